@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"regexp"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -16,40 +17,100 @@ import (
 	"golang.org/x/exp/slices"
 )
 
-// getAWSClientConfig returns an aws.Config to be used on clients.
+// listRolesPageSize is the number of roles requested per page, which is the maximum allowed by AWS IAM
+const listRolesPageSize int32 = 1000
+
+// awsClientSet holds AWS SDK configuration and clients of the account this application runs in, for a single region.
+// It is created once per region and reused by all reconciliations, as credentials of the configuration are cached and
+// refreshed by the SDK.
+type awsClientSet struct {
+	config aws.Config
+	iam    *iam.Client
+	sts    *sts.Client
+	imds   *imds.Client
+
+	// partition is the AWS partition detected from the caller identity by getAccountId, or empty string until then
+	partition string
+}
+
+var (
+	// awsClients holds AWS clients keyed by region, as pipelines may run against different regions
+	awsClients   = map[string]*awsClientSet{}
+	awsClientsMu sync.Mutex
+)
+
+// getAWSClients returns AWS clients of the account this application runs in, creating them on first use.
 //
-// It initializes the AWS SDK and creates an Amazon client configuration.
-// It takes no parameters and returns a aws.Config and an error.
-func getAWSClientConfig() (aws.Config, error) {
+// It takes the context used to load SDK configuration and the region of the clients, and returns a *awsClientSet and an error.
+func getAWSClients(ctx context.Context, region string) (*awsClientSet, error) {
+	awsClientsMu.Lock()
+	defer awsClientsMu.Unlock()
+
+	if clients, ok := awsClients[region]; ok {
+		return clients, nil
+	}
+
 	// Initialize AWS SDK
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(defaultAWSRegion))
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
 	if err != nil {
-		return cfg, err
+		return nil, err
 	}
 
-	return cfg, nil
+	awsClients[region] = &awsClientSet{
+		config: cfg,
+		iam:    iam.NewFromConfig(cfg),
+		sts:    sts.NewFromConfig(cfg),
+		imds:   imds.NewFromConfig(cfg),
+	}
+	return awsClients[region], nil
 }
 
-// listSSORoles retrieves a list of IAM roles that are used by AWS SSO service.
+// getAWSClientConfig returns an aws.Config to be used on clients.
 //
-// This function does not take any parameters.
-// It returns a slice of types.Role and an error.
-func listSSORoles() ([]types.Role, error) {
+// It returns configuration shared by all clients of the region, which is loaded once by getAWSClients.
+// It takes the context used to load SDK configuration and the region of the clients, and returns a aws.Config and an error.
+func getAWSClientConfig(ctx context.Context, region string) (aws.Config, error) {
+	clients, err := getAWSClients(ctx, region)
+	if err != nil {
+		return aws.Config{}, err
+	}
 
-	var pathPrefix = "/aws-reserved/sso.amazonaws.com/"
-	var pageSize int32 = 10
+	return clients.config, nil
+}
+
+// listSSORoles retrieves a list of IAM roles that are used by AWS SSO service.
+//
+// Roles are served from cache while it is younger than -role-cache-ttl.
+// It takes the context of the API calls and the region of IAM client, and returns a slice of types.Role and an error.
+func listSSORoles(ctx context.Context, region string) ([]types.Role, error) {
 
 	logger.Info("Retrieving SSO roles from AWS IAM...")
 
-	cfg, err := getAWSClientConfig()
+	roles, err := cachedSSORoles(localRoleCacheKey(region), func() ([]types.Role, error) {
+		clients, err := getAWSClients(ctx, region)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load SDK config: %w", err)
+		}
+		return paginateSSORoles(ctx, clients.iam)
+	})
 	if err != nil {
-		logger.Fatal("Unable to load SDK config, %v", zap.Error(err))
+		return roles, err
 	}
-	client := iam.NewFromConfig(cfg)
+
+	logger.Info(fmt.Sprintf("%d SSO roles retrieved from AWS IAM", len(roles)))
+	return roles, nil
+}
+
+// paginateSSORoles lists all roles created by AWS SSO using the given IAM client.
+//
+// It takes the context of the API calls and an IAM client, and returns a slice of types.Role and an error.
+func paginateSSORoles(ctx context.Context, client iam.ListRolesAPIClient) ([]types.Role, error) {
+
+	var pathPrefix = "/aws-reserved/sso.amazonaws.com/"
 
 	// Create a list roles request
 	params := &iam.ListRolesInput{
-		MaxItems:   aws.Int32(10),
+		MaxItems:   aws.Int32(listRolesPageSize),
 		PathPrefix: aws.String(pathPrefix),
 	}
 
@@ -57,7 +118,7 @@ func listSSORoles() ([]types.Role, error) {
 	paginator := iam.NewListRolesPaginator(
 		client,
 		params,
-		func(o *iam.ListRolesPaginatorOptions) { o.Limit = pageSize },
+		func(o *iam.ListRolesPaginatorOptions) { o.Limit = listRolesPageSize },
 	)
 
 	// Paginate through IAM Roles
@@ -65,7 +126,7 @@ func listSSORoles() ([]types.Role, error) {
 	var roles []types.Role
 	for paginator.HasMorePages() {
 		logger.Debug(fmt.Sprintf("Paginating through IAM Roles (page %d)...", (pageNum + 1)))
-		output, err := paginator.NextPage(context.TODO())
+		output, err := paginator.NextPage(ctx)
 		if err != nil {
 			logger.Error("Error occurred while paginating through roles", zap.Error(err))
 			return roles, err
@@ -73,7 +134,6 @@ func listSSORoles() ([]types.Role, error) {
 		roles = append(roles, output.Roles...)
 		pageNum++
 	}
-	logger.Info(fmt.Sprintf("%d SSO roles retrieved from AWS IAM", len(roles)))
 	return roles, nil
 }
 
@@ -86,10 +146,8 @@ func translatePermissionSetNameToARN(mapping SSORoleMapping, iamRoles []types.Ro
 	logger.Debug(fmt.Sprintf("Translating %s permission set to ARN", mapping.PermissionSet))
 
 	// Create a regex matchet to find a role by permission set name ("AWSReservedSSO_devops_07572db8b73986b8")
-	r, err := regexp.Compile(fmt.Sprintf("^AWSReservedSSO_%s_[[:alnum:]]{16}$", mapping.PermissionSet))
-	if err != nil {
-		panic(err)
-	}
+	// Permission set name is quoted, so that characters like "." or "+" allowed in it are matched literally
+	r := regexp.MustCompile(fmt.Sprintf("^AWSReservedSSO_%s_[[:alnum:]]{16}$", regexp.QuoteMeta(mapping.PermissionSet)))
 
 	// Get index of IAM role matching permission set name. If permission set name is not found - return error
 	idx := slices.IndexFunc(iamRoles, func(role types.Role) bool { return r.Match([]byte(*role.RoleName)) })
@@ -120,47 +178,53 @@ func removePathFromRoleARN(arn string, path string) string {
 	return r.ReplaceAllString(arn, "/")
 }
 
-// Get AWS account ID
-func getAccountId() (string, error) {
+// Get AWS account ID, using STS client of the given region
+func getAccountId(ctx context.Context, region string) (string, error) {
 	logger.Debug("Reading AWS Account ID...")
 
-	cfg, err := getAWSClientConfig()
+	clients, err := getAWSClients(ctx, region)
 	if err != nil {
-		logger.Fatal("Unable to load SDK config, %v", zap.Error(err))
+		return "", fmt.Errorf("unable to load SDK config: %w", err)
 	}
 
-	client := sts.NewFromConfig(cfg)
-	if err != nil {
-		logger.Fatal("Unable to load SDK config, %v", zap.Error(err))
-	}
 	input := &sts.GetCallerIdentityInput{}
 
-	req, err := client.GetCallerIdentity(context.TODO(), input)
+	req, err := clients.sts.GetCallerIdentity(ctx, input)
 	if err != nil {
 		return "", err
 	}
 
 	logger.Debug(fmt.Sprintf("Retrievied %s as AWS Account ID", *req.Account))
 
+	// Partition of caller identity is used to build ARNs of the account
+	partition, err := partitionFromARN(aws.ToString(req.Arn))
+	if err != nil {
+		return "", err
+	}
+	awsClientsMu.Lock()
+	clients.partition = partition
+	awsClientsMu.Unlock()
+
 	return *req.Account, nil
 }
 
-func getInstanceRole() string {
-	cfg, err := getAWSClientConfig()
+// getInstanceRole returns the name of IAM role attached to the EC2 instance, as reported by Instance Metadata Service.
+func getInstanceRole(ctx context.Context, region string) (string, error) {
+	clients, err := getAWSClients(ctx, region)
 	if err != nil {
-		logger.Fatal("Unable to load SDK config, %v", zap.Error(err))
+		return "", fmt.Errorf("unable to load SDK config: %w", err)
 	}
 
-	client := imds.NewFromConfig(cfg)
-	response, err := client.GetMetadata(context.TODO(), &imds.GetMetadataInput{Path: "iam/security-credentials"})
+	response, err := clients.imds.GetMetadata(ctx, &imds.GetMetadataInput{Path: "iam/security-credentials"})
 	if err != nil {
-		logger.Fatal("Unable to retrieve the private IP address from the EC2 instance: %v", zap.Error(err))
+		return "", fmt.Errorf("unable to retrieve IAM role from the EC2 instance metadata: %w", err)
 	}
+	defer response.Content.Close()
 
 	role, err := io.ReadAll(response.Content)
 	if err != nil {
-		logger.Fatal("Unable to read role name from response: %v", zap.Error(err))
+		return "", fmt.Errorf("unable to read role name from response: %w", err)
 	}
 
-	return string(role)
+	return string(role), nil
 }
@@ -91,4 +91,34 @@ func TestTranslatePermissionSetNameToARN(t *testing.T) {
 		}
 	})
 
+	// Test that regular expression characters of permission set name are matched literally
+	t.Run("Permission set name with special characters", func(t *testing.T) {
+		special := mapping
+		special.PermissionSet = "dev.ops+"
+		iamRoles := []types.Role{
+			{
+				RoleName: aws.String("AWSReservedSSO_devXopss_0123456789abcdef"),
+				Path:     aws.String("/"),
+				Arn:      aws.String("arn:aws:iam::123456789012:role/AWSReservedSSO_devXopss_0123456789abcdef"),
+			},
+			{
+				RoleName: aws.String("AWSReservedSSO_dev.ops+_0123456789abcdef"),
+				Path:     aws.String("/"),
+				Arn:      aws.String("arn:aws:iam::123456789012:role/AWSReservedSSO_dev.ops+_0123456789abcdef"),
+			},
+		}
+
+		got, err := translatePermissionSetNameToARN(special, iamRoles)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if want := "arn:aws:iam::123456789012:role/AWSReservedSSO_dev.ops+_0123456789abcdef"; got.RoleARN != want {
+			t.Errorf("translatePermissionSetNameToARN() resolved role %s, want %s", got.RoleARN, want)
+		}
+
+		special.PermissionSet = "dev(ops"
+		if _, err := translatePermissionSetNameToARN(special, iamRoles); err == nil {
+			t.Errorf("Got nil error, was expecting to get an error")
+		}
+	})
 }
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// backupOfLabel identifies backups of destination ConfigMap by its name
+	backupOfLabel = "aws-iam-authenticator-sso-wrapper.justinas-b.github.io/backup-of"
+
+	// backupOfNamespaceLabel identifies backups of destination ConfigMap by its namespace
+	backupOfNamespaceLabel = "aws-iam-authenticator-sso-wrapper.justinas-b.github.io/backup-of-namespace"
+
+	// backupRevisionLabel is the revision of a backup, which increases with every backup
+	backupRevisionLabel = "aws-iam-authenticator-sso-wrapper.justinas-b.github.io/revision"
+
+	// backupCreatedAnnotation is the time when a backup was created
+	backupCreatedAnnotation = "aws-iam-authenticator-sso-wrapper.justinas-b.github.io/backed-up-at"
+
+	// pausedAnnotation pauses reconciliation of destination ConfigMap while it is set, its value describes why
+	pausedAnnotation = "aws-iam-authenticator-sso-wrapper.justinas-b.github.io/paused"
+)
+
+const (
+	backupKindConfigMap = "configmap"
+	backupKindSecret    = "secret"
+)
+
+// destinationBackup is a snapshot of destination ConfigMap data taken before it was written
+type destinationBackup struct {
+	// Name is the name of ConfigMap or Secret holding the backup
+	Name string
+
+	// Revision is the revision of the backup, the latest backup has the highest revision
+	Revision int
+
+	// Created is the time when the backup was created
+	Created time.Time
+
+	// Data is the data of destination ConfigMap
+	Data map[string]string
+
+	// Annotations are the ownership annotations of destination ConfigMap
+	Annotations map[string]string
+}
+
+// backupName returns the name of ConfigMap or Secret holding the given revision of destination ConfigMap backup.
+//
+// Namespace of destination ConfigMap is part of the name, as pipelines may publish to ConfigMaps of the same name in
+// different namespaces while storing their backups in the same namespace.
+func backupName(destinationName string, destinationNamespace string, revision int) string {
+	return fmt.Sprintf("%s-%s-backup-%d", destinationNamespace, destinationName, revision)
+}
+
+// ownershipAnnotations returns those of the given annotations which list entries managed by this application.
+func ownershipAnnotations(annotations map[string]string) map[string]string {
+	ownership := map[string]string{}
+	for _, list := range managedLists {
+		if value, ok := annotations[list.annotation]; ok {
+			ownership[list.annotation] = value
+		}
+	}
+	return ownership
+}
+
+// listBackups returns backups of destination ConfigMap, sorted by revision from the oldest to the latest.
+//
+// Parameters:
+// - ctx: Context of the API calls.
+// - clientset: The Kubernetes clientset.
+// - namespaceName: The namespace where backups are stored.
+// - destinationName: The name of destination ConfigMap.
+// - destinationNamespace: The namespace of destination ConfigMap.
+//
+// Returns:
+// - []destinationBackup: The backups of destination ConfigMap.
+// - error: An error if backups could not be listed.
+func listBackups(ctx context.Context, clientset kubernetes.Interface, namespaceName string, destinationName string, destinationNamespace string) ([]destinationBackup, error) {
+	selector := labels.Set{backupOfLabel: destinationName, backupOfNamespaceLabel: destinationNamespace}
+	options := metav1.ListOptions{LabelSelector: labels.SelectorFromSet(selector).String()}
+
+	var objects []metav1.ObjectMeta
+	var data []map[string]string
+	switch backupKind {
+	case backupKindSecret:
+		secrets, err := clientset.CoreV1().Secrets(namespaceName).List(ctx, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list backup Secrets in namespace %s: %w", namespaceName, err)
+		}
+		for _, secret := range secrets.Items {
+			secretData := map[string]string{}
+			for key, value := range secret.Data {
+				secretData[key] = string(value)
+			}
+			objects, data = append(objects, secret.ObjectMeta), append(data, secretData)
+		}
+	default:
+		configMaps, err := clientset.CoreV1().ConfigMaps(namespaceName).List(ctx, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list backup ConfigMaps in namespace %s: %w", namespaceName, err)
+		}
+		for _, configMap := range configMaps.Items {
+			objects, data = append(objects, configMap.ObjectMeta), append(data, configMap.Data)
+		}
+	}
+
+	backups := []destinationBackup{}
+	for i, object := range objects {
+		revision, err := strconv.Atoi(object.Labels[backupRevisionLabel])
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Backup %s in namespace %s has invalid revision, ignoring it", object.Name, namespaceName), zap.Error(err))
+			continue
+		}
+		created, _ := time.Parse(time.RFC3339, object.Annotations[backupCreatedAnnotation])
+
+		backups = append(backups, destinationBackup{Name: object.Name, Revision: revision, Created: created, Data: data[i], Annotations: ownershipAnnotations(object.Annotations)})
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Revision < backups[j].Revision })
+	return backups, nil
+}
+
+// backupDestination snapshots data and ownership annotations of destination ConfigMap into a new backup, and deletes
+// the oldest backups exceeding -backup-retention. Nothing is done when retention is 0, or when the latest backup
+// already holds the same data and annotations, so that retries and restarts do not take the same backup again.
+//
+// Parameters:
+// - ctx: Context of the API calls.
+// - clientset: The Kubernetes clientset.
+// - namespaceName: The namespace where backups are stored.
+// - destination: The destination ConfigMap to back up.
+//
+// Returns:
+// - int: The revision of created or already existing backup, or 0 if backups are disabled.
+// - error: An error if the backup could not be created.
+func backupDestination(ctx context.Context, clientset kubernetes.Interface, namespaceName string, destination *v1.ConfigMap) (int, error) {
+	if backupRetention <= 0 {
+		return 0, nil
+	}
+
+	backups, err := listBackups(ctx, clientset, namespaceName, destination.Name, destination.Namespace)
+	if err != nil {
+		return 0, err
+	}
+
+	revision := 1
+	if len(backups) > 0 {
+		latest := backups[len(backups)-1]
+		if fingerprintConfigMapData(latest.Data) == fingerprintConfigMapData(destination.Data) && reflect.DeepEqual(latest.Annotations, ownershipAnnotations(destination.Annotations)) {
+			logger.Info(fmt.Sprintf("ConfigMap %s in namespace %s is already backed up as revision %d", destination.Name, destination.Namespace, latest.Revision))
+			return latest.Revision, nil
+		}
+		revision = latest.Revision + 1
+	}
+
+	object := metav1.ObjectMeta{
+		Name:      backupName(destination.Name, destination.Namespace, revision),
+		Namespace: namespaceName,
+		Labels: map[string]string{
+			backupOfLabel:          destination.Name,
+			backupOfNamespaceLabel: destination.Namespace,
+			backupRevisionLabel:    strconv.Itoa(revision),
+		},
+		Annotations: ownershipAnnotations(destination.Annotations),
+	}
+	object.Annotations[backupCreatedAnnotation] = time.Now().UTC().Format(time.RFC3339)
+
+	switch backupKind {
+	case backupKindSecret:
+		secret := &v1.Secret{ObjectMeta: object, Data: map[string][]byte{}}
+		for key, value := range destination.Data {
+			secret.Data[key] = []byte(value)
+		}
+		_, err = clientset.CoreV1().Secrets(namespaceName).Create(ctx, secret, metav1.CreateOptions{FieldManager: fieldManager})
+	default:
+		configMap := &v1.ConfigMap{ObjectMeta: object, Data: destination.Data}
+		_, err = clientset.CoreV1().ConfigMaps(namespaceName).Create(ctx, configMap, metav1.CreateOptions{FieldManager: fieldManager})
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to create backup %s in namespace %s: %w", object.Name, namespaceName, err)
+	}
+	logger.Info(fmt.Sprintf("Backed up ConfigMap %s in namespace %s as revision %d", destination.Name, destination.Namespace, revision))
+
+	// Delete the oldest backups, so that only the latest ones are retained
+	for len(backups)+1 > backupRetention {
+		if err := deleteBackup(ctx, clientset, namespaceName, backups[0].Name); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to delete backup %s in namespace %s", backups[0].Name, namespaceName), zap.Error(err))
+		}
+		backups = backups[1:]
+	}
+
+	return revision, nil
+}
+
+// deleteBackup deletes ConfigMap or Secret holding a backup.
+func deleteBackup(ctx context.Context, clientset kubernetes.Interface, namespaceName string, name string) error {
+	if backupKind == backupKindSecret {
+		return clientset.CoreV1().Secrets(namespaceName).Delete(ctx, name, metav1.DeleteOptions{})
+	}
+	return clientset.CoreV1().ConfigMaps(namespaceName).Delete(ctx, name, metav1.DeleteOptions{})
+}
+
+// rollbackDestination restores data and ownership annotations of destination ConfigMap from a backup, and pauses its
+// reconciliation until it is resumed. Current data of destination ConfigMap is backed up beforehand, so that rollback
+// can be reverted too.
+//
+// Parameters:
+// - ctx: Context of the API calls.
+// - clientset: The Kubernetes clientset.
+// - namespaceName: The namespace where backups are stored.
+// - destinationName: The name of destination ConfigMap.
+// - destinationNamespace: The namespace of destination ConfigMap.
+// - revision: The revision of backup to restore.
+//
+// Returns:
+// - error: An error if the backup does not exist or destination ConfigMap could not be written.
+func rollbackDestination(ctx context.Context, clientset kubernetes.Interface, namespaceName string, destinationName string, destinationNamespace string, revision int) error {
+	backups, err := listBackups(ctx, clientset, namespaceName, destinationName, destinationNamespace)
+	if err != nil {
+		return err
+	}
+
+	var backup *destinationBackup
+	for i := range backups {
+		if backups[i].Revision == revision {
+			backup = &backups[i]
+		}
+	}
+	if backup == nil {
+		return fmt.Errorf("revision %d of ConfigMap %s is not found in namespace %s", revision, destinationName, namespaceName)
+	}
+
+	destination, err := getConfigMap(ctx, clientset, destinationName, destinationNamespace)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	} else if err == nil {
+		if _, err := backupDestination(ctx, clientset, namespaceName, destination); err != nil {
+			return err
+		}
+	}
+
+	annotations := map[string]string{pausedAnnotation: fmt.Sprintf("rolled back to revision %d", revision)}
+	for key, value := range backup.Annotations {
+		annotations[key] = value
+	}
+
+	return setConfigMap(ctx, clientset, destinationName, destinationNamespace, backup.Data, annotations, "")
+}
+
+// resumeDestination removes pause annotation from destination ConfigMap, keeping its data and ownership annotations.
+//
+// Parameters:
+// - ctx: Context of the API calls.
+// - clientset: The Kubernetes clientset.
+// - destinationName: The name of destination ConfigMap.
+// - destinationNamespace: The namespace of destination ConfigMap.
+//
+// Returns:
+// - bool: Whether reconciliation was paused.
+// - error: An error if destination ConfigMap could not be read or written.
+func resumeDestination(ctx context.Context, clientset kubernetes.Interface, destinationName string, destinationNamespace string) (bool, error) {
+	destination, err := getConfigMap(ctx, clientset, destinationName, destinationNamespace)
+	if err != nil {
+		return false, err
+	}
+	if _, paused := destination.Annotations[pausedAnnotation]; !paused {
+		return false, nil
+	}
+
+	return true, setConfigMap(ctx, clientset, destinationName, destinationNamespace, destination.Data, ownershipAnnotations(destination.Annotations), destination.ResourceVersion)
+}
+
+// getBackupNamespace returns the namespace where backups of the pipeline are stored, which defaults to its source namespace.
+func getBackupNamespace(p *pipeline) (string, error) {
+	if backupNamespaceName != "" {
+		return backupNamespaceName, nil
+	}
+	return p.sourceNamespace()
+}
+
+// runRollback implements the rollback subcommand, which restores a backup of destination ConfigMap and pauses its
+// reconciliation. When no revision is given, available backups are listed instead.
+//
+// It uses the same flags as reconciliation, which must be parsed beforehand. Destination ConfigMap is the one of
+// the pipeline selected by -pipeline flag.
+//
+// Parameters:
+// - ctx: Context of the API calls, cancelled on SIGTERM/SIGINT.
+// - revision: The revision of backup to restore, or 0 to list backups.
+// - stdout: The writer to which the outcome is written.
+//
+// Returns:
+// - int: The exit code, 0 on success and 2 on failure.
+func runRollback(ctx context.Context, revision int, stdout io.Writer) int {
+	p, err := selectPipeline(pipelineName)
+	if err != nil {
+		logger.Error("Failed to select pipeline", zap.Error(err))
+		return 2
+	}
+
+	clientset, err := getKubernetesClientSet()
+	if err != nil {
+		logger.Error("Failed to create Kubernetes clientset", zap.Error(err))
+		return 2
+	}
+
+	namespaceName, err := getBackupNamespace(p)
+	if err != nil {
+		logger.Error("Failed to determine namespace of backups", zap.Error(err))
+		return 2
+	}
+
+	if revision == 0 {
+		backups, err := listBackups(ctx, clientset, namespaceName, p.DestinationConfigMap, p.DestinationNamespace)
+		if err != nil {
+			logger.Error("Failed to list backups", zap.Error(err))
+			return 2
+		}
+		fmt.Fprintln(stdout, "REVISION\tBACKED UP AT\tNAME")
+		for _, backup := range backups {
+			fmt.Fprintf(stdout, "%d\t%s\t%s\n", backup.Revision, backup.Created.Format(time.RFC3339), backup.Name)
+		}
+		return 0
+	}
+
+	if err := rollbackDestination(ctx, clientset, namespaceName, p.DestinationConfigMap, p.DestinationNamespace, revision); err != nil {
+		logger.Error("Failed to roll back destination ConfigMap", zap.Error(err))
+		return 2
+	}
+
+	fmt.Fprintf(stdout, "ConfigMap %s in namespace %s was rolled back to revision %d, reconciliation is paused until resume subcommand is run\n", p.DestinationConfigMap, p.DestinationNamespace, revision)
+	return 0
+}
+
+// runResume implements the resume subcommand, which resumes reconciliation of destination ConfigMap paused by rollback.
+//
+// It uses the same flags as reconciliation, which must be parsed beforehand. Destination ConfigMap is the one of
+// the pipeline selected by -pipeline flag.
+//
+// Parameters:
+// - ctx: Context of the API calls, cancelled on SIGTERM/SIGINT.
+// - stdout: The writer to which the outcome is written.
+//
+// Returns:
+// - int: The exit code, 0 on success and 2 on failure.
+func runResume(ctx context.Context, stdout io.Writer) int {
+	p, err := selectPipeline(pipelineName)
+	if err != nil {
+		logger.Error("Failed to select pipeline", zap.Error(err))
+		return 2
+	}
+
+	clientset, err := getKubernetesClientSet()
+	if err != nil {
+		logger.Error("Failed to create Kubernetes clientset", zap.Error(err))
+		return 2
+	}
+
+	paused, err := resumeDestination(ctx, clientset, p.DestinationConfigMap, p.DestinationNamespace)
+	if err != nil {
+		logger.Error("Failed to resume reconciliation of destination ConfigMap", zap.Error(err))
+		return 2
+	}
+
+	if !paused {
+		fmt.Fprintf(stdout, "Reconciliation of ConfigMap %s in namespace %s is not paused\n", p.DestinationConfigMap, p.DestinationNamespace)
+		return 0
+	}
+	fmt.Fprintf(stdout, "Reconciliation of ConfigMap %s in namespace %s was resumed\n", p.DestinationConfigMap, p.DestinationNamespace)
+	return 0
+}
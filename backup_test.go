@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// useBackupSettings sets backup flags for the duration of the test
+func useBackupSettings(t *testing.T, retention int, kind string) {
+	originalRetention, originalKind := backupRetention, backupKind
+	t.Cleanup(func() {
+		backupRetention, backupKind = originalRetention, originalKind
+	})
+	backupRetention, backupKind = retention, kind
+}
+
+func TestBackupDestination(t *testing.T) {
+	destination := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "aws-auth",
+			Namespace:   "kube-system",
+			Annotations: map[string]string{managedRolesAnnotation: `["arn"]`, "note": "skip"},
+		},
+		Data: map[string]string{"mapRoles": "- rolearn: arn\n"},
+	}
+
+	// Test that only the latest backups are retained, for both kinds of backup objects
+	for _, kind := range []string{backupKindConfigMap, backupKindSecret} {
+		t.Run("Retention of "+kind, func(t *testing.T) {
+			useBackupSettings(t, 2, kind)
+			clientset := fake.NewSimpleClientset()
+
+			var latest *v1.ConfigMap
+			for want := 1; want <= 3; want++ {
+				latest = destination.DeepCopy()
+				latest.Data = map[string]string{"mapRoles": fmt.Sprintf("- rolearn: arn-%d\n", want)}
+				revision, err := backupDestination(context.Background(), clientset, "TEST_NAMESPACE", latest)
+				if err != nil {
+					t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+				}
+				if revision != want {
+					t.Errorf("backupDestination() created revision %d, want %d", revision, want)
+				}
+			}
+
+			backups, err := listBackups(context.Background(), clientset, "TEST_NAMESPACE", destination.Name, destination.Namespace)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+			}
+			if len(backups) != 2 || backups[0].Revision != 2 || backups[1].Revision != 3 {
+				t.Fatalf("listBackups() returned unexpected backups: %+v", backups)
+			}
+			if !reflect.DeepEqual(backups[1].Data, latest.Data) {
+				t.Errorf("Backup has unexpected data: %+v, want %+v", backups[1].Data, latest.Data)
+			}
+			if want := map[string]string{managedRolesAnnotation: `["arn"]`}; !reflect.DeepEqual(backups[1].Annotations, want) {
+				t.Errorf("Backup has unexpected annotations: %+v, want %+v", backups[1].Annotations, want)
+			}
+		})
+	}
+
+	// Test that unchanged destination is not backed up again
+	t.Run("Destination already backed up", func(t *testing.T) {
+		useBackupSettings(t, 5, backupKindConfigMap)
+		clientset := fake.NewSimpleClientset()
+
+		changed := destination.DeepCopy()
+		changed.Data = map[string]string{"mapRoles": "- rolearn: other\n"}
+		for i, cm := range []*v1.ConfigMap{destination, destination, changed, changed} {
+			revision, err := backupDestination(context.Background(), clientset, "TEST_NAMESPACE", cm)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+			}
+			if want := i/2 + 1; revision != want {
+				t.Errorf("backupDestination() returned revision %d, want %d", revision, want)
+			}
+		}
+
+		backups, err := listBackups(context.Background(), clientset, "TEST_NAMESPACE", destination.Name, destination.Namespace)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if len(backups) != 2 {
+			t.Errorf("listBackups() returned %d backups, want 2", len(backups))
+		}
+	})
+
+	// Test that backups of ConfigMaps sharing a name in different namespaces are kept apart
+	t.Run("Destinations of the same name", func(t *testing.T) {
+		useBackupSettings(t, 2, backupKindConfigMap)
+		clientset := fake.NewSimpleClientset()
+
+		other := destination.DeepCopy()
+		other.Namespace = "team-a"
+		for _, cm := range []*v1.ConfigMap{destination, other, other} {
+			if _, err := backupDestination(context.Background(), clientset, "TEST_NAMESPACE", cm); err != nil {
+				t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+			}
+		}
+
+		backups, err := listBackups(context.Background(), clientset, "TEST_NAMESPACE", destination.Name, destination.Namespace)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if len(backups) != 1 || backups[0].Name != "kube-system-aws-auth-backup-1" {
+			t.Errorf("listBackups() returned unexpected backups: %+v", backups)
+		}
+	})
+
+	// Test that no backup is created when backups are disabled
+	t.Run("Backups disabled", func(t *testing.T) {
+		useBackupSettings(t, 0, backupKindConfigMap)
+		clientset := fake.NewSimpleClientset()
+
+		if _, err := backupDestination(context.Background(), clientset, "TEST_NAMESPACE", destination); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		configMaps, err := clientset.CoreV1().ConfigMaps("TEST_NAMESPACE").List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if len(configMaps.Items) != 0 {
+			t.Errorf("backupDestination() created unexpected objects: %+v", configMaps.Items)
+		}
+	})
+}
+
+func TestRollbackDestination(t *testing.T) {
+	useBackupSettings(t, 5, backupKindConfigMap)
+	clientset := fake.NewClientset()
+
+	good := map[string]string{"mapRoles": "- rolearn: good\n"}
+	bad := map[string]string{"mapRoles": "- rolearn: bad\n"}
+
+	// Publish good data, back it up and replace it with bad data, as reconciliation does
+	if err := setConfigMap(context.Background(), clientset, "aws-auth", "kube-system", good, map[string]string{managedRolesAnnotation: `["good"]`}, ""); err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+	destination, err := getConfigMap(context.Background(), clientset, "aws-auth", "kube-system")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+	if _, err := backupDestination(context.Background(), clientset, "TEST_NAMESPACE", destination); err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+	if err := setConfigMap(context.Background(), clientset, "aws-auth", "kube-system", bad, map[string]string{managedRolesAnnotation: `["bad"]`}, ""); err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+
+	// Test that revision which does not exist is not restored
+	t.Run("Revision does not exist", func(t *testing.T) {
+		if err := rollbackDestination(context.Background(), clientset, "TEST_NAMESPACE", "aws-auth", "kube-system", 42); err == nil {
+			t.Errorf("rollbackDestination() returned nil, was expecting to get an error")
+		}
+	})
+
+	// Test that backup is restored and reconciliation is paused
+	t.Run("Rollback", func(t *testing.T) {
+		if err := rollbackDestination(context.Background(), clientset, "TEST_NAMESPACE", "aws-auth", "kube-system", 1); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		got, err := getConfigMap(context.Background(), clientset, "aws-auth", "kube-system")
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if !reflect.DeepEqual(got.Data, good) {
+			t.Errorf("rollbackDestination() restored unexpected data: %+v, want %+v", got.Data, good)
+		}
+		if got.Annotations[managedRolesAnnotation] != `["good"]` {
+			t.Errorf("rollbackDestination() restored unexpected ownership: %s", got.Annotations[managedRolesAnnotation])
+		}
+		if _, paused := got.Annotations[pausedAnnotation]; !paused {
+			t.Errorf("rollbackDestination() did not pause reconciliation")
+		}
+
+		// Data which was rolled back is backed up too, so that rollback can be reverted
+		backups, err := listBackups(context.Background(), clientset, "TEST_NAMESPACE", "aws-auth", "kube-system")
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if len(backups) != 2 || !reflect.DeepEqual(backups[1].Data, bad) {
+			t.Errorf("rollbackDestination() did not back up current data: %+v", backups)
+		}
+	})
+
+	// Test that resume removes pause annotation and keeps restored data
+	t.Run("Resume", func(t *testing.T) {
+		paused, err := resumeDestination(context.Background(), clientset, "aws-auth", "kube-system")
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if !paused {
+			t.Errorf("resumeDestination() reported reconciliation was not paused")
+		}
+
+		got, err := getConfigMap(context.Background(), clientset, "aws-auth", "kube-system")
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if _, paused := got.Annotations[pausedAnnotation]; paused {
+			t.Errorf("resumeDestination() did not remove pause annotation: %+v", got.Annotations)
+		}
+		if !reflect.DeepEqual(got.Data, good) || got.Annotations[managedRolesAnnotation] != `["good"]` {
+			t.Errorf("resumeDestination() changed restored ConfigMap: %+v", got)
+		}
+
+		if paused, err := resumeDestination(context.Background(), clientset, "aws-auth", "kube-system"); err != nil || paused {
+			t.Errorf("resumeDestination() = %t, %v, was expecting to get false, nil", paused, err)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+)
+
+// localRoleCacheKey returns the key of cached SSO roles of the account this application runs in. Roles of IAM are
+// shared by all regions of a partition, so they are only cached once per partition.
+func localRoleCacheKey(region string) string {
+	return partitionForRegion(region)
+}
+
+// roleCacheEntry holds SSO roles of a single account together with the time they were retrieved
+type roleCacheEntry struct {
+	roles     []types.Role
+	retrieved time.Time
+}
+
+// permissionSetNameCacheEntry holds name of a permission set together with the time it was retrieved
+type permissionSetNameCacheEntry struct {
+	name      string
+	retrieved time.Time
+}
+
+var (
+	// roleCache holds SSO roles keyed by account ID, or by localRoleCacheKey for the account this application runs in
+	roleCache   = map[string]roleCacheEntry{}
+	roleCacheMu sync.Mutex
+
+	// permissionSetNameCache holds names of permission sets keyed by their ARNs
+	permissionSetNameCache   = map[string]permissionSetNameCacheEntry{}
+	permissionSetNameCacheMu sync.Mutex
+)
+
+// cachedSSORoles returns SSO roles of the account from cache, or retrieves them using list function if cache
+// entry is older than -role-cache-ttl.
+//
+// Parameters:
+// - key: The cache key of the account.
+// - list: The function retrieving SSO roles of the account from AWS IAM.
+//
+// Returns:
+// - []types.Role: The SSO roles of the account.
+// - error: An error if roles were not cached and could not be retrieved.
+func cachedSSORoles(key string, list func() ([]types.Role, error)) ([]types.Role, error) {
+	roleCacheMu.Lock()
+	defer roleCacheMu.Unlock()
+
+	if entry, ok := roleCache[key]; ok && time.Since(entry.retrieved) < roleCacheTTL {
+		logger.Debug("Using cached SSO roles", zap.String("account", key), zap.Duration("age", time.Since(entry.retrieved)))
+		return entry.roles, nil
+	}
+
+	roles, err := list()
+	if err != nil {
+		return roles, err
+	}
+
+	if roleCacheTTL > 0 {
+		roleCache[key] = roleCacheEntry{roles: roles, retrieved: time.Now()}
+	}
+	return roles, nil
+}
+
+// cachedPermissionSetName returns name of the permission set from cache, or retrieves it using describe function if
+// cache entry is older than -role-cache-ttl.
+//
+// Parameters:
+// - arn: The ARN of the permission set.
+// - describe: The function retrieving name of the permission set from AWS SSO Admin.
+//
+// Returns:
+// - string: The name of the permission set.
+// - error: An error if name was not cached and could not be retrieved.
+func cachedPermissionSetName(arn string, describe func() (string, error)) (string, error) {
+	permissionSetNameCacheMu.Lock()
+	defer permissionSetNameCacheMu.Unlock()
+
+	if entry, ok := permissionSetNameCache[arn]; ok && time.Since(entry.retrieved) < roleCacheTTL {
+		return entry.name, nil
+	}
+
+	name, err := describe()
+	if err != nil {
+		return name, err
+	}
+
+	if roleCacheTTL > 0 {
+		permissionSetNameCache[arn] = permissionSetNameCacheEntry{name: name, retrieved: time.Now()}
+	}
+	return name, nil
+}
+
+// invalidateRoleCache removes all cached SSO roles and permission set names, so that they are retrieved again during
+// the next reconciliation.
+func invalidateRoleCache() {
+	roleCacheMu.Lock()
+	roleCache = map[string]roleCacheEntry{}
+	roleCacheMu.Unlock()
+
+	permissionSetNameCacheMu.Lock()
+	permissionSetNameCache = map[string]permissionSetNameCacheEntry{}
+	permissionSetNameCacheMu.Unlock()
+}
+
+// fingerprintConfigMapData returns a fingerprint of ConfigMap data, which changes whenever any of its keys or values change.
+func fingerprintConfigMapData(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		// Lengths are included so that boundaries between keys and values can not be shifted
+		hash.Write([]byte(fmt.Sprintf("%d:%s%d:%s", len(key), key, len(data[key]), data[key])))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// destinationUpToDate checks whether destination ConfigMap already holds data with the given fingerprint, which
+// was written by the last successful reconciliation and has not been modified since.
+//
+// Parameters:
+// - current: The data of destination ConfigMap, or nil if it does not exist.
+// - fingerprint: The fingerprint of desired data of destination ConfigMap.
+// - lastFingerprint: The fingerprint of data written by the last successful reconciliation of the pipeline.
+//
+// Returns:
+// - bool: True if update of destination ConfigMap can be skipped.
+func destinationUpToDate(current map[string]string, fingerprint string, lastFingerprint string) bool {
+	return current != nil && fingerprint == lastFingerprint && fingerprintConfigMapData(current) == fingerprint
+}
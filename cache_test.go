@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+func TestCachedSSORoles(t *testing.T) {
+	originalTTL := roleCacheTTL
+	t.Cleanup(func() {
+		roleCacheTTL = originalTTL
+		invalidateRoleCache()
+	})
+
+	calls := 0
+	list := func() ([]types.Role, error) {
+		calls++
+		return []types.Role{newSSORole("devops", "0123456789abcdef")}, nil
+	}
+
+	// Test that roles are not cached when TTL is 0
+	roleCacheTTL = 0
+	cachedSSORoles(localRoleCacheKey(defaultAWSRegion), list)
+	cachedSSORoles(localRoleCacheKey(defaultAWSRegion), list)
+	if calls != 2 {
+		t.Errorf("Roles were listed %d times with caching disabled, want 2", calls)
+	}
+
+	// Test that roles are served from cache until TTL expires or cache is invalidated
+	calls = 0
+	roleCacheTTL = time.Hour
+	cachedSSORoles(localRoleCacheKey(defaultAWSRegion), list)
+	roles, err := cachedSSORoles(localRoleCacheKey(defaultAWSRegion), list)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+	if calls != 1 || len(roles) != 1 {
+		t.Errorf("Roles were listed %d times and %d roles returned, want 1 and 1", calls, len(roles))
+	}
+
+	cachedSSORoles("111111111111", list)
+	if calls != 2 {
+		t.Errorf("Roles of other account were served from cache of local account")
+	}
+
+	invalidateRoleCache()
+	cachedSSORoles(localRoleCacheKey(defaultAWSRegion), list)
+	if calls != 3 {
+		t.Errorf("Roles were served from cache after it was invalidated")
+	}
+}
+
+func TestCachedPermissionSetName(t *testing.T) {
+	originalTTL := roleCacheTTL
+	t.Cleanup(func() {
+		roleCacheTTL = originalTTL
+		invalidateRoleCache()
+	})
+
+	calls := 0
+	describe := func() (string, error) {
+		calls++
+		return "devops", nil
+	}
+	const arn = "arn:aws:sso:::permissionSet/ssoins-0000000000000000/ps-0000000000000001"
+
+	// Test that names are not cached when TTL is 0
+	roleCacheTTL = 0
+	cachedPermissionSetName(arn, describe)
+	cachedPermissionSetName(arn, describe)
+	if calls != 2 {
+		t.Errorf("Permission set was described %d times with caching disabled, want 2", calls)
+	}
+
+	// Test that names are served from cache until cache is invalidated
+	calls = 0
+	roleCacheTTL = time.Hour
+	cachedPermissionSetName(arn, describe)
+	name, err := cachedPermissionSetName(arn, describe)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+	if calls != 1 || name != "devops" {
+		t.Errorf("Permission set was described %d times and named %q, want 1 and %q", calls, name, "devops")
+	}
+
+	invalidateRoleCache()
+	cachedPermissionSetName(arn, describe)
+	if calls != 2 {
+		t.Errorf("Permission set name was served from cache after it was invalidated")
+	}
+}
+
+func TestFingerprintConfigMapData(t *testing.T) {
+	data := map[string]string{"mapRoles": "- rolearn: arn\n", "mapUsers": "[]\n"}
+
+	if fingerprintConfigMapData(data) != fingerprintConfigMapData(map[string]string{"mapUsers": "[]\n", "mapRoles": "- rolearn: arn\n"}) {
+		t.Errorf("Fingerprint depends on order of keys")
+	}
+	if fingerprintConfigMapData(data) == fingerprintConfigMapData(map[string]string{"mapRoles": "- rolearn: arn2\n", "mapUsers": "[]\n"}) {
+		t.Errorf("Fingerprint did not change when value changed")
+	}
+	if fingerprintConfigMapData(map[string]string{"ab": "c"}) == fingerprintConfigMapData(map[string]string{"a": "bc"}) {
+		t.Errorf("Fingerprint did not change when boundary between key and value shifted")
+	}
+}
+
+func TestDestinationUpToDate(t *testing.T) {
+	desired := map[string]string{"mapRoles": "- rolearn: arn\n"}
+	fingerprint := fingerprintConfigMapData(desired)
+
+	if destinationUpToDate(desired, fingerprint, "") {
+		t.Errorf("Destination is up to date before it was written")
+	}
+
+	if !destinationUpToDate(desired, fingerprint, fingerprint) {
+		t.Errorf("Destination is not up to date after it was written")
+	}
+	if destinationUpToDate(map[string]string{"mapRoles": "[]\n"}, fingerprint, fingerprint) {
+		t.Errorf("Destination is up to date after it was modified")
+	}
+	if destinationUpToDate(nil, fingerprint, fingerprint) {
+		t.Errorf("Destination is up to date after it was deleted")
+	}
+}
@@ -15,3 +15,143 @@ We truncate at 63 chars because some Kubernetes name fields are limited to this
 {{- $name := default "__CHART__" .Values.nameOverride -}}
 {{printf "%s-%s" .Release.Name $name | trunc 63 | trimSuffix "-" -}}
 {{end}}
+
+{{/*
+Leader election is required whenever more than one replica is running.
+*/}}
+{{- define "aws-iam-authenticator-sso-wrapper.leaderElection" -}}
+{{- if or .Values.deployment.applicationArguments.leaderElection.enabled (gt (int .Values.deployment.replicas) 1) -}}
+true
+{{- end -}}
+{{- end -}}
+
+{{/*
+SSORoleMapping custom resources are read whenever they are enabled by enableCrd argument or by any of pipelines.
+*/}}
+{{- define "aws-iam-authenticator-sso-wrapper.crdEnabled" -}}
+{{- $enabled := .Values.deployment.applicationArguments.enableCrd -}}
+{{- range .Values.deployment.applicationArguments.pipelines -}}
+{{- if .enableCrd -}}
+{{- $enabled = true -}}
+{{- end -}}
+{{- end -}}
+{{- if $enabled -}}
+true
+{{- end -}}
+{{- end -}}
+
+{{/*
+Namespaces in which pipelines select source ConfigMaps by label, comma separated. Pipelines without srcNamespaces
+select them in their srcNamespace, or in all namespaces, see selectorAllNamespaces.
+*/}}
+{{- define "aws-iam-authenticator-sso-wrapper.selectorNamespaces" -}}
+{{- $namespaces := list -}}
+{{- range (include "aws-iam-authenticator-sso-wrapper.sources" . | fromJsonArray) -}}
+{{- if .selector -}}
+{{- range .namespaces -}}
+{{- $namespaces = append $namespaces . -}}
+{{- end -}}
+{{- end -}}
+{{- end -}}
+{{- $namespaces | uniq | join "," -}}
+{{- end -}}
+
+{{/*
+Any pipeline selecting source ConfigMaps by label without srcNamespaces and srcNamespace selects them in all namespaces.
+*/}}
+{{- define "aws-iam-authenticator-sso-wrapper.selectorAllNamespaces" -}}
+{{- $all := false -}}
+{{- range (include "aws-iam-authenticator-sso-wrapper.sources" . | fromJsonArray) -}}
+{{- if and .selector (not .namespaces) -}}
+{{- $all = true -}}
+{{- end -}}
+{{- end -}}
+{{- if $all -}}
+true
+{{- end -}}
+{{- end -}}
+
+{{/*
+Source ConfigMaps of pipelines, or of the single pipeline defined by values above. Every source holds namespaces it is
+read from, which are empty when ConfigMaps are selected in all namespaces, and either name of ConfigMap or label selector
+of ConfigMaps.
+*/}}
+{{- define "aws-iam-authenticator-sso-wrapper.sources" -}}
+{{- $args := .Values.deployment.applicationArguments -}}
+{{- $sources := list -}}
+{{- range (default (list dict) $args.pipelines) -}}
+{{- $selector := default $args.srcSelector .srcSelector -}}
+{{- if $selector -}}
+{{- $namespaces := default $args.srcNamespaces .srcNamespaces -}}
+{{- if and (not .srcNamespaces) .srcNamespace -}}
+{{- $namespaces = list .srcNamespace -}}
+{{- end -}}
+{{- $sources = append $sources (dict "namespaces" $namespaces "selector" $selector) -}}
+{{- else -}}
+{{- $sources = append $sources (dict "namespaces" (list (default $.Release.Namespace .srcNamespace)) "name" (default $args.srcConfigmap .srcConfigmap)) -}}
+{{- end -}}
+{{- end -}}
+{{- toJson $sources -}}
+{{- end -}}
+
+{{/*
+Namespaces of source ConfigMaps matched by admission webhook, comma separated, or empty when source ConfigMaps are
+selected in all namespaces.
+*/}}
+{{- define "aws-iam-authenticator-sso-wrapper.webhookNamespaces" -}}
+{{- if not (include "aws-iam-authenticator-sso-wrapper.selectorAllNamespaces" .) -}}
+{{- $namespaces := list .Release.Namespace -}}
+{{- range (include "aws-iam-authenticator-sso-wrapper.sources" . | fromJsonArray) -}}
+{{- $namespaces = concat $namespaces .namespaces -}}
+{{- end -}}
+{{- $namespaces | uniq | join "," -}}
+{{- end -}}
+{{- end -}}
+
+{{/*
+CEL expression of label selector. Set-based requirements can not be expressed, so such selectors match every
+ConfigMap and are left to the webhook itself.
+*/}}
+{{- define "aws-iam-authenticator-sso-wrapper.labelSelectorExpression" -}}
+{{- if contains "(" . -}}
+true
+{{- else -}}
+{{- $requirements := list -}}
+{{- range (splitList "," .) -}}
+{{- $requirement := trim . -}}
+{{- if hasPrefix "!" $requirement -}}
+{{- $requirements = append $requirements (printf "!(has(object.metadata.labels) && '%s' in object.metadata.labels)" (trimPrefix "!" $requirement)) -}}
+{{- else if contains "!=" $requirement -}}
+{{- $parts := regexSplit "!=" $requirement 2 -}}
+{{- $requirements = append $requirements (printf "!(has(object.metadata.labels) && '%s' in object.metadata.labels && object.metadata.labels['%s'] == '%s')" (trim (index $parts 0)) (trim (index $parts 0)) (trim (index $parts 1))) -}}
+{{- else if contains "=" $requirement -}}
+{{- $parts := regexSplit "==?" $requirement 2 -}}
+{{- $requirements = append $requirements (printf "(has(object.metadata.labels) && '%s' in object.metadata.labels && object.metadata.labels['%s'] == '%s')" (trim (index $parts 0)) (trim (index $parts 0)) (trim (index $parts 1))) -}}
+{{- else if $requirement -}}
+{{- $requirements = append $requirements (printf "(has(object.metadata.labels) && '%s' in object.metadata.labels)" $requirement) -}}
+{{- end -}}
+{{- end -}}
+{{- join " && " $requirements -}}
+{{- end -}}
+{{- end -}}
+
+{{/*
+CEL expression matching source ConfigMaps of any pipeline, by name or by label selector.
+*/}}
+{{- define "aws-iam-authenticator-sso-wrapper.webhookExpression" -}}
+{{- $conditions := list -}}
+{{- range (include "aws-iam-authenticator-sso-wrapper.sources" . | fromJsonArray) -}}
+{{- $namespaces := list -}}
+{{- range .namespaces -}}
+{{- $namespaces = append $namespaces (printf "'%s'" .) -}}
+{{- end -}}
+{{- if and .selector (not $namespaces) -}}
+{{- $conditions = append $conditions (printf "(%s)" (include "aws-iam-authenticator-sso-wrapper.labelSelectorExpression" .selector)) -}}
+{{- else if .selector -}}
+{{- $conditions = append $conditions (printf "(object.metadata.namespace in [%s] && %s)" (join ", " $namespaces) (include "aws-iam-authenticator-sso-wrapper.labelSelectorExpression" .selector)) -}}
+{{- else -}}
+{{- $conditions = append $conditions (printf "(object.metadata.namespace in [%s] && object.metadata.name == '%s')" (join ", " $namespaces) .name) -}}
+{{- end -}}
+{{- end -}}
+{{- join " || " $conditions -}}
+{{- end -}}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+)
+
+// ssoRoleMappingResource identifies SSORoleMapping custom resources
+var ssoRoleMappingResource = schema.GroupVersionResource{
+	Group:    "aws-iam-authenticator-sso-wrapper.justinas-b.github.io",
+	Version:  "v1alpha1",
+	Resource: "ssorolemappings",
+}
+
+// ssoRoleMappingPipelineLabel is the label of SSORoleMapping custom resources naming the pipeline they belong to.
+// Pipelines defined by -pipelines-config file only read custom resources labelled with their name.
+const ssoRoleMappingPipelineLabel = "aws-iam-authenticator-sso-wrapper.justinas-b.github.io/pipeline"
+
+// ssoRoleMappingSpec is the spec of SSORoleMapping custom resource
+type ssoRoleMappingSpec struct {
+	// PermissionSet is the name of permission set to be translated to role ARN
+	PermissionSet string `json:"permissionSet,omitempty"`
+
+	// RoleARN is the ARN of the role, used as is when PermissionSet is not defined
+	RoleARN string `json:"roleArn,omitempty"`
+
+	// Account is the ID of AWS account whose SSO roles PermissionSet is resolved against, defaulting to the account
+	// this application runs in
+	Account string `json:"account,omitempty"`
+
+	// Username is the username pattern that this instances assuming this role will have in Kubernetes
+	Username string `json:"username"`
+
+	// Groups is a list of Kubernetes groups this role will authenticate as
+	Groups []string `json:"groups,omitempty"`
+
+	// UserID is the AWS PrincipalId of the role
+	UserID string `json:"userid,omitempty"`
+}
+
+// ssoRoleMappingStatus is the status of SSORoleMapping custom resource
+type ssoRoleMappingStatus struct {
+	// ResolvedRoleARN is the role ARN published to destination, empty if the mapping could not be resolved
+	ResolvedRoleARN string `json:"resolvedRoleArn,omitempty"`
+
+	// Error describes why the mapping could not be resolved
+	Error string `json:"error,omitempty"`
+
+	// ObservedGeneration is the generation of the custom resource which was last resolved
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+// listCustomRoleMappings retrieves SSORoleMapping custom resources, ordered by their names.
+//
+// Parameters:
+// - ctx: Context of the API calls.
+// - client: The Kubernetes dynamic client.
+// - namespaceName: The namespace from which custom resources are read.
+// - selector: The label selector of custom resources, or empty string to read all of them.
+//
+// Returns:
+// - []unstructured.Unstructured: The custom resources.
+// - error: An error if custom resources could not be listed.
+func listCustomRoleMappings(ctx context.Context, client dynamic.Interface, namespaceName string, selector string) ([]unstructured.Unstructured, error) {
+
+	logger.Info(fmt.Sprintf("Retrieving SSORoleMapping resources from namespace %s", namespaceName))
+
+	list, err := client.Resource(ssoRoleMappingResource).Namespace(namespaceName).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	items := list.Items
+	slices.SortFunc(items, func(a, b unstructured.Unstructured) int { return strings.Compare(a.GetName(), b.GetName()) })
+	return items, nil
+}
+
+// collectCustomRoleMappings resolves role mappings defined by SSORoleMapping custom resources.
+//
+// Every custom resource is resolved separately and, when writeStatus is set, the outcome is recorded in its status.
+// Custom resources which can not be resolved are left out, so that a single invalid resource does not block the others.
+//
+// Parameters:
+// - client: The Kubernetes dynamic client.
+// - items: The custom resources, see listCustomRoleMappings.
+// - awsIAMRoles: The SSO roles retrieved from AWS IAM of all referenced accounts.
+// - accountId: The AWS account ID.
+// - writeStatus: Whether status of custom resources is updated, which is only done by reconciliation.
+//
+// Returns:
+// - []SSORoleMapping: The resolved role mappings, in the order of custom resources.
+// - int: The number of custom resources which could not be resolved.
+func (t *translator) collectCustomRoleMappings(client dynamic.Interface, items []unstructured.Unstructured, awsIAMRoles []types.Role, accountId string, writeStatus bool) ([]SSORoleMapping, int) {
+	var mappings []SSORoleMapping
+	unresolved := 0
+
+	for i := range items {
+		item := &items[i]
+
+		mapping, err := t.resolveCustomRoleMapping(item, awsIAMRoles, accountId)
+		status := ssoRoleMappingStatus{ObservedGeneration: item.GetGeneration()}
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Unable to resolve SSORoleMapping %s, skipping it", item.GetName()), zap.Error(err))
+			status.Error = err.Error()
+			unresolved++
+		} else {
+			status.ResolvedRoleARN = mapping.RoleARN
+			mappings = append(mappings, mapping)
+		}
+
+		if !writeStatus {
+			continue
+		}
+		if err := updateCustomRoleMappingStatus(t.ctx, client, item, status); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to update status of SSORoleMapping %s", item.GetName()), zap.Error(err))
+		}
+	}
+
+	logger.Info(fmt.Sprintf("%d SSORoleMapping resources resolved, %d failed", len(mappings), unresolved))
+	return mappings, unresolved
+}
+
+// parseCustomRoleMapping converts spec of SSORoleMapping custom resource into a role mapping which is not resolved yet.
+func parseCustomRoleMapping(item *unstructured.Unstructured) (SSORoleMapping, error) {
+	object, _, err := unstructured.NestedMap(item.Object, "spec")
+	if err != nil {
+		return SSORoleMapping{}, fmt.Errorf("invalid spec: %w", err)
+	}
+
+	spec := ssoRoleMappingSpec{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object, &spec); err != nil {
+		return SSORoleMapping{}, fmt.Errorf("invalid spec: %w", err)
+	}
+
+	if (spec.PermissionSet == "") == (spec.RoleARN == "") {
+		return SSORoleMapping{}, fmt.Errorf("exactly one of permissionSet and roleArn must be defined")
+	}
+
+	return SSORoleMapping{
+		RoleARN:       spec.RoleARN,
+		PermissionSet: spec.PermissionSet,
+		Account:       spec.Account,
+		Username:      spec.Username,
+		Groups:        spec.Groups,
+		UserID:        spec.UserID,
+	}, nil
+}
+
+// resolveCustomRoleMapping converts SSORoleMapping custom resource into a resolved role mapping.
+func (t *translator) resolveCustomRoleMapping(item *unstructured.Unstructured, awsIAMRoles []types.Role, accountId string) (SSORoleMapping, error) {
+	mapping, err := parseCustomRoleMapping(item)
+	if err != nil {
+		return SSORoleMapping{}, err
+	}
+
+	mapping, err = t.renderRoleMappingSelector(mapping, accountId)
+	if err != nil {
+		return SSORoleMapping{}, err
+	}
+	if isPermissionSetSelector(mapping.PermissionSet) {
+		return SSORoleMapping{}, fmt.Errorf("permissionSet selectors are only supported in mapRoles of source ConfigMap")
+	}
+
+	return t.resolveRoleMapping(mapping, awsIAMRoles, accountId)
+}
+
+// updateCustomRoleMappingStatus writes status of SSORoleMapping custom resource, unless it is already up to date.
+func updateCustomRoleMappingStatus(ctx context.Context, client dynamic.Interface, item *unstructured.Unstructured, status ssoRoleMappingStatus) error {
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return err
+	}
+
+	current, _, _ := unstructured.NestedMap(item.Object, "status")
+	if reflect.DeepEqual(current, object) {
+		return nil
+	}
+
+	updated := item.DeepCopy()
+	if err := unstructured.SetNestedMap(updated.Object, object, "status"); err != nil {
+		return err
+	}
+
+	_, err = client.Resource(ssoRoleMappingResource).Namespace(item.GetNamespace()).UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	return err
+}
+
+// watchCustomRoleMappings starts an informer on SSORoleMapping custom resources and sends a signal
+// to trigger whenever any of them is created, deleted or its spec changes.
+//
+// Parameters:
+//   - ctx: Context which stops the informer once cancelled.
+//   - client: Kubernetes dynamic client used to list and watch custom resources.
+//   - namespaceName: The namespace of custom resources to watch.
+//   - selector: The label selector of custom resources to watch, or empty string to watch all of them.
+//   - trigger: Channel which receives a signal for every observed change, see signalChanges.
+//
+// Returns:
+//   - error: An error if the informer cache fails to sync.
+func watchCustomRoleMappings(ctx context.Context, client dynamic.Interface, namespaceName string, selector string, trigger chan<- struct{}) error {
+
+	logger.Info(fmt.Sprintf("Watching SSORoleMapping resources in namespace %s for changes", namespaceName))
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, 0, namespaceName, func(options *metav1.ListOptions) {
+		options.LabelSelector = selector
+	})
+
+	return signalChanges(ctx, factory.ForResource(ssoRoleMappingResource).Informer(), fmt.Sprintf("SSORoleMapping resources in namespace %s", namespaceName), nil,
+		func(oldObj, newObj interface{}) bool {
+			// Generation is only incremented on changes of spec, so that status updates are ignored
+			return oldObj.(*unstructured.Unstructured).GetGeneration() != newObj.(*unstructured.Unstructured).GetGeneration()
+		},
+		trigger)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// newCustomRoleMapping returns SSORoleMapping custom resource with the given spec
+func newCustomRoleMapping(name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": ssoRoleMappingResource.GroupVersion().String(),
+		"kind":       "SSORoleMapping",
+		"metadata": map[string]interface{}{
+			"name":       name,
+			"namespace":  "TEST_NAMESPACE",
+			"generation": int64(1),
+		},
+		"spec": spec,
+	}}
+}
+
+// newFakeDynamicClient returns fake dynamic client aware of SSORoleMapping custom resources
+func newFakeDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		ssoRoleMappingResource: "SSORoleMappingList",
+	}, objects...)
+}
+
+func TestCollectCustomRoleMappings(t *testing.T) {
+	awsIAMRoles := []types.Role{
+		{
+			RoleName: aws.String("AWSReservedSSO_devops_0123456789abcdef"),
+			Arn:      aws.String("arn:aws:iam::000000000000:role/aws-reserved/sso.amazonaws.com/eu-west-1/AWSReservedSSO_devops_0123456789abcdef"),
+			Path:     aws.String("/aws-reserved/sso.amazonaws.com/eu-west-1/"),
+		},
+		newAccountSSORole("111111111111", "devops", "fedcba9876543210"),
+	}
+
+	client := newFakeDynamicClient(
+		newCustomRoleMapping("devops", map[string]interface{}{
+			"permissionSet": "devops",
+			"username":      "devops:{{SessionName}}",
+			"groups":        []interface{}{"system:masters"},
+		}),
+		newCustomRoleMapping("generic", map[string]interface{}{
+			"roleArn":  "arn:aws:iam::$ACCOUNTID:role/generic",
+			"username": "generic",
+			"groups":   []interface{}{"viewers"},
+		}),
+		newCustomRoleMapping("missing", map[string]interface{}{
+			"permissionSet": "missing",
+			"username":      "missing",
+		}),
+		newCustomRoleMapping("other-account", map[string]interface{}{
+			"permissionSet": "devops",
+			"account":       "111111111111",
+			"username":      "other-account:{{SessionName}}",
+		}),
+	)
+
+	want := []SSORoleMapping{
+		{
+			RoleARN:  "arn:aws:iam::000000000000:role/AWSReservedSSO_devops_0123456789abcdef",
+			Username: "devops:{{SessionName}}",
+			Groups:   []string{"system:masters"},
+		},
+		{
+			RoleARN:  "arn:aws:iam::000000000000:role/generic",
+			Username: "generic",
+			Groups:   []string{"viewers"},
+		},
+		{
+			RoleARN:  "arn:aws:iam::111111111111:role/AWSReservedSSO_devops_fedcba9876543210",
+			Username: "other-account:{{SessionName}}",
+		},
+	}
+
+	tr := newTranslator(context.Background(), defaultAWSRegion)
+
+	items, err := listCustomRoleMappings(context.Background(), client, "TEST_NAMESPACE", "")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+
+	// Test that status is not written when role mappings are only previewed
+	tr.collectCustomRoleMappings(client, items, awsIAMRoles, "000000000000", false)
+	for _, action := range client.Actions() {
+		if action.GetSubresource() == "status" {
+			t.Errorf("collectCustomRoleMappings() updated status without writeStatus: %+v", action)
+		}
+	}
+
+	got, unresolved := tr.collectCustomRoleMappings(client, items, awsIAMRoles, "000000000000", true)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collectCustomRoleMappings() returned unexpected mappings: %+v, want %+v", got, want)
+	}
+	if unresolved != 1 {
+		t.Errorf("collectCustomRoleMappings() returned %d unresolved mappings, want 1", unresolved)
+	}
+
+	// Test that outcome of resolution is recorded in status of every custom resource
+	wantStatus := map[string]map[string]interface{}{
+		"devops":  {"resolvedRoleArn": want[0].RoleARN, "observedGeneration": int64(1)},
+		"generic": {"resolvedRoleArn": want[1].RoleARN, "observedGeneration": int64(1)},
+		"missing": {"error": "permission set missing not found in AWS IAM service", "observedGeneration": int64(1)},
+		// Permission set is resolved against SSO roles of the account defined by spec
+		"other-account": {"resolvedRoleArn": want[2].RoleARN, "observedGeneration": int64(1)},
+	}
+	for name, status := range wantStatus {
+		item, err := client.Resource(ssoRoleMappingResource).Namespace("TEST_NAMESPACE").Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		got, _, _ := unstructured.NestedMap(item.Object, "status")
+		if !reflect.DeepEqual(got, status) {
+			t.Errorf("Status of %s is unexpected: %+v, want %+v", name, got, status)
+		}
+	}
+}
+
+func TestListCustomRoleMappingsOfPipeline(t *testing.T) {
+	teamA := newCustomRoleMapping("team-a", map[string]interface{}{"roleArn": "arn:aws:iam::000000000000:role/team-a", "username": "team-a"})
+	teamA.SetLabels(map[string]string{ssoRoleMappingPipelineLabel: "team-a"})
+	teamB := newCustomRoleMapping("team-b", map[string]interface{}{"roleArn": "arn:aws:iam::000000000000:role/team-b", "username": "team-b"})
+	teamB.SetLabels(map[string]string{ssoRoleMappingPipelineLabel: "team-b"})
+	unlabelled := newCustomRoleMapping("unlabelled", map[string]interface{}{"roleArn": "arn:aws:iam::000000000000:role/unlabelled", "username": "unlabelled"})
+	client := newFakeDynamicClient(teamA, teamB, unlabelled)
+
+	// Test that pipeline defined by file only reads custom resources labelled with its name
+	p := &pipeline{Name: "team-a"}
+	got, err := listCustomRoleMappings(context.Background(), client, "TEST_NAMESPACE", p.customRoleMappingSelector())
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+	if len(got) != 1 || got[0].GetName() != "team-a" {
+		t.Errorf("listCustomRoleMappings() returned unexpected custom resources: %+v", got)
+	}
+}
+
+func TestResolveCustomRoleMapping(t *testing.T) {
+	tr := newTranslator(context.Background(), defaultAWSRegion)
+
+	// Test that spec defining both permission set and role ARN is rejected
+	t.Run("Both permissionSet and roleArn defined", func(t *testing.T) {
+		item := newCustomRoleMapping("invalid", map[string]interface{}{
+			"permissionSet": "devops",
+			"roleArn":       "arn:aws:iam::000000000000:role/generic",
+			"username":      "invalid",
+		})
+
+		if _, err := tr.resolveCustomRoleMapping(item, nil, "000000000000"); err == nil {
+			t.Errorf("resolveCustomRoleMapping() returned nil, was expecting to get an error")
+		}
+	})
+
+	// Test that permission set selector is rejected, as status can only hold a single role
+	t.Run("Permission set selector defined", func(t *testing.T) {
+		item := newCustomRoleMapping("selector", map[string]interface{}{
+			"permissionSet": "team-*-readonly",
+			"username":      "selector",
+		})
+
+		if _, err := tr.resolveCustomRoleMapping(item, nil, "000000000000"); err == nil {
+			t.Errorf("resolveCustomRoleMapping() returned nil, was expecting to get an error")
+		}
+	})
+}
+
+func TestWatchCustomRoleMappings(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	item := newCustomRoleMapping("devops", map[string]interface{}{"permissionSet": "devops", "username": "devops"})
+	client := newFakeDynamicClient(item)
+	trigger := make(chan struct{}, 1)
+
+	if err := watchCustomRoleMappings(ctx, client, "TEST_NAMESPACE", "", trigger); err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+
+	expectTrigger := func(want bool) {
+		t.Helper()
+		select {
+		case <-trigger:
+			if !want {
+				t.Errorf("watchCustomRoleMappings() sent unexpected signal")
+			}
+		case <-time.After(500 * time.Millisecond):
+			if want {
+				t.Errorf("watchCustomRoleMappings() did not send a signal, was expecting one")
+			}
+		}
+	}
+	expectTrigger(false)
+
+	// Status updates do not change generation and must not trigger reconciliation
+	if err := unstructured.SetNestedField(item.Object, "arn", "status", "resolvedRoleArn"); err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+	if _, err := client.Resource(ssoRoleMappingResource).Namespace("TEST_NAMESPACE").UpdateStatus(ctx, item, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+	expectTrigger(false)
+
+	// Spec updates increment generation and trigger reconciliation
+	item.SetGeneration(2)
+	if _, err := client.Resource(ssoRoleMappingResource).Namespace("TEST_NAMESPACE").Update(ctx, item, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+	expectTrigger(true)
+}
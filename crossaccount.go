@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"golang.org/x/exp/slices"
+)
+
+// crossAccountSessionName is the session name used when assuming reader role in other accounts
+const crossAccountSessionName = "aws-iam-authenticator-sso-wrapper"
+
+// crossAccountAPI is the subset of IAM API used in other accounts.
+type crossAccountAPI interface {
+	iam.ListRolesAPIClient
+	iam.ListAccountAliasesAPIClient
+}
+
+var (
+	// crossAccountClients caches IAM clients of other accounts, keyed by region and account ID. Credentials of
+	// every client are cached and refreshed by the client itself before the assumed role session expires.
+	crossAccountClients   = map[crossAccountClientKey]crossAccountAPI{}
+	crossAccountClientsMu sync.Mutex
+
+	// newCrossAccountClient creates IAM client of the given account, replaced in tests
+	newCrossAccountClient = assumeCrossAccountRole
+)
+
+// crossAccountClientKey identifies cached IAM client of another account
+type crossAccountClientKey struct {
+	region    string
+	accountId string
+}
+
+// assumeCrossAccountRole returns IAM client which assumes reader role in the given account, using STS of the given region.
+func assumeCrossAccountRole(ctx context.Context, region string, accountId string) (crossAccountAPI, error) {
+	cfg, err := getAWSClientConfig(ctx, region)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %w", err)
+	}
+
+	roleARN := iamRoleARN(getPartition(region), accountId, crossAccountRoleName)
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), roleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = crossAccountSessionName
+	})
+	cfg.Credentials = aws.NewCredentialsCache(provider)
+
+	return iam.NewFromConfig(cfg), nil
+}
+
+// getCrossAccountClient returns cached IAM client of the given account and region, creating it on first use.
+func getCrossAccountClient(ctx context.Context, region string, accountId string) (crossAccountAPI, error) {
+	crossAccountClientsMu.Lock()
+	defer crossAccountClientsMu.Unlock()
+
+	key := crossAccountClientKey{region: region, accountId: accountId}
+	if client, ok := crossAccountClients[key]; ok {
+		return client, nil
+	}
+
+	client, err := newCrossAccountClient(ctx, region, accountId)
+	if err != nil {
+		return nil, err
+	}
+	crossAccountClients[key] = client
+
+	return client, nil
+}
+
+// listCrossAccountSSORoles retrieves SSO roles of other AWS accounts referenced by role mappings.
+//
+// Parameters:
+// - ctx: Context of the API calls.
+// - region: The region of AWS clients.
+// - roleMappings: The role mappings, some of which may define account they belong to.
+// - accountId: The AWS account ID where this application runs, whose roles are retrieved by listSSORoles.
+//
+// Returns:
+// - []types.Role: The SSO roles of all referenced accounts.
+// - error: An error if roles of any referenced account could not be retrieved.
+func listCrossAccountSSORoles(ctx context.Context, region string, roleMappings []SSORoleMapping, accountId string) ([]types.Role, error) {
+	var roles []types.Role
+
+	for _, account := range mappingAccounts(roleMappings, accountId) {
+		if crossAccountRoleName == "" {
+			logger.Warn(fmt.Sprintf("Role mappings reference account %s, but -cross-account-role-name is not defined. Its permission sets will not be resolved", account))
+			continue
+		}
+
+		logger.Info(fmt.Sprintf("Retrieving SSO roles from AWS IAM of account %s...", account))
+
+		client, err := getCrossAccountClient(ctx, region, account)
+		if err != nil {
+			return nil, err
+		}
+
+		accountRoles, err := cachedSSORoles(account, func() ([]types.Role, error) { return paginateSSORoles(ctx, client) })
+		if err != nil {
+			return nil, fmt.Errorf("failed to list SSO roles of account %s: %w", account, err)
+		}
+
+		logger.Info(fmt.Sprintf("%d SSO roles retrieved from AWS IAM of account %s", len(accountRoles), account))
+		roles = append(roles, accountRoles...)
+	}
+
+	return roles, nil
+}
+
+// mappingAccounts returns sorted IDs of accounts referenced by role mappings, other than the given one.
+func mappingAccounts(roleMappings []SSORoleMapping, accountId string) []string {
+	var accounts []string
+	for _, mapping := range roleMappings {
+		if mapping.Account != "" && mapping.Account != accountId && !slices.Contains(accounts, mapping.Account) {
+			accounts = append(accounts, mapping.Account)
+		}
+	}
+	slices.Sort(accounts)
+	return accounts
+}
+
+// rolesInAccount returns roles belonging to the given account, or all roles if account is empty.
+func rolesInAccount(roles []types.Role, accountId string) []types.Role {
+	if accountId == "" {
+		return roles
+	}
+
+	var filtered []types.Role
+	for _, role := range roles {
+		// Role ARN has format of arn:<partition>:iam::<account>:role/<name>
+		if parts := strings.SplitN(aws.ToString(role.Arn), ":", 6); len(parts) == 6 && parts[4] == accountId {
+			filtered = append(filtered, role)
+		}
+	}
+	return filtered
+}
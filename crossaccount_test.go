@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// fakeIAMClient is an in-memory implementation of crossAccountAPI
+type fakeIAMClient struct {
+	roles   []types.Role
+	aliases []string
+}
+
+func (c *fakeIAMClient) ListAccountAliases(ctx context.Context, params *iam.ListAccountAliasesInput, optFns ...func(*iam.Options)) (*iam.ListAccountAliasesOutput, error) {
+	return &iam.ListAccountAliasesOutput{AccountAliases: c.aliases}, nil
+}
+
+func (c *fakeIAMClient) ListRoles(ctx context.Context, params *iam.ListRolesInput, optFns ...func(*iam.Options)) (*iam.ListRolesOutput, error) {
+	var roles []types.Role
+	for _, role := range c.roles {
+		if strings.HasPrefix(aws.ToString(role.Path), aws.ToString(params.PathPrefix)) {
+			roles = append(roles, role)
+		}
+	}
+	return &iam.ListRolesOutput{Roles: roles}, nil
+}
+
+// useFakeCrossAccountClients replaces IAM clients of other accounts with fakes for the duration of the test
+func useFakeCrossAccountClients(t *testing.T, roles map[string][]types.Role) {
+	originalClient, originalRoleName := newCrossAccountClient, crossAccountRoleName
+	t.Cleanup(func() {
+		newCrossAccountClient, crossAccountRoleName = originalClient, originalRoleName
+		crossAccountClients = map[crossAccountClientKey]crossAccountAPI{}
+	})
+
+	crossAccountClients = map[crossAccountClientKey]crossAccountAPI{}
+	crossAccountRoleName = "sso-wrapper-reader"
+	newCrossAccountClient = func(ctx context.Context, region string, accountId string) (crossAccountAPI, error) {
+		return &fakeIAMClient{roles: roles[accountId]}, nil
+	}
+}
+
+func TestMappingAccounts(t *testing.T) {
+	mappings := []SSORoleMapping{
+		{PermissionSet: "devops"},
+		{PermissionSet: "devops", Account: "222222222222"},
+		{PermissionSet: "sre", Account: "111111111111"},
+		{PermissionSet: "sre", Account: "000000000000"},
+		{PermissionSet: "readonly", Account: "222222222222"},
+	}
+
+	want := []string{"111111111111", "222222222222"}
+	if got := mappingAccounts(mappings, "000000000000"); !reflect.DeepEqual(got, want) {
+		t.Errorf("mappingAccounts() = %v, want %v", got, want)
+	}
+}
+
+func TestRolesInAccount(t *testing.T) {
+	roles := []types.Role{
+		newAccountSSORole("000000000000", "devops", "0123456789abcdef"),
+		newAccountSSORole("111111111111", "devops", "fedcba9876543210"),
+	}
+
+	if got := rolesInAccount(roles, "111111111111"); !reflect.DeepEqual(got, roles[1:]) {
+		t.Errorf("rolesInAccount() returned unexpected roles: %+v, want %+v", got, roles[1:])
+	}
+	if got := rolesInAccount(roles, ""); !reflect.DeepEqual(got, roles) {
+		t.Errorf("rolesInAccount() returned unexpected roles: %+v, want %+v", got, roles)
+	}
+}
+
+func TestListCrossAccountSSORoles(t *testing.T) {
+	mappings := []SSORoleMapping{
+		{PermissionSet: "devops"},
+		{PermissionSet: "devops", Account: "111111111111"},
+	}
+
+	t.Run("Roles of referenced accounts are listed", func(t *testing.T) {
+		useFakeCrossAccountClients(t, map[string][]types.Role{
+			"111111111111": {
+				newAccountSSORole("111111111111", "devops", "fedcba9876543210"),
+				{RoleName: aws.String("NotSSORole"), Arn: aws.String("arn:aws:iam::111111111111:role/NotSSORole"), Path: aws.String("/")},
+			},
+		})
+
+		got, err := listCrossAccountSSORoles(context.Background(), "us-east-1", mappings, "000000000000")
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if len(got) != 1 || *got[0].RoleName != "AWSReservedSSO_devops_fedcba9876543210" {
+			t.Errorf("listCrossAccountSSORoles() returned unexpected roles: %+v", got)
+		}
+		if _, ok := crossAccountClients[crossAccountClientKey{region: "us-east-1", accountId: "111111111111"}]; !ok {
+			t.Errorf("IAM client of account 111111111111 was not cached")
+		}
+	})
+
+	t.Run("Accounts are skipped without cross-account role name", func(t *testing.T) {
+		useFakeCrossAccountClients(t, nil)
+		crossAccountRoleName = ""
+
+		got, err := listCrossAccountSSORoles(context.Background(), "us-east-1", mappings, "000000000000")
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("listCrossAccountSSORoles() returned unexpected roles: %+v", got)
+		}
+	})
+}
+
+func TestResolveCrossAccountRoleMapping(t *testing.T) {
+	roles := []types.Role{
+		newAccountSSORole("000000000000", "devops", "0123456789abcdef"),
+		newAccountSSORole("111111111111", "devops", "fedcba9876543210"),
+	}
+
+	tests := []struct {
+		name    string
+		mapping SSORoleMapping
+		want    string
+	}{
+		{"Permission set of local account", SSORoleMapping{PermissionSet: "devops"}, "arn:aws:iam::000000000000:role/AWSReservedSSO_devops_0123456789abcdef"},
+		{"Permission set of other account", SSORoleMapping{PermissionSet: "devops", Account: "111111111111"}, "arn:aws:iam::111111111111:role/AWSReservedSSO_devops_fedcba9876543210"},
+		{"Account ID placeholder of other account", SSORoleMapping{RoleARN: "arn:aws:iam::$ACCOUNTID:role/admin", Account: "111111111111"}, "arn:aws:iam::111111111111:role/admin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newTranslator(context.Background(), defaultAWSRegion).resolveRoleMapping(tt.mapping, roles, "000000000000")
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+			}
+			if got.RoleARN != tt.want || got.Account != "" || got.PermissionSet != "" {
+				t.Errorf("resolveRoleMapping() returned unexpected object: %+v, want RoleARN %s", got, tt.want)
+			}
+		})
+	}
+}
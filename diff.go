@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// changeType is the kind of difference of a single role mapping
+type changeType string
+
+const (
+	changeAdded   changeType = "+"
+	changeRemoved changeType = "-"
+	changeChanged changeType = "~"
+)
+
+// mappingChange describes how a role mapping of a single role ARN differs between two sets of role mappings.
+type mappingChange struct {
+	Type    changeType
+	RoleARN string
+
+	// Before is the mapping currently published, nil when the mapping is added
+	Before *SSORoleMapping
+
+	// After is the mapping to be published, nil when the mapping is removed
+	After *SSORoleMapping
+}
+
+// runDiff implements the diff subcommand, which prints what the next reconciliation would change in
+// destination ConfigMap, without writing it.
+//
+// It uses the same flags as reconciliation, which must be parsed beforehand. Destination ConfigMap is the one of
+// the pipeline selected by -pipeline flag.
+//
+// Parameters:
+// - ctx: Context of the API calls, cancelled on SIGTERM/SIGINT.
+// - stdout: The writer to which the differences are written.
+//
+// Returns:
+// - int: The exit code, 0 when there are no differences, 1 when there are differences and 2 on failure.
+func runDiff(ctx context.Context, stdout io.Writer) int {
+	if outputMode != outputConfigMap {
+		logger.Error(fmt.Sprintf("diff subcommand is only supported with -output=%s", outputConfigMap))
+		return 2
+	}
+
+	p, err := selectPipeline(pipelineName)
+	if err != nil {
+		logger.Error("Failed to select pipeline", zap.Error(err))
+		return 2
+	}
+
+	clientset, err := getKubernetesClientSet()
+	if err != nil {
+		logger.Error("Failed to create Kubernetes clientset", zap.Error(err))
+		return 2
+	}
+
+	// Role mappings are computed as a dry run, so that diff does not write status of custom resources or emit events
+	_, desired, _, err := desiredRoleMappings(ctx, clientset, p, true)
+	if err != nil {
+		logger.Error("Failed to compute role mappings", zap.Error(err))
+		return 2
+	}
+
+	current := []SSORoleMapping{}
+	destination, err := getConfigMap(ctx, clientset, p.DestinationConfigMap, p.DestinationNamespace)
+	if err != nil && !errors.IsNotFound(err) {
+		logger.Error("Failed to get destination ConfigMap", zap.Error(err))
+		return 2
+	} else if err == nil {
+		if err := yaml.Unmarshal([]byte(destination.Data["mapRoles"]), &current); err != nil {
+			logger.Error("Failed to unmarshal RoleMappings from destination ConfigMap", zap.Error(err))
+			return 2
+		}
+	}
+
+	// Entries of destination managed by others are kept, so they are part of desired role mappings too
+	desired, err = mergeDestinationRoleMappings(destination, desired)
+	if err != nil {
+		logger.Error("Failed to merge role mappings with destination ConfigMap", zap.Error(err))
+		return 2
+	}
+
+	changes := diffRoleMappings(current, desired)
+	printMappingChanges(stdout, changes)
+
+	if groups := splitList(adminGroups); len(groups) > 0 {
+		if err := checkAdminLockout(current, desired, groups, maxAdminShrinkPercent); err != nil {
+			fmt.Fprintf(stdout, "\nUpdate would be refused by lockout protection: %s\n", err)
+		}
+	}
+
+	if len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// diffRoleMappings compares two sets of role mappings by role ARN.
+//
+// Like aws-iam-authenticator, mappings are looked up by role ARN and, when multiple mappings share
+// the same role ARN, the last one is effective. Mappings without role ARN are ignored. Order of
+// groups is not significant.
+//
+// Parameters:
+// - current: The role mappings currently published.
+// - desired: The role mappings to be published.
+//
+// Returns:
+// - []mappingChange: The changes sorted by role ARN, empty if both sets are equivalent.
+func diffRoleMappings(current []SSORoleMapping, desired []SSORoleMapping) []mappingChange {
+	before := indexRoleMappings(current)
+	after := indexRoleMappings(desired)
+
+	arns := sortedKeys(before)
+	for _, arn := range sortedKeys(after) {
+		if _, ok := before[arn]; !ok {
+			arns = append(arns, arn)
+		}
+	}
+	slices.Sort(arns)
+
+	changes := []mappingChange{}
+	for _, arn := range arns {
+		b, inBefore := before[arn]
+		a, inAfter := after[arn]
+
+		switch {
+		case !inBefore:
+			changes = append(changes, mappingChange{Type: changeAdded, RoleARN: arn, After: &a})
+		case !inAfter:
+			changes = append(changes, mappingChange{Type: changeRemoved, RoleARN: arn, Before: &b})
+		case b.Username != a.Username || !sameElements(b.Groups, a.Groups):
+			changes = append(changes, mappingChange{Type: changeChanged, RoleARN: arn, Before: &b, After: &a})
+		}
+	}
+
+	return changes
+}
+
+// indexRoleMappings returns role mappings keyed by role ARN, the last mapping of the same role ARN winning.
+func indexRoleMappings(mappings []SSORoleMapping) map[string]SSORoleMapping {
+	index := map[string]SSORoleMapping{}
+	for _, mapping := range mappings {
+		if mapping.RoleARN != "" {
+			index[mapping.RoleARN] = mapping
+		}
+	}
+	return index
+}
+
+// printMappingChanges writes human readable description of changes.
+func printMappingChanges(w io.Writer, changes []mappingChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No changes")
+		return
+	}
+
+	for _, change := range changes {
+		fmt.Fprintf(w, "%s %s\n", change.Type, change.RoleARN)
+
+		switch change.Type {
+		case changeAdded:
+			fmt.Fprintf(w, "    username: %s\n", change.After.Username)
+			fmt.Fprintf(w, "    groups: %s\n", strings.Join(change.After.Groups, ", "))
+		case changeRemoved:
+			fmt.Fprintf(w, "    username: %s\n", change.Before.Username)
+			fmt.Fprintf(w, "    groups: %s\n", strings.Join(change.Before.Groups, ", "))
+		case changeChanged:
+			if change.Before.Username != change.After.Username {
+				fmt.Fprintf(w, "    username: %s -> %s\n", change.Before.Username, change.After.Username)
+			}
+			for _, group := range change.After.Groups {
+				if !slices.Contains(change.Before.Groups, group) {
+					fmt.Fprintf(w, "    + group: %s\n", group)
+				}
+			}
+			for _, group := range change.Before.Groups {
+				if !slices.Contains(change.After.Groups, group) {
+					fmt.Fprintf(w, "    - group: %s\n", group)
+				}
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d role mapping(s) would be changed\n", len(changes))
+}
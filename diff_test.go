@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffRoleMappings(t *testing.T) {
+	current := []SSORoleMapping{
+		{RoleARN: "arn:aws:iam::000000000000:role/kept", Username: "kept", Groups: []string{"a", "b"}},
+		{RoleARN: "arn:aws:iam::000000000000:role/changed", Username: "old", Groups: []string{"a"}},
+		{RoleARN: "arn:aws:iam::000000000000:role/removed", Username: "removed", Groups: []string{"a"}},
+	}
+	desired := []SSORoleMapping{
+		{RoleARN: "arn:aws:iam::000000000000:role/kept", Username: "kept", Groups: []string{"b", "a"}},
+		{RoleARN: "arn:aws:iam::000000000000:role/changed", Username: "new", Groups: []string{"b"}},
+		{RoleARN: "arn:aws:iam::000000000000:role/added", Username: "added", Groups: []string{"a"}},
+	}
+
+	// Test that equivalent mappings produce no changes
+	t.Run("No changes", func(t *testing.T) {
+		if got := diffRoleMappings(current, current); len(got) != 0 {
+			t.Errorf("diffRoleMappings() returned unexpected changes: %+v, want none", got)
+		}
+	})
+
+	// Test that added, removed and changed mappings are detected, while reordered groups are not
+	t.Run("Mappings changed", func(t *testing.T) {
+		got := diffRoleMappings(current, desired)
+
+		want := []mappingChange{
+			{Type: changeAdded, RoleARN: "arn:aws:iam::000000000000:role/added", After: &desired[2]},
+			{Type: changeChanged, RoleARN: "arn:aws:iam::000000000000:role/changed", Before: &current[1], After: &desired[1]},
+			{Type: changeRemoved, RoleARN: "arn:aws:iam::000000000000:role/removed", Before: &current[2]},
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("diffRoleMappings() returned unexpected changes: %+v, want %+v", got, want)
+		}
+	})
+
+	// Test that the last mapping of the same role ARN is effective
+	t.Run("Duplicate role ARN", func(t *testing.T) {
+		duplicated := append([]SSORoleMapping{{RoleARN: "arn:aws:iam::000000000000:role/kept", Username: "shadowed"}}, current...)
+
+		if got := diffRoleMappings(duplicated, current); len(got) != 0 {
+			t.Errorf("diffRoleMappings() returned unexpected changes: %+v, want none", got)
+		}
+	})
+}
+
+func TestPrintMappingChanges(t *testing.T) {
+	var out bytes.Buffer
+	before := SSORoleMapping{RoleARN: "arn:aws:iam::000000000000:role/changed", Username: "old", Groups: []string{"a"}}
+	after := SSORoleMapping{RoleARN: "arn:aws:iam::000000000000:role/changed", Username: "new", Groups: []string{"b"}}
+
+	printMappingChanges(&out, []mappingChange{{Type: changeChanged, RoleARN: before.RoleARN, Before: &before, After: &after}})
+
+	for _, line := range []string{"~ arn:aws:iam::000000000000:role/changed", "username: old -> new", "+ group: b", "- group: a"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("printMappingChanges() output does not contain %q: %s", line, out.String())
+		}
+	}
+}
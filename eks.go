@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// outputConfigMap publishes role mappings to aws-auth ConfigMap
+	outputConfigMap = "configmap"
+
+	// outputAccessEntries publishes role mappings as EKS access entries
+	outputAccessEntries = "access-entries"
+
+	// accessEntryManagedTag is the tag marking access entries managed by this application.
+	// Access entries without this tag are never modified or deleted.
+	accessEntryManagedTag = "aws-iam-authenticator-sso-wrapper/managed"
+
+	accessEntryTypeStandard  = "STANDARD"
+	accessEntryTypeEC2Linux  = "EC2_LINUX"
+	clusterAdminAccessPolicy = "AmazonEKSClusterAdminPolicy"
+)
+
+// eksAccessEntriesAPI is the subset of EKS API used to manage access entries.
+type eksAccessEntriesAPI interface {
+	ListAccessEntries(ctx context.Context, params *eks.ListAccessEntriesInput, optFns ...func(*eks.Options)) (*eks.ListAccessEntriesOutput, error)
+	DescribeAccessEntry(ctx context.Context, params *eks.DescribeAccessEntryInput, optFns ...func(*eks.Options)) (*eks.DescribeAccessEntryOutput, error)
+	CreateAccessEntry(ctx context.Context, params *eks.CreateAccessEntryInput, optFns ...func(*eks.Options)) (*eks.CreateAccessEntryOutput, error)
+	UpdateAccessEntry(ctx context.Context, params *eks.UpdateAccessEntryInput, optFns ...func(*eks.Options)) (*eks.UpdateAccessEntryOutput, error)
+	DeleteAccessEntry(ctx context.Context, params *eks.DeleteAccessEntryInput, optFns ...func(*eks.Options)) (*eks.DeleteAccessEntryOutput, error)
+	AssociateAccessPolicy(ctx context.Context, params *eks.AssociateAccessPolicyInput, optFns ...func(*eks.Options)) (*eks.AssociateAccessPolicyOutput, error)
+	DisassociateAccessPolicy(ctx context.Context, params *eks.DisassociateAccessPolicyInput, optFns ...func(*eks.Options)) (*eks.DisassociateAccessPolicyOutput, error)
+	ListAssociatedAccessPolicies(ctx context.Context, params *eks.ListAssociatedAccessPoliciesInput, optFns ...func(*eks.Options)) (*eks.ListAssociatedAccessPoliciesOutput, error)
+}
+
+// accessEntry is the desired state of a single EKS access entry.
+type accessEntry struct {
+	PrincipalARN     string
+	Type             string
+	Username         string
+	KubernetesGroups []string
+	AccessPolicies   []string
+}
+
+// groupAccessPolicies maps Kubernetes groups, which can not be used in access entries, to names of equivalent access policies
+var groupAccessPolicies = map[string]string{
+	"system:masters": clusterAdminAccessPolicy,
+}
+
+// workerNodeGroups are Kubernetes groups which identify worker node role mapping
+var workerNodeGroups = []string{"system:bootstrappers", "system:nodes"}
+
+// newEKSClient returns EKS client configured for the given region of the cluster.
+func newEKSClient(ctx context.Context, region string) (*eks.Client, error) {
+	cfg, err := getAWSClientConfig(ctx, region)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %w", err)
+	}
+
+	return eks.NewFromConfig(cfg), nil
+}
+
+// buildAccessEntries converts role mappings into desired access entries keyed by principal ARN.
+//
+// Mappings for the same role are merged. Groups reserved by Kubernetes (prefixed with "system:")
+// can not be assigned to access entries, so "system:masters" is replaced with cluster admin access
+// policy and worker node mappings are converted into EC2_LINUX access entries. Other reserved groups
+// are dropped.
+//
+// It takes a slice of SSORoleMapping structs.
+// It returns a map of accessEntry structs keyed by principal ARN.
+func buildAccessEntries(mappings []SSORoleMapping) map[string]accessEntry {
+	entries := map[string]accessEntry{}
+
+	for _, mapping := range mappings {
+		if mapping.RoleARN == "" {
+			continue
+		}
+
+		entry, exists := entries[mapping.RoleARN]
+		if !exists {
+			entry = accessEntry{PrincipalARN: mapping.RoleARN, Type: accessEntryTypeStandard, Username: mapping.Username}
+		}
+
+		if isWorkerNodeMapping(mapping) {
+			entries[mapping.RoleARN] = accessEntry{PrincipalARN: mapping.RoleARN, Type: accessEntryTypeEC2Linux}
+			continue
+		}
+
+		for _, group := range mapping.Groups {
+			if policy, ok := groupAccessPolicies[group]; ok {
+				entry.AccessPolicies = appendUnique(entry.AccessPolicies, accessPolicyARN(principalPartition(mapping.RoleARN), policy))
+			} else if strings.HasPrefix(group, "system:") {
+				logger.Warn(fmt.Sprintf("Group %s can not be assigned to access entry of %s, skipping it", group, mapping.RoleARN))
+			} else {
+				entry.KubernetesGroups = appendUnique(entry.KubernetesGroups, group)
+			}
+		}
+
+		entries[mapping.RoleARN] = entry
+	}
+
+	return entries
+}
+
+// isWorkerNodeMapping checks whether mapping grants permissions required by worker nodes.
+func isWorkerNodeMapping(mapping SSORoleMapping) bool {
+	for _, group := range workerNodeGroups {
+		if !slices.Contains(mapping.Groups, group) {
+			return false
+		}
+	}
+	return true
+}
+
+// reconcileAccessEntries creates, updates and deletes access entries of EKS cluster to match given role mappings.
+//
+// Only access entries tagged with accessEntryManagedTag are updated or deleted. Access entries created by
+// other means (e.g. cluster creator or managed node groups) are left untouched, even if a mapping for
+// the same principal exists.
+//
+// Parameters:
+//   - ctx: Context of the API calls.
+//   - client: EKS client.
+//   - clusterName: The name of EKS cluster.
+//   - mappings: The role mappings to be published.
+//
+// Returns:
+//   - error: An error joining all failures, in which case remaining access entries are still reconciled.
+func reconcileAccessEntries(ctx context.Context, client eksAccessEntriesAPI, clusterName string, mappings []SSORoleMapping) error {
+
+	logger.Info(fmt.Sprintf("Reconciling access entries of EKS cluster %s", clusterName))
+
+	desired := buildAccessEntries(mappings)
+
+	existing, err := listAccessEntries(ctx, client, clusterName)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	for _, principalARN := range sortedKeys(existing) {
+		current := existing[principalARN]
+		if current.Tags[accessEntryManagedTag] != "true" {
+			if _, ok := desired[principalARN]; ok {
+				logger.Warn(fmt.Sprintf("Access entry for %s is not managed by this application, skipping it", principalARN))
+				delete(desired, principalARN)
+			}
+			continue
+		}
+
+		want, ok := desired[principalARN]
+		if !ok || aws.ToString(current.Type) != want.Type {
+			logger.Info(fmt.Sprintf("Deleting access entry for %s", principalARN))
+			if _, err := client.DeleteAccessEntry(ctx, &eks.DeleteAccessEntryInput{ClusterName: aws.String(clusterName), PrincipalArn: aws.String(principalARN)}); err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete access entry for %s: %w", principalARN, err))
+				delete(desired, principalARN)
+			}
+			continue
+		}
+		delete(desired, principalARN)
+
+		// EKS generates username if none is provided, so it is only compared when mapping defines one
+		usernameChanged := want.Username != "" && aws.ToString(current.Username) != want.Username
+		if want.Type == accessEntryTypeStandard && (usernameChanged || !sameElements(current.KubernetesGroups, want.KubernetesGroups)) {
+			logger.Info(fmt.Sprintf("Updating access entry for %s", principalARN))
+			input := &eks.UpdateAccessEntryInput{
+				ClusterName:      aws.String(clusterName),
+				PrincipalArn:     aws.String(principalARN),
+				KubernetesGroups: append([]string{}, want.KubernetesGroups...),
+			}
+			if want.Username != "" {
+				input.Username = aws.String(want.Username)
+			}
+			if _, err := client.UpdateAccessEntry(ctx, input); err != nil {
+				errs = append(errs, fmt.Errorf("failed to update access entry for %s: %w", principalARN, err))
+				continue
+			}
+		}
+
+		if err := reconcileAccessPolicies(ctx, client, clusterName, want); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, principalARN := range sortedKeys(desired) {
+		want := desired[principalARN]
+		logger.Info(fmt.Sprintf("Creating access entry for %s", principalARN))
+		input := &eks.CreateAccessEntryInput{
+			ClusterName:  aws.String(clusterName),
+			PrincipalArn: aws.String(principalARN),
+			Type:         aws.String(want.Type),
+			Tags:         map[string]string{accessEntryManagedTag: "true"},
+		}
+		if want.Type == accessEntryTypeStandard {
+			input.KubernetesGroups = want.KubernetesGroups
+			if want.Username != "" {
+				input.Username = aws.String(want.Username)
+			}
+		}
+		if _, err := client.CreateAccessEntry(ctx, input); err != nil {
+			errs = append(errs, fmt.Errorf("failed to create access entry for %s: %w", principalARN, err))
+			continue
+		}
+
+		if err := reconcileAccessPolicies(ctx, client, clusterName, want); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	logger.Info(fmt.Sprintf("Successfully reconciled access entries of EKS cluster %s", clusterName))
+	return nil
+}
+
+// listAccessEntries returns all access entries of EKS cluster keyed by principal ARN.
+func listAccessEntries(ctx context.Context, client eksAccessEntriesAPI, clusterName string) (map[string]ekstypes.AccessEntry, error) {
+	entries := map[string]ekstypes.AccessEntry{}
+
+	paginator := eks.NewListAccessEntriesPaginator(client, &eks.ListAccessEntriesInput{ClusterName: aws.String(clusterName)})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list access entries: %w", err)
+		}
+
+		for _, principalARN := range output.AccessEntries {
+			described, err := client.DescribeAccessEntry(ctx, &eks.DescribeAccessEntryInput{ClusterName: aws.String(clusterName), PrincipalArn: aws.String(principalARN)})
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe access entry for %s: %w", principalARN, err)
+			}
+			entries[principalARN] = *described.AccessEntry
+		}
+	}
+
+	return entries, nil
+}
+
+// reconcileAccessPolicies associates and disassociates cluster scoped access policies of a single access entry.
+func reconcileAccessPolicies(ctx context.Context, client eksAccessEntriesAPI, clusterName string, want accessEntry) error {
+	current, err := listAccessPolicies(ctx, client, clusterName, want.PrincipalARN)
+	if err != nil {
+		return err
+	}
+
+	for _, policy := range want.AccessPolicies {
+		if slices.Contains(current, policy) {
+			continue
+		}
+		logger.Info(fmt.Sprintf("Associating access policy %s with %s", policy, want.PrincipalARN))
+		_, err := client.AssociateAccessPolicy(ctx, &eks.AssociateAccessPolicyInput{
+			ClusterName:  aws.String(clusterName),
+			PrincipalArn: aws.String(want.PrincipalARN),
+			PolicyArn:    aws.String(policy),
+			AccessScope:  &ekstypes.AccessScope{Type: ekstypes.AccessScopeTypeCluster},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to associate access policy %s with %s: %w", policy, want.PrincipalARN, err)
+		}
+	}
+
+	for _, policy := range current {
+		if slices.Contains(want.AccessPolicies, policy) {
+			continue
+		}
+		logger.Info(fmt.Sprintf("Disassociating access policy %s from %s", policy, want.PrincipalARN))
+		_, err := client.DisassociateAccessPolicy(ctx, &eks.DisassociateAccessPolicyInput{
+			ClusterName:  aws.String(clusterName),
+			PrincipalArn: aws.String(want.PrincipalARN),
+			PolicyArn:    aws.String(policy),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to disassociate access policy %s from %s: %w", policy, want.PrincipalARN, err)
+		}
+	}
+
+	return nil
+}
+
+// listAccessPolicies returns ARNs of access policies associated with access entry of the given principal.
+func listAccessPolicies(ctx context.Context, client eksAccessEntriesAPI, clusterName string, principalARN string) ([]string, error) {
+	var policies []string
+
+	paginator := eks.NewListAssociatedAccessPoliciesPaginator(client, &eks.ListAssociatedAccessPoliciesInput{ClusterName: aws.String(clusterName), PrincipalArn: aws.String(principalARN)})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list access policies of %s: %w", principalARN, err)
+		}
+		for _, policy := range output.AssociatedAccessPolicies {
+			policies = append(policies, aws.ToString(policy.PolicyArn))
+		}
+	}
+
+	return policies, nil
+}
+
+// appendUnique appends value to the slice unless it is already present.
+func appendUnique(values []string, value string) []string {
+	if slices.Contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
+
+// sameElements checks whether both slices contain the same elements, regardless of their order.
+func sameElements(a []string, b []string) bool {
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+// sortedKeys returns keys of the map in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
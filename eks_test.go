@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"golang.org/x/exp/slices"
+)
+
+// fakeEKSClient is an in-memory implementation of eksAccessEntriesAPI
+type fakeEKSClient struct {
+	entries  map[string]ekstypes.AccessEntry
+	policies map[string][]string
+	calls    []string
+}
+
+func newFakeEKSClient(entries ...ekstypes.AccessEntry) *fakeEKSClient {
+	client := &fakeEKSClient{entries: map[string]ekstypes.AccessEntry{}, policies: map[string][]string{}}
+	for _, entry := range entries {
+		client.entries[*entry.PrincipalArn] = entry
+	}
+	return client
+}
+
+func (c *fakeEKSClient) ListAccessEntries(ctx context.Context, params *eks.ListAccessEntriesInput, optFns ...func(*eks.Options)) (*eks.ListAccessEntriesOutput, error) {
+	return &eks.ListAccessEntriesOutput{AccessEntries: sortedKeys(c.entries)}, nil
+}
+
+func (c *fakeEKSClient) DescribeAccessEntry(ctx context.Context, params *eks.DescribeAccessEntryInput, optFns ...func(*eks.Options)) (*eks.DescribeAccessEntryOutput, error) {
+	entry := c.entries[*params.PrincipalArn]
+	return &eks.DescribeAccessEntryOutput{AccessEntry: &entry}, nil
+}
+
+func (c *fakeEKSClient) CreateAccessEntry(ctx context.Context, params *eks.CreateAccessEntryInput, optFns ...func(*eks.Options)) (*eks.CreateAccessEntryOutput, error) {
+	c.calls = append(c.calls, "CreateAccessEntry "+*params.PrincipalArn)
+	c.entries[*params.PrincipalArn] = ekstypes.AccessEntry{
+		PrincipalArn:     params.PrincipalArn,
+		Type:             params.Type,
+		Username:         params.Username,
+		KubernetesGroups: params.KubernetesGroups,
+		Tags:             params.Tags,
+	}
+	return &eks.CreateAccessEntryOutput{}, nil
+}
+
+func (c *fakeEKSClient) UpdateAccessEntry(ctx context.Context, params *eks.UpdateAccessEntryInput, optFns ...func(*eks.Options)) (*eks.UpdateAccessEntryOutput, error) {
+	c.calls = append(c.calls, "UpdateAccessEntry "+*params.PrincipalArn)
+	entry := c.entries[*params.PrincipalArn]
+	entry.KubernetesGroups = params.KubernetesGroups
+	if params.Username != nil {
+		entry.Username = params.Username
+	}
+	c.entries[*params.PrincipalArn] = entry
+	return &eks.UpdateAccessEntryOutput{}, nil
+}
+
+func (c *fakeEKSClient) DeleteAccessEntry(ctx context.Context, params *eks.DeleteAccessEntryInput, optFns ...func(*eks.Options)) (*eks.DeleteAccessEntryOutput, error) {
+	c.calls = append(c.calls, "DeleteAccessEntry "+*params.PrincipalArn)
+	delete(c.entries, *params.PrincipalArn)
+	delete(c.policies, *params.PrincipalArn)
+	return &eks.DeleteAccessEntryOutput{}, nil
+}
+
+func (c *fakeEKSClient) AssociateAccessPolicy(ctx context.Context, params *eks.AssociateAccessPolicyInput, optFns ...func(*eks.Options)) (*eks.AssociateAccessPolicyOutput, error) {
+	c.calls = append(c.calls, "AssociateAccessPolicy "+*params.PrincipalArn)
+	c.policies[*params.PrincipalArn] = append(c.policies[*params.PrincipalArn], *params.PolicyArn)
+	return &eks.AssociateAccessPolicyOutput{}, nil
+}
+
+func (c *fakeEKSClient) DisassociateAccessPolicy(ctx context.Context, params *eks.DisassociateAccessPolicyInput, optFns ...func(*eks.Options)) (*eks.DisassociateAccessPolicyOutput, error) {
+	c.calls = append(c.calls, "DisassociateAccessPolicy "+*params.PrincipalArn)
+	policies := c.policies[*params.PrincipalArn]
+	c.policies[*params.PrincipalArn] = slices.DeleteFunc(policies, func(p string) bool { return p == *params.PolicyArn })
+	return &eks.DisassociateAccessPolicyOutput{}, nil
+}
+
+func (c *fakeEKSClient) ListAssociatedAccessPolicies(ctx context.Context, params *eks.ListAssociatedAccessPoliciesInput, optFns ...func(*eks.Options)) (*eks.ListAssociatedAccessPoliciesOutput, error) {
+	output := &eks.ListAssociatedAccessPoliciesOutput{}
+	for _, policy := range c.policies[*params.PrincipalArn] {
+		output.AssociatedAccessPolicies = append(output.AssociatedAccessPolicies, ekstypes.AssociatedAccessPolicy{PolicyArn: aws.String(policy)})
+	}
+	return output, nil
+}
+
+func TestBuildAccessEntries(t *testing.T) {
+	mappings := []SSORoleMapping{
+		{
+			RoleARN:  "arn:aws:iam::123456789012:role/AWSReservedSSO_devops_0123456789abcdef",
+			Username: "devops:{{SessionName}}",
+			Groups:   []string{"system:masters", "devops"},
+		},
+		{
+			RoleARN:  "arn:aws:iam::123456789012:role/AWSReservedSSO_devops_0123456789abcdef",
+			Username: "devops:{{SessionName}}",
+			Groups:   []string{"viewers", "system:authenticated"},
+		},
+		{
+			RoleARN:  "arn:aws:iam::123456789012:role/node-role",
+			Username: "system:node:{{EC2PrivateDNSName}}",
+			Groups:   []string{"system:bootstrappers", "system:nodes"},
+		},
+	}
+
+	want := map[string]accessEntry{
+		"arn:aws:iam::123456789012:role/AWSReservedSSO_devops_0123456789abcdef": {
+			PrincipalARN:     "arn:aws:iam::123456789012:role/AWSReservedSSO_devops_0123456789abcdef",
+			Type:             accessEntryTypeStandard,
+			Username:         "devops:{{SessionName}}",
+			KubernetesGroups: []string{"devops", "viewers"},
+			AccessPolicies:   []string{accessPolicyARN("aws", clusterAdminAccessPolicy)},
+		},
+		"arn:aws:iam::123456789012:role/node-role": {
+			PrincipalARN: "arn:aws:iam::123456789012:role/node-role",
+			Type:         accessEntryTypeEC2Linux,
+		},
+	}
+
+	got := buildAccessEntries(mappings)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildAccessEntries() returned unexpected object: %+v, want %+v", got, want)
+	}
+}
+
+func TestReconcileAccessEntries(t *testing.T) {
+	managed := map[string]string{accessEntryManagedTag: "true"}
+
+	// Test that missing access entries are created together with access policies
+	t.Run("Access entries do not exist", func(t *testing.T) {
+		client := newFakeEKSClient()
+		mappings := []SSORoleMapping{{
+			RoleARN:  "arn:aws:iam::123456789012:role/AWSReservedSSO_devops_0123456789abcdef",
+			Username: "devops:{{SessionName}}",
+			Groups:   []string{"system:masters"},
+		}}
+
+		if err := reconcileAccessEntries(context.TODO(), client, "TEST_CLUSTER", mappings); err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		entry, ok := client.entries[mappings[0].RoleARN]
+		if !ok {
+			t.Fatalf("Access entry for %s was not created", mappings[0].RoleARN)
+		}
+		if aws.ToString(entry.Username) != mappings[0].Username || entry.Tags[accessEntryManagedTag] != "true" {
+			t.Errorf("reconcileAccessEntries() created unexpected access entry: %+v", entry)
+		}
+		if got := client.policies[mappings[0].RoleARN]; !reflect.DeepEqual(got, []string{accessPolicyARN("aws", clusterAdminAccessPolicy)}) {
+			t.Errorf("reconcileAccessEntries() associated unexpected access policies: %v, want %v", got, []string{accessPolicyARN("aws", clusterAdminAccessPolicy)})
+		}
+	})
+
+	// Test that managed access entries are updated and removed, while unmanaged are left untouched
+	t.Run("Access entries exist", func(t *testing.T) {
+		client := newFakeEKSClient(
+			ekstypes.AccessEntry{
+				PrincipalArn:     aws.String("arn:aws:iam::123456789012:role/AWSReservedSSO_devops_0123456789abcdef"),
+				Type:             aws.String(accessEntryTypeStandard),
+				Username:         aws.String("devops:{{SessionName}}"),
+				KubernetesGroups: []string{"old-group"},
+				Tags:             managed,
+			},
+			ekstypes.AccessEntry{
+				PrincipalArn: aws.String("arn:aws:iam::123456789012:role/AWSReservedSSO_removed_0123456789abcdef"),
+				Type:         aws.String(accessEntryTypeStandard),
+				Tags:         managed,
+			},
+			ekstypes.AccessEntry{
+				PrincipalArn: aws.String("arn:aws:iam::123456789012:role/cluster-creator"),
+				Type:         aws.String(accessEntryTypeStandard),
+			},
+			ekstypes.AccessEntry{
+				PrincipalArn: aws.String("arn:aws:iam::123456789012:role/foreign"),
+				Type:         aws.String(accessEntryTypeStandard),
+			},
+		)
+		client.policies["arn:aws:iam::123456789012:role/AWSReservedSSO_devops_0123456789abcdef"] = []string{accessPolicyARN("aws", clusterAdminAccessPolicy)}
+
+		mappings := []SSORoleMapping{
+			{
+				RoleARN:  "arn:aws:iam::123456789012:role/AWSReservedSSO_devops_0123456789abcdef",
+				Username: "devops:{{SessionName}}",
+				Groups:   []string{"new-group"},
+			},
+			{
+				RoleARN:  "arn:aws:iam::123456789012:role/foreign",
+				Username: "foreign",
+				Groups:   []string{"system:masters"},
+			},
+		}
+
+		if err := reconcileAccessEntries(context.TODO(), client, "TEST_CLUSTER", mappings); err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		want := []string{
+			"DeleteAccessEntry arn:aws:iam::123456789012:role/AWSReservedSSO_removed_0123456789abcdef",
+			"UpdateAccessEntry arn:aws:iam::123456789012:role/AWSReservedSSO_devops_0123456789abcdef",
+			"DisassociateAccessPolicy arn:aws:iam::123456789012:role/AWSReservedSSO_devops_0123456789abcdef",
+		}
+		slices.Sort(want)
+		slices.Sort(client.calls)
+		if !reflect.DeepEqual(client.calls, want) {
+			t.Errorf("reconcileAccessEntries() made unexpected calls: %v, want %v", client.calls, want)
+		}
+
+		if got := client.entries["arn:aws:iam::123456789012:role/AWSReservedSSO_devops_0123456789abcdef"].KubernetesGroups; !reflect.DeepEqual(got, []string{"new-group"}) {
+			t.Errorf("reconcileAccessEntries() set unexpected groups: %v, want %v", got, []string{"new-group"})
+		}
+	})
+
+	// Test that nothing is changed when access entries are up to date
+	t.Run("Access entries are up to date", func(t *testing.T) {
+		client := newFakeEKSClient()
+		mappings := []SSORoleMapping{{
+			RoleARN:  "arn:aws:iam::123456789012:role/AWSReservedSSO_devops_0123456789abcdef",
+			Username: "devops:{{SessionName}}",
+			Groups:   []string{"system:masters", "devops"},
+		}}
+
+		if err := reconcileAccessEntries(context.TODO(), client, "TEST_CLUSTER", mappings); err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		client.calls = nil
+
+		if err := reconcileAccessEntries(context.TODO(), client, "TEST_CLUSTER", mappings); err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if len(client.calls) != 0 {
+			t.Errorf("reconcileAccessEntries() made unexpected calls: %v, want none", client.calls)
+		}
+	})
+}
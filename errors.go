@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ReconcileStage identifies the step of reconciliation during which an error occurred.
+type ReconcileStage string
+
+const (
+	StageKubernetesClient  ReconcileStage = "kubernetes-client"
+	StageNamespace         ReconcileStage = "namespace"
+	StageSourceConfigMap   ReconcileStage = "source-configmap"
+	StageParseMappings     ReconcileStage = "parse-mappings"
+	StageCustomResources   ReconcileStage = "custom-resources"
+	StageListRoles         ReconcileStage = "list-sso-roles"
+	StageAccountID         ReconcileStage = "account-id"
+	StagePermissionSets    ReconcileStage = "permission-sets"
+	StageInstanceRole      ReconcileStage = "instance-role"
+	StageMarshalMappings   ReconcileStage = "marshal-mappings"
+	StageMergeDestination  ReconcileStage = "merge-destination"
+	StageLockoutProtection ReconcileStage = "lockout-protection"
+	StageBackupDestination ReconcileStage = "backup-destination"
+	StageWriteDestination  ReconcileStage = "write-destination"
+)
+
+// ReconcileError is returned by reconcile when one of its steps fails.
+//
+// Whenever a ReconcileError is returned, destination ConfigMap is left untouched.
+type ReconcileError struct {
+	// Stage is the step of reconciliation which failed.
+	Stage ReconcileStage
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error returns the error message prefixed with the failed stage.
+func (e *ReconcileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ReconcileError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether retrying the reconciliation may resolve the error.
+//
+// Errors caused by invalid content of source ConfigMap are permanent until the
+// ConfigMap is changed, while errors caused by AWS or Kubernetes APIs, including
+// concurrent writes of destination ConfigMap, are considered to be transient.
+// Conflicts with other field managers of destination ConfigMap require manual resolution.
+func (e *ReconcileError) Retryable() bool {
+	if errors.Is(e.Err, errFieldManagerConflict) {
+		return false
+	}
+
+	switch e.Stage {
+	case StageParseMappings, StageMarshalMappings, StageMergeDestination:
+		return false
+	default:
+		return true
+	}
+}
+
+// newReconcileError wraps an error into ReconcileError for the given stage.
+func newReconcileError(stage ReconcileStage, err error) *ReconcileError {
+	return &ReconcileError{Stage: stage, Err: err}
+}
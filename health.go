@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// healthState tracks progress of schedulers of all pipelines, which is used to answer liveness and readiness probes.
+//
+// Every pipeline is tracked separately, so that a stuck or failing pipeline is not hidden by the healthy ones.
+type healthState struct {
+	mu sync.Mutex
+
+	// standby is true while this replica waits for the leader lease
+	standby bool
+
+	// schedulers tracks schedulers running on this replica, keyed by the pipeline they reconcile
+	schedulers map[string]*schedulerHealth
+}
+
+// schedulerHealth tracks progress of the scheduler of a single pipeline.
+type schedulerHealth struct {
+	// lastProgress is the time when the scheduler started or last completed a cycle
+	lastProgress time.Time
+
+	// reconciled is true once role mappings were updated successfully at least once
+	reconciled bool
+}
+
+// healthStatus is the health state of this replica
+var healthStatus = &healthState{}
+
+// setStandby marks replica as waiting for the leader lease, or clears the mark.
+func (h *healthState) setStandby(standby bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.standby = standby
+}
+
+// schedulerStarted records that the scheduler loop of the pipeline has started on this replica.
+func (h *healthState) schedulerStarted(pipeline string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.schedulers == nil {
+		h.schedulers = map[string]*schedulerHealth{}
+	}
+	h.schedulers[pipeline] = &schedulerHealth{lastProgress: time.Now()}
+	h.standby = false
+}
+
+// schedulerStopped records that the scheduler loop of the pipeline has returned.
+func (h *healthState) schedulerStopped(pipeline string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.schedulers, pipeline)
+}
+
+// cycleCompleted records that the scheduler of the pipeline completed a cycle, either successfully or not.
+func (h *healthState) cycleCompleted(pipeline string, success bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	scheduler, ok := h.schedulers[pipeline]
+	if !ok {
+		return
+	}
+	scheduler.lastProgress = time.Now()
+	if success {
+		scheduler.reconciled = true
+	}
+}
+
+// live checks whether schedulers of all pipelines are making progress.
+//
+// It takes the maximum time allowed to pass without a scheduler completing a cycle.
+// It returns an error naming the stuck pipelines, or nil if there are none.
+// Replica which does not run any scheduler (e.g. a standby one) is always considered live.
+func (h *healthState) live(maxAge time.Duration) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var problems []string
+	for _, pipeline := range h.pipelines() {
+		if age := time.Since(h.schedulers[pipeline].lastProgress); age > maxAge {
+			problems = append(problems, fmt.Sprintf("scheduler of %s has not completed a cycle for %s, which exceeds %s", pipeline, age.Round(time.Second), maxAge))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// ready checks whether the replica has successfully updated role mappings of every pipeline at least once.
+//
+// It returns an error naming the pipelines which are not ready, or nil if there are none.
+// Standby replica is considered ready, as it is healthy and only waits for the leader lease.
+func (h *healthState) ready() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.standby {
+		return nil
+	}
+	if len(h.schedulers) == 0 {
+		return fmt.Errorf("role mappings have not been updated successfully yet")
+	}
+
+	var problems []string
+	for _, pipeline := range h.pipelines() {
+		if !h.schedulers[pipeline].reconciled {
+			problems = append(problems, fmt.Sprintf("role mappings of %s have not been updated successfully yet", pipeline))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// pipelines returns sorted names of pipelines whose schedulers are running. Caller must hold the lock.
+func (h *healthState) pipelines() []string {
+	names := make([]string, 0, len(h.schedulers))
+	for name := range h.schedulers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// probeHandler returns HTTP handler which responds with 200 if check succeeds and 503 otherwise.
+func probeHandler(check func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := check(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
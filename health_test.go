@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthStateLive(t *testing.T) {
+	// Test that replica which does not run the scheduler is live
+	t.Run("Scheduler is not running", func(t *testing.T) {
+		h := &healthState{}
+		if err := h.live(time.Minute); err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+	})
+
+	// Test that scheduler which recently completed a cycle is live
+	t.Run("Scheduler completed a cycle recently", func(t *testing.T) {
+		h := &healthState{}
+		h.schedulerStarted("TEST_PIPELINE")
+		h.cycleCompleted("TEST_PIPELINE", false)
+		if err := h.live(time.Minute); err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+	})
+
+	// Test that stuck scheduler of one pipeline is not hidden by schedulers of other pipelines
+	t.Run("Scheduler of one pipeline is stuck", func(t *testing.T) {
+		h := &healthState{}
+		h.schedulerStarted("TEST_PIPELINE")
+		h.schedulerStarted("OTHER_PIPELINE")
+		h.schedulers["OTHER_PIPELINE"].lastProgress = time.Now().Add(-2 * time.Minute)
+		h.cycleCompleted("TEST_PIPELINE", true)
+		if err := h.live(time.Minute); err == nil || !strings.Contains(err.Error(), "OTHER_PIPELINE") {
+			t.Errorf("Got unexpected error: %v, was expecting to get an error naming OTHER_PIPELINE", err)
+		}
+	})
+
+	// Test that scheduler which did not complete a cycle in time is not live
+	t.Run("Scheduler is stuck", func(t *testing.T) {
+		h := &healthState{}
+		h.schedulerStarted("TEST_PIPELINE")
+		h.schedulers["TEST_PIPELINE"].lastProgress = time.Now().Add(-2 * time.Minute)
+		if err := h.live(time.Minute); err == nil {
+			t.Errorf("Got nil error, was expecting to get an error")
+		}
+	})
+}
+
+func TestHealthStateReady(t *testing.T) {
+	// Test that replica is not ready before the first successful update
+	t.Run("No successful update yet", func(t *testing.T) {
+		h := &healthState{}
+		h.schedulerStarted("TEST_PIPELINE")
+		h.cycleCompleted("TEST_PIPELINE", false)
+		if err := h.ready(); err == nil {
+			t.Errorf("Got nil error, was expecting to get an error")
+		}
+	})
+
+	// Test that replica stays ready after the first successful update, even if later ones fail
+	t.Run("Successful update", func(t *testing.T) {
+		h := &healthState{}
+		h.schedulerStarted("TEST_PIPELINE")
+		h.cycleCompleted("TEST_PIPELINE", true)
+		h.cycleCompleted("TEST_PIPELINE", false)
+		if err := h.ready(); err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+	})
+
+	// Test that replica is not ready until every pipeline was updated successfully
+	t.Run("One of pipelines not updated yet", func(t *testing.T) {
+		h := &healthState{}
+		h.schedulerStarted("TEST_PIPELINE")
+		h.schedulerStarted("OTHER_PIPELINE")
+		h.cycleCompleted("TEST_PIPELINE", true)
+		h.cycleCompleted("OTHER_PIPELINE", false)
+		if err := h.ready(); err == nil || !strings.Contains(err.Error(), "OTHER_PIPELINE") {
+			t.Errorf("Got unexpected error: %v, was expecting to get an error naming OTHER_PIPELINE", err)
+		}
+	})
+
+	// Test that standby replica is ready
+	t.Run("Standby replica", func(t *testing.T) {
+		h := &healthState{}
+		h.setStandby(true)
+		if err := h.ready(); err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+	})
+}
+
+func TestProbeEndpoints(t *testing.T) {
+	healthStatus = &healthState{}
+	server := httptest.NewServer(newHTTPHandler(time.Minute))
+	defer server.Close()
+
+	get := func(path string) int {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	healthStatus.schedulerStarted("TEST_PIPELINE")
+	if got := get("/readyz"); got != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz returned status %d before first update, want %d", got, http.StatusServiceUnavailable)
+	}
+	if got := get("/healthz"); got != http.StatusOK {
+		t.Errorf("GET /healthz returned status %d, want %d", got, http.StatusOK)
+	}
+
+	healthStatus.cycleCompleted("TEST_PIPELINE", true)
+	if got := get("/readyz"); got != http.StatusOK {
+		t.Errorf("GET /readyz returned status %d after successful update, want %d", got, http.StatusOK)
+	}
+
+	healthStatus.schedulers["TEST_PIPELINE"].lastProgress = time.Now().Add(-2 * time.Minute)
+	if got := get("/healthz"); got != http.StatusServiceUnavailable {
+		t.Errorf("GET /healthz returned status %d for stuck scheduler, want %d", got, http.StatusServiceUnavailable)
+	}
+}
@@ -4,27 +4,39 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"strings"
+	"reflect"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/service/iam/types"
 	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/csaupgrade"
 )
 
-// getKubernetesClientSet returns a Kubernetes clientset and an error.
+// getKubernetesConfig returns a Kubernetes client configuration and an error.
 //
-// This function initializes a Kubernetes in-cluster clientset. If the initialization fails,
-// it falls back to a kubeconfig clientset. It returns the initialized clientset or an error.
+// This function initializes a Kubernetes in-cluster configuration. If the initialization fails,
+// it falls back to a kubeconfig configuration. It returns the initialized configuration or an error.
 //
 // Return:
-// - *kubernetes.Clientset: The initialized Kubernetes clientset.
+// - *rest.Config: The initialized Kubernetes client configuration.
 // - error: An error if the initialization fails.
-func getKubernetesClientSet() (*kubernetes.Clientset, error) {
+func getKubernetesConfig() (*rest.Config, error) {
 	logger.Debug("Initialising Kubernetes in-cluster clientset")
 
 	config, err := rest.InClusterConfig()
@@ -40,7 +52,52 @@ func getKubernetesClientSet() (*kubernetes.Clientset, error) {
 		logger.Debug("Successfully initialised in-cluster clientset")
 	}
 
-	return kubernetes.NewForConfig(config)
+	return config, nil
+}
+
+// kubernetesClientSet is the clientset returned by getKubernetesClientSet, created on its first call
+var (
+	kubernetesClientSet   *kubernetes.Clientset
+	kubernetesClientSetMu sync.Mutex
+)
+
+// getKubernetesClientSet returns a Kubernetes clientset and an error.
+//
+// This function initializes a Kubernetes clientset using configuration returned by getKubernetesConfig. The clientset
+// is created once and shared by all callers, so that events emitted by subsequent reconciliations are correlated.
+//
+// Return:
+// - *kubernetes.Clientset: The initialized Kubernetes clientset.
+// - error: An error if the initialization fails.
+func getKubernetesClientSet() (*kubernetes.Clientset, error) {
+	kubernetesClientSetMu.Lock()
+	defer kubernetesClientSetMu.Unlock()
+
+	if kubernetesClientSet != nil {
+		return kubernetesClientSet, nil
+	}
+
+	config, err := getKubernetesConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	kubernetesClientSet, err = kubernetes.NewForConfig(config)
+	return kubernetesClientSet, err
+}
+
+// getDynamicClient returns a Kubernetes dynamic client, used to interact with custom resources.
+//
+// Return:
+// - dynamic.Interface: The initialized Kubernetes dynamic client.
+// - error: An error if the initialization fails.
+func getDynamicClient() (dynamic.Interface, error) {
+	config, err := getKubernetesConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return dynamic.NewForConfig(config)
 }
 
 // getCurrentNamespace returns the current namespace.
@@ -52,6 +109,11 @@ func getKubernetesClientSet() (*kubernetes.Clientset, error) {
 func getCurrentNamespace() (string, error) {
 	logger.Info("Getting current namespace")
 
+	if namespace := os.Getenv("LOCAL_NAMESPACE"); namespace != "" {
+		logger.Debug(fmt.Sprintf("Current namespace: %s", namespace))
+		return namespace, nil
+	}
+
 	// If LOCAL_NAMESPACE environment variable is not defined, get namespace from Kubernetes
 	namespace, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
 	if err != nil {
@@ -65,17 +127,18 @@ func getCurrentNamespace() (string, error) {
 // getConfigMap retrieves a ConfigMap from a Kubernetes cluster.
 //
 // Parameters:
+// - ctx: Context of the API calls.
 // - configMapName: the name of the ConfigMap to retrieve.
 // - namespaceName: the name of the namespace where the ConfigMap is located.
 //
 // Returns:
 // - *v1.ConfigMap: the retrieved ConfigMap.
 // - error: an error if the retrieval fails.
-func getConfigMap(clientset kubernetes.Interface, configMapName string, namespaceName string) (*v1.ConfigMap, error) {
+func getConfigMap(ctx context.Context, clientset kubernetes.Interface, configMapName string, namespaceName string) (*v1.ConfigMap, error) {
 
 	logger.Info(fmt.Sprintf("Retrieving ConfigMap %s from namespace %s", configMapName, namespaceName))
 
-	configMap, err := clientset.CoreV1().ConfigMaps(namespaceName).Get(context.TODO(), configMapName, metav1.GetOptions{})
+	configMap, err := clientset.CoreV1().ConfigMaps(namespaceName).Get(ctx, configMapName, metav1.GetOptions{})
 
 	if errors.IsNotFound(err) {
 		logger.Error(fmt.Sprintf("ConfigMap %s not found in namespace %s", configMapName, namespaceName), zap.Error(err))
@@ -92,50 +155,249 @@ func getConfigMap(clientset kubernetes.Interface, configMapName string, namespac
 	return configMap, nil
 }
 
-// setConfigMap creates or updates a ConfigMap in a Kubernetes cluster.
+// fieldManager is the name of field manager used to apply the destination ConfigMap
+const fieldManager = "aws-iam-authenticator-sso-wrapper"
+
+// eventReasonFieldManagerConflict is the reason of event emitted when fields of destination ConfigMap are managed by others
+const eventReasonFieldManagerConflict = "FieldManagerConflict"
+
+// errFieldManagerConflict is returned by setConfigMap when fields it applies are owned by another field manager
+var errFieldManagerConflict = fmt.Errorf("fields of ConfigMap are managed by another field manager")
+
+// errDestinationModified is returned by setConfigMap when ConfigMap was modified after the data was merged from it
+var errDestinationModified = fmt.Errorf("ConfigMap was modified after its data was read")
+
+// setConfigMap creates or updates a ConfigMap in a Kubernetes cluster using server-side apply.
+//
+// Only the given data and annotations are applied, so labels, annotations and data keys set by others are
+// preserved. Conflicts with other field managers are not overwritten, but returned as errFieldManagerConflict and
+// reported by a warning event on the ConfigMap. Writes of others made after the data was read are detected by
+// resource version precondition and returned as errDestinationModified, so that the data is merged again.
 //
 // Parameters:
+//   - ctx: Context of the API calls.
 //   - configMapName: The name of the ConfigMap.
 //   - namespaceName: The namespace of the ConfigMap.
 //   - data: The data to be stored in the ConfigMap.
+//   - annotations: The annotations to be set on the ConfigMap.
+//   - resourceVersion: The resource version of the ConfigMap the data was merged from, or empty string to write it regardless of its version.
 //
 // Returns:
 //   - error: An error if the creation or update fails.
-func setConfigMap(clientset kubernetes.Interface, configMapName string, namespaceName string, data map[string]string) error {
+func setConfigMap(ctx context.Context, clientset kubernetes.Interface, configMapName string, namespaceName string, data map[string]string, annotations map[string]string, resourceVersion string) error {
 
 	logger.Info(fmt.Sprintf("Setting ConfigMap %s in namespace %s", configMapName, namespaceName))
 
-	// Define ConfigMap's metadata
-	cm := v1.ConfigMap{
-		TypeMeta: metav1.TypeMeta{
-			Kind:       "ConfigMap",
-			APIVersion: "v1",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      configMapName,
-			Namespace: namespaceName,
-		},
-		Data: data,
+	existing, err := clientset.CoreV1().ConfigMaps(namespaceName).Get(ctx, configMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		existing = nil
+	} else if err != nil {
+		return err
+	}
+	if resourceVersion != "" && (existing == nil || existing.ResourceVersion != resourceVersion) {
+		return errDestinationModified
 	}
 
-	// Check if configMap already exists and if not, create it
-	if _, err := clientset.CoreV1().ConfigMaps(namespaceName).Get(context.TODO(), configMapName, metav1.GetOptions{}); errors.IsNotFound(err) {
-		_, err = clientset.CoreV1().ConfigMaps(namespaceName).Create(context.TODO(), &cm, metav1.CreateOptions{})
-		if err != nil {
+	cm := corev1ac.ConfigMap(configMapName, namespaceName).WithData(data).WithAnnotations(annotations)
+	if existing != nil {
+		if existing, err = upgradeManagedFields(ctx, clientset, existing); err != nil {
 			return err
 		}
+		if resourceVersion != "" {
+			// Managed fields upgrade is written by this application, so the version it produced is expected
+			cm = cm.WithResourceVersion(existing.ResourceVersion)
+		}
+	}
 
-	} else { // Otherwise update existing configMap
-		_, err = clientset.CoreV1().ConfigMaps(namespaceName).Update(context.TODO(), &cm, metav1.UpdateOptions{})
-		if err != nil {
-			return err
+	_, err = clientset.CoreV1().ConfigMaps(namespaceName).Apply(ctx, cm, metav1.ApplyOptions{FieldManager: fieldManager, Force: false})
+	if errors.HasStatusCause(err, metav1.CauseTypeFieldManagerConflict) {
+		err = fmt.Errorf("%w: %w", errFieldManagerConflict, err)
+		if existing != nil {
+			emitEvent(clientset, existing, v1.EventTypeWarning, eventReasonFieldManagerConflict, err.Error())
 		}
+		return err
+	} else if errors.IsConflict(err) {
+		return fmt.Errorf("%w: %w", errDestinationModified, err)
+	} else if err != nil {
+		return err
 	}
 
 	logger.Info(fmt.Sprintf("Successfully set ConfigMap %s in namespace %s", configMapName, namespaceName))
 	return nil
 }
 
+// upgradeManagedFields transfers ownership of ConfigMap fields written by Update operations of fieldManager,
+// as done by previous versions of this application, to its apply operations.
+//
+// Parameters:
+// - ctx: Context of the API calls.
+//   - existing: The existing ConfigMap.
+//
+// Returns:
+//   - *v1.ConfigMap: The ConfigMap with upgraded managed fields, or existing one if there was nothing to upgrade.
+//   - error: An error if the ConfigMap could not be patched.
+func upgradeManagedFields(ctx context.Context, clientset kubernetes.Interface, existing *v1.ConfigMap) (*v1.ConfigMap, error) {
+	patch, err := csaupgrade.UpgradeManagedFieldsPatch(existing, sets.New(fieldManager), fieldManager)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upgrade managed fields of ConfigMap %s in namespace %s: %w", existing.Name, existing.Namespace, err)
+	} else if patch == nil {
+		return existing, nil
+	}
+
+	logger.Info(fmt.Sprintf("Upgrading fields of ConfigMap %s in namespace %s managed by %s to server-side apply", existing.Name, existing.Namespace, fieldManager))
+	return clientset.CoreV1().ConfigMaps(existing.Namespace).Patch(ctx, existing.Name, k8stypes.JSONPatchType, patch, metav1.PatchOptions{})
+}
+
+// eventRecorders holds the event recorder of every clientset events were emitted with
+var (
+	eventRecorders   = map[kubernetes.Interface]record.EventRecorder{}
+	eventRecordersMu sync.Mutex
+)
+
+// getEventRecorder returns the event recorder writing events with the given clientset.
+//
+// Recorder is created once per clientset, so that its correlator aggregates repeated events into a single event
+// with increasing count, and drops events of an object which are emitted too often.
+//
+// Parameters:
+// - clientset: The Kubernetes clientset used to write events.
+//
+// Returns:
+// - record.EventRecorder: The event recorder.
+func getEventRecorder(clientset kubernetes.Interface) record.EventRecorder {
+	eventRecordersMu.Lock()
+	defer eventRecordersMu.Unlock()
+
+	recorder, ok := eventRecorders[clientset]
+	if !ok {
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+		recorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "aws-iam-authenticator-sso-wrapper"})
+		eventRecorders[clientset] = recorder
+	}
+
+	return recorder
+}
+
+// emitEvent records a Kubernetes event regarding the given ConfigMap.
+//
+// Events are written asynchronously by the event recorder of clientset, see getEventRecorder, which logs failures
+// to write them.
+//
+// Parameters:
+// - clientset: The Kubernetes clientset.
+// - configMap: The ConfigMap the event is about.
+// - eventType: The type of the event, either v1.EventTypeNormal or v1.EventTypeWarning.
+// - reason: The short, machine understandable reason of the event.
+// - message: The human readable description of the event.
+func emitEvent(clientset kubernetes.Interface, configMap *v1.ConfigMap, eventType string, reason string, message string) {
+	getEventRecorder(clientset).Event(configMap, eventType, reason, message)
+}
+
+// watchConfigMap starts an informer on a single ConfigMap and sends a signal to trigger whenever it changes.
+//
+// Parameters:
+//   - ctx: Context which stops the informer once cancelled.
+//   - clientset: Kubernetes clientset used to list and watch the ConfigMap.
+//   - configMapName: The name of the ConfigMap to watch.
+//   - namespaceName: The namespace of the ConfigMap to watch.
+//   - dataOnly: If true, updates are only signalled when ConfigMap's data or its pause annotation changes.
+//   - trigger: Channel which receives a signal for every observed change, see signalChanges.
+//
+// Returns:
+//   - error: An error if the informer cache fails to sync.
+func watchConfigMap(ctx context.Context, clientset kubernetes.Interface, configMapName string, namespaceName string, dataOnly bool, trigger chan<- struct{}) error {
+
+	logger.Info(fmt.Sprintf("Watching ConfigMap %s in namespace %s for changes", configMapName, namespaceName))
+
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(namespaceName),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", configMapName).String()
+		}),
+	)
+
+	return signalChanges(ctx, factory.Core().V1().ConfigMaps().Informer(), fmt.Sprintf("ConfigMap %s in namespace %s", configMapName, namespaceName),
+		func(obj interface{}) bool {
+			cm, ok := obj.(*v1.ConfigMap)
+			return ok && cm.Name == configMapName
+		},
+		func(oldObj, newObj interface{}) bool {
+			oldCM, newCM := oldObj.(*v1.ConfigMap), newObj.(*v1.ConfigMap)
+			if oldCM.ResourceVersion == newCM.ResourceVersion {
+				return false
+			}
+			return !dataOnly || !reflect.DeepEqual(oldCM.Data, newCM.Data) || oldCM.Annotations[pausedAnnotation] != newCM.Annotations[pausedAnnotation]
+		},
+		trigger)
+}
+
+// signalChanges starts the informer and sends a signal to trigger whenever a watched object is added or deleted, or
+// is updated in a way which needs to be reconciled.
+//
+// Objects from the initial list are not signalled, as they are already covered by the first scheduled run, and
+// signals are dropped if one is already pending.
+//
+// Parameters:
+//   - ctx: Context which stops the informer once cancelled.
+//   - informer: The informer of watched objects, which is not started yet.
+//   - description: The description of watched objects used in logs and errors.
+//   - filter: Function selecting watched objects among those received by the informer, or nil to watch all of them.
+//   - updated: Function checking whether an update of watched object needs to be reconciled.
+//   - trigger: Channel which receives a signal for every observed change.
+//
+// Returns:
+//   - error: An error if the informer cache fails to sync.
+func signalChanges(ctx context.Context, informer cache.SharedIndexInformer, description string, filter func(obj interface{}) bool, updated func(oldObj, newObj interface{}) bool, trigger chan<- struct{}) error {
+	notify := func(obj interface{}, reason string) {
+		key, _ := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		logger.Debug(fmt.Sprintf("%s was %s", key, reason), zap.String("watch", description))
+		sendTrigger(trigger)
+	}
+
+	_, err := informer.AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			return filter == nil || filter(obj)
+		},
+		Handler: cache.ResourceEventHandlerDetailedFuncs{
+			AddFunc: func(obj interface{}, isInInitialList bool) {
+				if !isInInitialList {
+					notify(obj, "added")
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				if updated(oldObj, newObj) {
+					notify(newObj, "updated")
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				notify(obj, "deleted")
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	go informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("failed to sync informer of %s", description)
+	}
+
+	return nil
+}
+
+// sendTrigger signals that reconciliation is required, unless a signal is already pending.
+func sendTrigger(trigger chan<- struct{}) {
+	select {
+	case trigger <- struct{}{}:
+	default: // Reconciliation is already pending
+	}
+}
+
 // transformRoleMappings replaces PermissionSet name with Role ARN in RoleMappings.
 //
 // It takes the following parameters:
@@ -143,43 +405,100 @@ func setConfigMap(clientset kubernetes.Interface, configMapName string, namespac
 // - awsIAMRoles: a slice of types.Role structs
 //
 // It returns a slice of SSORoleMapping structs, where the PermissionSet name is replaced with Role ARN.
-func transformRoleMappings(roleMappings []SSORoleMapping, awsIAMRoles []types.Role, accountId string) []SSORoleMapping {
+// Role mapping whose PermissionSet is a glob or anchored regex is expanded into one mapping per matched permission set,
+// and templates of all fields are rendered. Removed role mappings are recorded in dropped field of the translator.
+func (t *translator) transformRoleMappings(roleMappings []SSORoleMapping, awsIAMRoles []types.Role, accountId string) []SSORoleMapping {
 	// Replace PermissionSet name with Role ARN, if permission
 	// set is not found - remove it from configMap
 
 	logger.Info("Translating permissionSets to RoleARNs in RoleMappings...")
 
 	var roleMappingsUpdated []SSORoleMapping
+	t.dropped = nil
 
 	for _, roleMapping := range roleMappings {
 
-		// Check if Role Mapping needs translation. If not,
-		// skip this itteration and add object to updated list
-		if (roleMapping.PermissionSet == "") || (roleMapping.RoleARN != "") {
-			//Check if rolemapping requires fetching the accountid of the aws account
-			if(strings.Contains(roleMapping.RoleARN, "$ACCOUNTID")) {
-				logger.Info("Replacing $ACCOUNTID with Actual account ID")
-				roleMapping.RoleARN = strings.ReplaceAll(roleMapping.RoleARN, "$ACCOUNTID", accountId)
-				roleMappingsUpdated = append(roleMappingsUpdated, roleMapping)
+		// Render templates of fields selecting the role, as selector may reference variables too
+		roleMapping, err := t.renderRoleMappingSelector(roleMapping, accountId)
+		if err != nil {
+			t.dropRoleMapping(roleMapping.PermissionSet, fmt.Sprintf("Templates of role mapping for %s permission set are not valid. Removing mapping from the list", roleMapping.PermissionSet), err)
+			continue
+		}
+
+		// Expand permission set selector into one role mapping per matched permission set
+		expanded := []SSORoleMapping{roleMapping}
+		if roleMapping.RoleARN == "" && isPermissionSetSelector(roleMapping.PermissionSet) {
+			expanded, err = t.expandRoleMapping(roleMapping, awsIAMRoles, accountId)
+			if err != nil {
+				t.dropRoleMapping(roleMapping.PermissionSet, fmt.Sprintf("Permission sets that would correspond to %s selector not found. Removing mapping from the list", roleMapping.PermissionSet), err)
 				continue
-			} else {	
-				logger.Debug("Role Mapping does not need to be translated", zap.Any("roleMapping", roleMapping))
-				roleMappingsUpdated = append(roleMappingsUpdated, roleMapping)
+			}
+		}
+
+		for _, mapping := range expanded {
+			role, err := t.resolveRoleMapping(mapping, awsIAMRoles, accountId)
+			if err != nil {
+				t.dropRoleMapping(mapping.PermissionSet, fmt.Sprintf("Role that would correspond to %s permission set not found. Removing mapping from the list", mapping.PermissionSet), err)
 				continue
 			}
+
+			roleMappingsUpdated = append(roleMappingsUpdated, role)
 		}
 
+	}
+	logger.Info("Translation finished successfully")
+	return roleMappingsUpdated
+}
+
+// resolveRoleMapping translates a single role mapping into the format expected by aws-iam-authenticator.
+//
+// Permission set name is replaced with the ARN of corresponding role, and templates of role ARN, username,
+// groups and user ID are rendered. When role mapping defines other account, only roles of that account
+// are considered and $ACCOUNTID refers to its ID instead. Account and permission set are expected to be
+// rendered by renderRoleMappingSelector beforehand.
+//
+// Parameters:
+// - roleMapping: The role mapping to translate.
+// - awsIAMRoles: The SSO roles retrieved from AWS IAM of all referenced accounts.
+// - accountId: The AWS account ID where this application runs.
+//
+// Returns:
+// - SSORoleMapping: The translated role mapping.
+// - error: An error if the permission set could not be resolved to a role, or if templates could not be rendered.
+func (t *translator) resolveRoleMapping(roleMapping SSORoleMapping, awsIAMRoles []types.Role, accountId string) (SSORoleMapping, error) {
+
+	// Role Mapping of other account is resolved against roles and account ID of that account
+	crossAccount := roleMapping.Account != "" && roleMapping.Account != accountId
+	if roleMapping.Account != "" {
+		accountId = roleMapping.Account
+	}
+	roleMapping.Account = ""
+	variables := t.templateVariables(accountId, crossAccount)
+
+	// Check if Role Mapping needs translation. If not, only render its role ARN
+	if (roleMapping.PermissionSet == "") || (roleMapping.RoleARN != "") {
+		roleARN, err := renderTemplate(roleMapping.RoleARN, variables)
+		if err != nil {
+			return roleMapping, fmt.Errorf("rolearn: %w", err)
+		}
+		roleMapping.RoleARN = roleARN
+		if roleMapping.PermissionSet != "" {
+			variables["PERMISSIONSET"] = templateValue(roleMapping.PermissionSet)
+		} else {
+			variables["PERMISSIONSET"] = templateUnavailable("role mapping does not define permission set")
+		}
+		logger.Debug("Role Mapping does not need to be translated", zap.Any("roleMapping", roleMapping))
+	} else {
 		// Translate permission set name to ARN
-		role, err := translatePermissionSetNameToARN(roleMapping, awsIAMRoles)
+		variables["PERMISSIONSET"] = templateValue(roleMapping.PermissionSet)
+		role, err := t.resolvePermissionSet(roleMapping, rolesInAccount(awsIAMRoles, accountId))
 		if err != nil {
-			logger.Warn(fmt.Sprintf("Role that would correspond to %s permission set not found. Removing mapping from the list", roleMapping.PermissionSet), zap.Error(err))
-			continue
+			return roleMapping, err
 		}
-
+		roleMapping = role
 		logger.Debug("Role Mapping successfully translated", zap.Any("roleMapping", roleMapping))
-		roleMappingsUpdated = append(roleMappingsUpdated, role)
-
 	}
-	logger.Info("Translation finished successfully")
-	return roleMappingsUpdated
+	variables["ROLENAME"] = templateValue(roleNameFromARN(roleMapping.RoleARN))
+
+	return renderRoleMappingTemplates(roleMapping, variables)
 }
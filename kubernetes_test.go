@@ -4,26 +4,65 @@ import (
 	"context"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/iam/types"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 )
 
 func init() {
 	setupLogger(true)
 }
 
+// waitForEvents returns events of the given namespace once at least count of them are written, as event recorder
+// writes them asynchronously, or once waiting times out.
+func waitForEvents(t *testing.T, clientset kubernetes.Interface, namespaceName string, count int) []v1.Event {
+	t.Helper()
+
+	var events *v1.EventList
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		var err error
+		events, err = clientset.CoreV1().Events(namespaceName).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if len(events.Items) >= count || time.Now().After(deadline) {
+			return events.Items
+		}
+	}
+}
+
+// useFakeEventRecorder makes events emitted with the given clientset recorded by a fake recorder, whose channel
+// receives them synchronously.
+func useFakeEventRecorder(t *testing.T, clientset kubernetes.Interface) *record.FakeRecorder {
+	recorder := record.NewFakeRecorder(100)
+
+	eventRecordersMu.Lock()
+	eventRecorders[clientset] = recorder
+	eventRecordersMu.Unlock()
+
+	t.Cleanup(func() {
+		eventRecordersMu.Lock()
+		delete(eventRecorders, clientset)
+		eventRecordersMu.Unlock()
+	})
+
+	return recorder
+}
+
 func TestGetConfigMap(t *testing.T) {
 	// Test when ConfigMap does not exist
 	t.Run("ConfigMap does not exist", func(t *testing.T) {
 
 		fakeClientSet := fake.NewSimpleClientset()
 
-		_, err := getConfigMap(fakeClientSet, "NOT_EXISTING_CONFIGMAP", "NOT_EXISTING_NAMESPASCE")
+		_, err := getConfigMap(context.Background(), fakeClientSet, "NOT_EXISTING_CONFIGMAP", "NOT_EXISTING_NAMESPASCE")
 		if !errors.IsNotFound(err) {
 			t.Errorf("Got unexpected error: %s, was expecting to get NotFound", err)
 		}
@@ -46,7 +85,7 @@ func TestGetConfigMap(t *testing.T) {
 
 		fakeClientSet := fake.NewSimpleClientset(want)
 
-		got, err := getConfigMap(fakeClientSet, want.Name, want.Namespace)
+		got, err := getConfigMap(context.Background(), fakeClientSet, want.Name, want.Namespace)
 
 		if err != nil {
 			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
@@ -76,7 +115,7 @@ func TestSetConfigMap(t *testing.T) {
 		}
 
 		// Create a fake client
-		fakeClientSet := fake.NewSimpleClientset(ns)
+		fakeClientSet := fake.NewClientset(ns)
 
 		// Define data for configMap
 		cmdata := map[string]string{
@@ -86,7 +125,7 @@ func TestSetConfigMap(t *testing.T) {
 		}
 
 		// Update configMap which does not exist (should create new configMap)
-		err := setConfigMap(fakeClientSet, "NOT_EXISTING_CONFIGMAP", ns.Name, cmdata)
+		err := setConfigMap(context.Background(), fakeClientSet, "NOT_EXISTING_CONFIGMAP", ns.Name, cmdata, nil, "")
 		if err != nil {
 			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
 		}
@@ -107,7 +146,7 @@ func TestSetConfigMap(t *testing.T) {
 	// Test when namespace does exist
 	t.Run("ConfigMap and Namespace does not exist", func(t *testing.T) {
 
-		var fakeClientSet = fake.NewSimpleClientset()
+		var fakeClientSet = fake.NewClientset()
 
 		cmdata := map[string]string{
 			"mapAccounts": "[]\n",
@@ -115,7 +154,7 @@ func TestSetConfigMap(t *testing.T) {
 			"mapRoles":    "- rolearn: arn:aws:iam::123456789012:role/AWSReservedSSO_devops_0123456789abcdef\n  username: devops:{{SessionName}}\n  groups:\n    - system:masters\n",
 		}
 
-		err := setConfigMap(fakeClientSet, "NOT_EXISTING_CONFIGMAP", "NOT_EXISTING_NAMESPACE", cmdata)
+		err := setConfigMap(context.Background(), fakeClientSet, "NOT_EXISTING_CONFIGMAP", "NOT_EXISTING_NAMESPACE", cmdata, nil, "")
 		if err != nil {
 			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
 		}
@@ -166,7 +205,7 @@ func TestSetConfigMap(t *testing.T) {
 		}
 
 		// Create a fake client
-		fakeClientSet := fake.NewSimpleClientset(ns, cm)
+		fakeClientSet := fake.NewClientset(ns, cm)
 
 		cmdata := map[string]string{
 			"mapAccounts": "[]\n",
@@ -174,7 +213,7 @@ func TestSetConfigMap(t *testing.T) {
 			"mapRoles":    "- rolearn: arn:aws:iam::123456789012:role/AWSReservedSSO_devops_0123456789abcdef\n  username: devops:{{SessionName}}\n  groups:\n    - system:masters\n",
 		}
 
-		err := setConfigMap(fakeClientSet, cm.Name, ns.Name, cmdata)
+		err := setConfigMap(context.Background(), fakeClientSet, cm.Name, ns.Name, cmdata, nil, "")
 		if err != nil {
 			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
 		}
@@ -191,6 +230,241 @@ func TestSetConfigMap(t *testing.T) {
 		}
 
 	})
+
+	// Test that fields written by Update operations of previous versions are taken over
+	t.Run("ConfigMap was updated by previous version", func(t *testing.T) {
+
+		fakeClientSet := fake.NewClientset()
+		cm := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "TEST_CONFIGMAP", Namespace: "TEST_NAMESPACE"},
+			Data:       map[string]string{"mapRoles": "[]\n"},
+		}
+		if _, err := fakeClientSet.CoreV1().ConfigMaps(cm.Namespace).Create(context.TODO(), cm, metav1.CreateOptions{FieldManager: fieldManager}); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		cmdata := map[string]string{"mapRoles": "- rolearn: arn:aws:iam::123456789012:role/admin\n"}
+		if err := setConfigMap(context.Background(), fakeClientSet, cm.Name, cm.Namespace, cmdata, nil, ""); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		updatedConfigMap, err := fakeClientSet.CoreV1().ConfigMaps(cm.Namespace).Get(context.TODO(), cm.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if !reflect.DeepEqual(updatedConfigMap.Data, cmdata) {
+			t.Errorf("setConfigMap() created unexpected object: %+v, want %+v", updatedConfigMap.Data, cmdata)
+		}
+		for _, entry := range updatedConfigMap.ManagedFields {
+			if entry.Manager == fieldManager && entry.Operation != metav1.ManagedFieldsOperationApply {
+				t.Errorf("setConfigMap() left %s operation of %s field manager", entry.Operation, fieldManager)
+			}
+		}
+	})
+
+	// Test that fields owned by other field managers are not overwritten
+	t.Run("ConfigMap is managed by another field manager", func(t *testing.T) {
+
+		fakeClientSet := fake.NewClientset()
+		cm := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "TEST_CONFIGMAP", Namespace: "TEST_NAMESPACE"},
+			Data:       map[string]string{"mapRoles": "[]\n"},
+		}
+		created, err := fakeClientSet.CoreV1().ConfigMaps(cm.Namespace).Create(context.TODO(), cm, metav1.CreateOptions{FieldManager: "eksctl"})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		err = setConfigMap(context.Background(), fakeClientSet, cm.Name, cm.Namespace, map[string]string{"mapRoles": "- rolearn: arn:aws:iam::123456789012:role/admin\n"}, nil, created.ResourceVersion)
+		if err == nil {
+			t.Fatalf("setConfigMap() returned nil, was expecting to get an error")
+		}
+		if newReconcileError(StageWriteDestination, err).Retryable() {
+			t.Errorf("Conflict with another field manager was reported as retryable")
+		}
+
+		updatedConfigMap, err := fakeClientSet.CoreV1().ConfigMaps(cm.Namespace).Get(context.TODO(), cm.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if !reflect.DeepEqual(updatedConfigMap.Data, cm.Data) {
+			t.Errorf("setConfigMap() overwrote data of another field manager: %+v, want %+v", updatedConfigMap.Data, cm.Data)
+		}
+
+		events := waitForEvents(t, fakeClientSet, cm.Namespace, 1)
+		if len(events) != 1 || events[0].Reason != eventReasonFieldManagerConflict {
+			t.Errorf("Expected a single %s event, got %+v", eventReasonFieldManagerConflict, events)
+		}
+	})
+
+	// Test that ConfigMap modified after its data was read is not overwritten, and the update is retried
+	t.Run("ConfigMap was modified concurrently", func(t *testing.T) {
+
+		fakeClientSet := fake.NewClientset()
+		cm := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "TEST_CONFIGMAP", Namespace: "TEST_NAMESPACE", ResourceVersion: "1"},
+			Data:       map[string]string{"mapRoles": "[]\n"},
+		}
+		created, err := fakeClientSet.CoreV1().ConfigMaps(cm.Namespace).Create(context.TODO(), cm, metav1.CreateOptions{FieldManager: "eksctl"})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		// Fake clientset does not maintain resource versions, so the version of modification is set explicitly
+		modified := created.DeepCopy()
+		modified.ResourceVersion = "2"
+		modified.Data = map[string]string{"mapRoles": "- rolearn: arn:aws:iam::123456789012:role/node\n"}
+		if _, err := fakeClientSet.CoreV1().ConfigMaps(cm.Namespace).Update(context.TODO(), modified, metav1.UpdateOptions{FieldManager: "kubectl-edit"}); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		err = setConfigMap(context.Background(), fakeClientSet, cm.Name, cm.Namespace, map[string]string{"mapRoles": "- rolearn: arn:aws:iam::123456789012:role/admin\n"}, nil, created.ResourceVersion)
+		if err == nil {
+			t.Fatalf("setConfigMap() returned nil, was expecting to get an error")
+		}
+		if !newReconcileError(StageWriteDestination, err).Retryable() {
+			t.Errorf("Concurrent modification of ConfigMap was reported as not retryable")
+		}
+
+		updatedConfigMap, err := fakeClientSet.CoreV1().ConfigMaps(cm.Namespace).Get(context.TODO(), cm.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if !reflect.DeepEqual(updatedConfigMap.Data, modified.Data) {
+			t.Errorf("setConfigMap() overwrote concurrent modification: %+v, want %+v", updatedConfigMap.Data, modified.Data)
+		}
+	})
+}
+
+func TestEmitEvent(t *testing.T) {
+	// Test that repeated events are aggregated into a single event with increasing count
+	t.Run("Repeated event", func(t *testing.T) {
+		clientset := fake.NewClientset()
+		cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "aws-auth", Namespace: "TEST_NAMESPACE"}}
+
+		emitEvent(clientset, cm, v1.EventTypeWarning, eventReasonLockoutProtection, "mapRoles would lock out administrators")
+		waitForEvents(t, clientset, cm.Namespace, 1)
+		emitEvent(clientset, cm, v1.EventTypeWarning, eventReasonLockoutProtection, "mapRoles would lock out administrators")
+
+		var events []v1.Event
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if events = waitForEvents(t, clientset, cm.Namespace, 1); len(events) == 1 && events[0].Count == 2 {
+				break
+			}
+		}
+		if len(events) != 1 || events[0].Count != 2 || events[0].InvolvedObject.Name != cm.Name {
+			t.Errorf("emitEvent() recorded unexpected events: %+v", events)
+		}
+	})
+}
+
+func TestWatchConfigMap(t *testing.T) {
+
+	// expectTrigger waits for a signal on trigger channel and fails the test if none arrives
+	expectTrigger := func(t *testing.T, trigger <-chan struct{}, want bool) {
+		t.Helper()
+		select {
+		case <-trigger:
+			if !want {
+				t.Errorf("watchConfigMap() sent unexpected signal")
+			}
+		case <-time.After(500 * time.Millisecond):
+			if want {
+				t.Errorf("watchConfigMap() did not send a signal, was expecting one")
+			}
+		}
+	}
+
+	newConfigMap := func(name string, data map[string]string) *v1.ConfigMap {
+		return &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "TEST_NAMESPACE",
+				ResourceVersion: "1",
+			},
+			Data: data,
+		}
+	}
+
+	// Test that existing ConfigMap does not trigger reconciliation, while its changes do
+	t.Run("ConfigMap is updated", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cm := newConfigMap("TEST_CONFIGMAP", map[string]string{"mapRoles": "[]\n"})
+		fakeClientSet := fake.NewSimpleClientset(cm)
+		trigger := make(chan struct{}, 1)
+
+		if err := watchConfigMap(ctx, fakeClientSet, cm.Name, cm.Namespace, false, trigger); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		expectTrigger(t, trigger, false)
+
+		cm.Data["mapRoles"] = "- permissionset: devops\n"
+		cm.ResourceVersion = "2"
+		if _, err := fakeClientSet.CoreV1().ConfigMaps(cm.Namespace).Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		expectTrigger(t, trigger, true)
+	})
+
+	// Test that metadata-only changes are ignored when only data changes are watched
+	t.Run("ConfigMap metadata is updated while watching data only", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cm := newConfigMap("TEST_CONFIGMAP", map[string]string{"mapRoles": "[]\n"})
+		fakeClientSet := fake.NewSimpleClientset(cm)
+		trigger := make(chan struct{}, 1)
+
+		if err := watchConfigMap(ctx, fakeClientSet, cm.Name, cm.Namespace, true, trigger); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		cm.Labels = map[string]string{"foo": "bar"}
+		cm.ResourceVersion = "2"
+		if _, err := fakeClientSet.CoreV1().ConfigMaps(cm.Namespace).Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		expectTrigger(t, trigger, false)
+
+		cm.Data["mapRoles"] = "- permissionset: devops\n"
+		cm.ResourceVersion = "3"
+		if _, err := fakeClientSet.CoreV1().ConfigMaps(cm.Namespace).Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		expectTrigger(t, trigger, true)
+	})
+
+	// Test that creation and deletion of watched ConfigMap triggers reconciliation, while other ConfigMaps are ignored
+	t.Run("ConfigMap is created and deleted", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		fakeClientSet := fake.NewSimpleClientset()
+		trigger := make(chan struct{}, 1)
+
+		if err := watchConfigMap(ctx, fakeClientSet, "TEST_CONFIGMAP", "TEST_NAMESPACE", false, trigger); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		other := newConfigMap("OTHER_CONFIGMAP", nil)
+		if _, err := fakeClientSet.CoreV1().ConfigMaps(other.Namespace).Create(ctx, other, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		expectTrigger(t, trigger, false)
+
+		cm := newConfigMap("TEST_CONFIGMAP", nil)
+		if _, err := fakeClientSet.CoreV1().ConfigMaps(cm.Namespace).Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		expectTrigger(t, trigger, true)
+
+		if err := fakeClientSet.CoreV1().ConfigMaps(cm.Namespace).Delete(ctx, cm.Name, metav1.DeleteOptions{}); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		expectTrigger(t, trigger, true)
+	})
 }
 
 func TestTransformRoleMappings(t *testing.T) {
@@ -228,7 +502,7 @@ func TestTransformRoleMappings(t *testing.T) {
 			},
 		}
 
-		got := transformRoleMappings(mappings, roles, "")
+		got := newTranslator(context.Background(), defaultAWSRegion).transformRoleMappings(mappings, roles, "")
 
 		if !reflect.DeepEqual(got, want) {
 			t.Errorf("TransformRoleMappings() returned unexpected object: %+v, want %+v", got, want)
@@ -275,7 +549,7 @@ func TestTransformRoleMappings(t *testing.T) {
 			},
 		}
 
-		got := transformRoleMappings(mappings, roles, "")
+		got := newTranslator(context.Background(), defaultAWSRegion).transformRoleMappings(mappings, roles, "")
 
 		if !reflect.DeepEqual(got, want) {
 			t.Errorf("TransformRoleMappings() returned unexpected object: %+v, want %+v", got, want)
@@ -327,7 +601,7 @@ func TestTransformRoleMappings(t *testing.T) {
 			},
 		}
 
-		got := transformRoleMappings(mappings, roles, "")
+		got := newTranslator(context.Background(), defaultAWSRegion).transformRoleMappings(mappings, roles, "")
 
 		if !reflect.DeepEqual(got, want) {
 			t.Errorf("TransformRoleMappings() returned unexpected object: %+v, want %+v", got, want)
@@ -367,7 +641,7 @@ func TestTransformRoleMappings(t *testing.T) {
 			},
 		}
 
-		got := transformRoleMappings(mappings, roles, "123456789012")
+		got := newTranslator(context.Background(), defaultAWSRegion).transformRoleMappings(mappings, roles, "123456789012")
 
 		if !reflect.DeepEqual(got, want) {
 			t.Errorf("TransformRoleMappings() returned unexpected object: %+v, want %+v", got, want)
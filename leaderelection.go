@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// getLeaderElectionIdentity returns the identity this replica uses when competing for the lease.
+//
+// It prefers the POD_NAME environment variable (populated via the downward API) and
+// falls back to the hostname, which matches the pod name on Kubernetes.
+//
+// Returns the identity as a string and any error encountered.
+func getLeaderElectionIdentity() (string, error) {
+	if podName := os.Getenv("POD_NAME"); podName != "" {
+		return podName, nil
+	}
+
+	return os.Hostname()
+}
+
+// runWithLeaderElection runs the given function only while this replica holds the leader lease.
+//
+// Parameters:
+//   - ctx: Context which stops the leader election once cancelled. The lease is released on cancellation.
+//   - clientset: Kubernetes clientset used to manage the Lease object.
+//   - leaseName: The name of the Lease object.
+//   - namespaceName: The namespace of the Lease object.
+//   - identity: Unique identity of this replica.
+//   - run: The function executed after leadership is acquired. It must return once its context is cancelled.
+//
+// Returns:
+//   - error: An error if the leader election could not be started.
+func runWithLeaderElection(ctx context.Context, clientset kubernetes.Interface, leaseName string, namespaceName string, identity string, run func(ctx context.Context)) error {
+
+	logger.Info(fmt.Sprintf("Starting leader election for lease %s in namespace %s as %s", leaseName, namespaceName, identity))
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      leaseName,
+			Namespace: namespaceName,
+		},
+		Client: clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   leaderElectionLeaseDuration,
+		RenewDeadline:   leaderElectionRenewDeadline,
+		RetryPeriod:     leaderElectionRetryPeriod,
+		Name:            leaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logger.Info(fmt.Sprintf("Acquired leader lease %s, starting reconciliation", leaseName))
+				run(ctx)
+			},
+			OnStoppedLeading: func() {
+				logger.Info(fmt.Sprintf("Released leader lease %s", leaseName))
+			},
+			OnNewLeader: func(currentLeader string) {
+				if currentLeader != identity {
+					logger.Info(fmt.Sprintf("Replica %s is the current leader, waiting in standby", currentLeader))
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	elector.Run(ctx)
+	return nil
+}
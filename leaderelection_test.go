@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetLeaderElectionIdentity(t *testing.T) {
+	// Test when POD_NAME environment variable is defined
+	t.Run("POD_NAME is defined", func(t *testing.T) {
+		t.Setenv("POD_NAME", "TEST_POD")
+
+		got, err := getLeaderElectionIdentity()
+		if err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		if got != "TEST_POD" {
+			t.Errorf("getLeaderElectionIdentity() = %s, want %s", got, "TEST_POD")
+		}
+	})
+}
+
+func TestRunWithLeaderElection(t *testing.T) {
+	leaderElectionLeaseDuration = 2 * time.Second
+	leaderElectionRenewDeadline = 1 * time.Second
+	leaderElectionRetryPeriod = 100 * time.Millisecond
+
+	// Test that function is executed once lease is acquired and lease is held by this replica
+	t.Run("Lease is acquired", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		fakeClientSet := fake.NewSimpleClientset()
+		holders := make(chan string, 1)
+
+		err := runWithLeaderElection(ctx, fakeClientSet, "TEST_LEASE", "TEST_NAMESPACE", "TEST_POD", func(ctx context.Context) {
+			lease, err := fakeClientSet.CoordinationV1().Leases("TEST_NAMESPACE").Get(ctx, "TEST_LEASE", metav1.GetOptions{})
+			if err != nil {
+				t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+				holders <- ""
+			} else {
+				holders <- *lease.Spec.HolderIdentity
+			}
+			cancel()
+		})
+		if err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		if holder := <-holders; holder != "TEST_POD" {
+			t.Errorf("Lease is held by %q, want %q", holder, "TEST_POD")
+		}
+	})
+
+	// Test that function is not executed while another replica holds the lease
+	t.Run("Lease is held by another replica", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		fakeClientSet := fake.NewSimpleClientset()
+
+		go func() {
+			_ = runWithLeaderElection(ctx, fakeClientSet, "TEST_LEASE", "TEST_NAMESPACE", "LEADER_POD", func(ctx context.Context) {
+				<-ctx.Done()
+			})
+		}()
+
+		// Wait for the first replica to acquire the lease
+		time.Sleep(500 * time.Millisecond)
+
+		standbyCtx, standbyCancel := context.WithTimeout(ctx, 1*time.Second)
+		defer standbyCancel()
+
+		executed := make(chan struct{}, 1)
+		err := runWithLeaderElection(standbyCtx, fakeClientSet, "TEST_LEASE", "TEST_NAMESPACE", "STANDBY_POD", func(ctx context.Context) {
+			executed <- struct{}{}
+		})
+		if err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		if len(executed) > 0 {
+			t.Errorf("runWithLeaderElection() executed function on standby replica")
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// eventReasonLockoutProtection is the reason of events emitted when publishing role mappings is refused
+const eventReasonLockoutProtection = "LockoutProtection"
+
+// countAdminMappings counts role mappings which grant at least one of admin groups.
+//
+// It takes a slice of SSORoleMapping structs and a slice of admin group names.
+// It returns the number of mappings granting admin access.
+func countAdminMappings(mappings []SSORoleMapping, adminGroups []string) int {
+	count := 0
+	for _, mapping := range mappings {
+		for _, group := range mapping.Groups {
+			if slices.Contains(adminGroups, group) {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
+// checkAdminLockout compares admin mappings of currently published and new role mappings.
+//
+// Parameters:
+// - current: The role mappings currently published in destination ConfigMap.
+// - desired: The role mappings to be published.
+// - adminGroups: The Kubernetes groups granting admin access to the cluster.
+// - maxShrinkPercent: The maximum allowed decrease of admin mappings, in percent.
+//
+// Returns:
+// - error: An error if admin mappings would fall to zero or shrink by more than maxShrinkPercent, or nil otherwise.
+func checkAdminLockout(current []SSORoleMapping, desired []SSORoleMapping, adminGroups []string, maxShrinkPercent float64) error {
+	before := countAdminMappings(current, adminGroups)
+	after := countAdminMappings(desired, adminGroups)
+
+	if before == 0 || after >= before {
+		return nil
+	}
+
+	if after == 0 {
+		return fmt.Errorf("refusing to remove all %d mappings granting %s groups", before, strings.Join(adminGroups, ", "))
+	}
+
+	shrink := float64(before-after) / float64(before) * 100
+	if shrink > maxShrinkPercent {
+		return fmt.Errorf("refusing to reduce mappings granting %s groups from %d to %d (%.0f%%), which exceeds allowed %.0f%%", strings.Join(adminGroups, ", "), before, after, shrink, maxShrinkPercent)
+	}
+
+	return nil
+}
+
+// protectFromLockout checks that publishing role mappings would not lock administrators out of the cluster.
+//
+// Role mappings currently published in destination ConfigMap are compared with the new ones. If the check
+// fails, a warning event is emitted on destination ConfigMap. If destination ConfigMap does not exist yet
+// or its mapRoles can not be parsed, there is nothing to compare with and the check passes.
+//
+// Parameters:
+// - ctx: Context of the API calls.
+// - clientset: The Kubernetes clientset.
+// - configMapName: The name of destination ConfigMap.
+// - namespaceName: The namespace of destination ConfigMap.
+// - desired: The role mappings to be published.
+//
+// Returns:
+// - error: An error if role mappings must not be published, or nil otherwise.
+func protectFromLockout(ctx context.Context, clientset kubernetes.Interface, configMapName string, namespaceName string, desired []SSORoleMapping) error {
+	groups := splitList(adminGroups)
+	if len(groups) == 0 {
+		return nil
+	}
+
+	configMap, err := clientset.CoreV1().ConfigMaps(namespaceName).Get(ctx, configMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get configMap %s from namespace %s: %w", configMapName, namespaceName, err)
+	}
+
+	current := []SSORoleMapping{}
+	if err := yaml.Unmarshal([]byte(configMap.Data["mapRoles"]), &current); err != nil {
+		logger.Warn(fmt.Sprintf("Unable to parse mapRoles of ConfigMap %s in namespace %s, skipping lockout protection", configMapName, namespaceName), zap.Error(err))
+		return nil
+	}
+
+	if err := checkAdminLockout(current, desired, groups, maxAdminShrinkPercent); err != nil {
+		emitEvent(clientset, configMap, v1.EventTypeWarning, eventReasonLockoutProtection, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// protectAccessEntriesFromLockout checks that reconciling access entries would not lock administrators out of the cluster.
+//
+// Access entries managed by this application, which reconcileAccessEntries may update or delete, are compared with
+// the desired ones. An access entry grants admin access when it is assigned any of admin groups, or is associated
+// with the access policy those groups are replaced with (e.g. AmazonEKSClusterAdminPolicy for system:masters).
+// Access entries which are not managed by this application are never changed, so they are not counted.
+//
+// Parameters:
+// - ctx: Context of the API calls.
+// - client: EKS client.
+// - clusterName: The name of EKS cluster.
+// - mappings: The role mappings to be published.
+//
+// Returns:
+// - error: An error if access entries must not be reconciled, or nil otherwise.
+func protectAccessEntriesFromLockout(ctx context.Context, client eksAccessEntriesAPI, clusterName string, mappings []SSORoleMapping) error {
+	groups := splitList(adminGroups)
+	if len(groups) == 0 {
+		return nil
+	}
+
+	existing, err := listAccessEntries(ctx, client, clusterName)
+	if err != nil {
+		return err
+	}
+
+	current := []SSORoleMapping{}
+	for _, principalARN := range sortedKeys(existing) {
+		entry := existing[principalARN]
+		if entry.Tags[accessEntryManagedTag] != "true" {
+			continue
+		}
+		policies, err := listAccessPolicies(ctx, client, clusterName, principalARN)
+		if err != nil {
+			return err
+		}
+		current = append(current, accessEntryRoleMapping(principalARN, entry.KubernetesGroups, policies))
+	}
+
+	desired := []SSORoleMapping{}
+	for principalARN, entry := range buildAccessEntries(mappings) {
+		if other, ok := existing[principalARN]; ok && other.Tags[accessEntryManagedTag] != "true" {
+			continue
+		}
+		desired = append(desired, accessEntryRoleMapping(principalARN, entry.KubernetesGroups, entry.AccessPolicies))
+	}
+
+	return checkAdminLockout(current, desired, groups, maxAdminShrinkPercent)
+}
+
+// accessEntryRoleMapping returns role mapping equivalent to an access entry, whose access policies are replaced
+// with Kubernetes groups they stand for, so that it can be compared by checkAdminLockout.
+func accessEntryRoleMapping(principalARN string, kubernetesGroups []string, accessPolicies []string) SSORoleMapping {
+	groups := append([]string{}, kubernetesGroups...)
+	for _, group := range sortedKeys(groupAccessPolicies) {
+		if slices.Contains(accessPolicies, accessPolicyARN(principalPartition(principalARN), groupAccessPolicies[group])) {
+			groups = append(groups, group)
+		}
+	}
+	return SSORoleMapping{RoleARN: principalARN, Groups: groups}
+}
+
+// splitList splits comma separated list into a slice, omitting empty elements.
+func splitList(list string) []string {
+	result := []string{}
+	for _, element := range strings.Split(list, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			result = append(result, element)
+		}
+	}
+	return result
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheckAdminLockout(t *testing.T) {
+	admin := func(name string) SSORoleMapping {
+		return SSORoleMapping{RoleARN: "arn:aws:iam::000000000000:role/" + name, Username: name, Groups: []string{"system:masters"}}
+	}
+	viewer := SSORoleMapping{RoleARN: "arn:aws:iam::000000000000:role/viewer", Username: "viewer", Groups: []string{"viewers"}}
+
+	tests := []struct {
+		name     string
+		current  []SSORoleMapping
+		desired  []SSORoleMapping
+		wantFail bool
+	}{
+		{"No admin mappings published yet", []SSORoleMapping{viewer}, []SSORoleMapping{viewer}, false},
+		{"Admin mappings unchanged", []SSORoleMapping{admin("a"), viewer}, []SSORoleMapping{admin("a")}, false},
+		{"Admin mappings grow", []SSORoleMapping{admin("a")}, []SSORoleMapping{admin("a"), admin("b")}, false},
+		{"Admin mappings shrink within limit", []SSORoleMapping{admin("a"), admin("b")}, []SSORoleMapping{admin("a")}, false},
+		{"Admin mappings shrink above limit", []SSORoleMapping{admin("a"), admin("b"), admin("c"), admin("d")}, []SSORoleMapping{admin("a")}, true},
+		{"Admin mappings fall to zero", []SSORoleMapping{admin("a")}, []SSORoleMapping{viewer}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkAdminLockout(tt.current, tt.desired, []string{"system:masters"}, 50)
+			if tt.wantFail && err == nil {
+				t.Errorf("checkAdminLockout() returned nil, was expecting to get an error")
+			} else if !tt.wantFail && err != nil {
+				t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+			}
+		})
+	}
+}
+
+func TestProtectFromLockout(t *testing.T) {
+	adminGroups = "system:masters"
+	maxAdminShrinkPercent = 50
+
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "aws-auth",
+			Namespace: "kube-system",
+		},
+		Data: map[string]string{
+			"mapRoles": "- rolearn: arn:aws:iam::000000000000:role/admin\n  username: admin\n  groups:\n  - system:masters\n",
+		},
+	}
+
+	// Test that check passes when destination ConfigMap does not exist
+	t.Run("ConfigMap does not exist", func(t *testing.T) {
+		fakeClientSet := fake.NewSimpleClientset()
+
+		if err := protectFromLockout(context.Background(), fakeClientSet, "aws-auth", "kube-system", []SSORoleMapping{}); err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+	})
+
+	// Test that check fails and an event is emitted when all admin mappings would be removed
+	t.Run("All admin mappings removed", func(t *testing.T) {
+		fakeClientSet := fake.NewSimpleClientset(configMap)
+
+		if err := protectFromLockout(context.Background(), fakeClientSet, "aws-auth", "kube-system", []SSORoleMapping{}); err == nil {
+			t.Errorf("protectFromLockout() returned nil, was expecting to get an error")
+		}
+
+		events := waitForEvents(t, fakeClientSet, "kube-system", 1)
+		if len(events) != 1 || events[0].Reason != eventReasonLockoutProtection || events[0].InvolvedObject.Name != "aws-auth" {
+			t.Errorf("protectFromLockout() emitted unexpected events: %+v", events)
+		}
+	})
+
+	// Test that check passes when admin mappings are kept
+	t.Run("Admin mappings kept", func(t *testing.T) {
+		fakeClientSet := fake.NewSimpleClientset(configMap)
+		desired := []SSORoleMapping{{RoleARN: "arn:aws:iam::000000000000:role/admin", Username: "admin", Groups: []string{"system:masters"}}}
+
+		if err := protectFromLockout(context.Background(), fakeClientSet, "aws-auth", "kube-system", desired); err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+	})
+}
+
+func TestProtectAccessEntriesFromLockout(t *testing.T) {
+	adminGroups = "system:masters,admins"
+	maxAdminShrinkPercent = 50
+	t.Cleanup(func() { adminGroups = "system:masters" })
+
+	managed := map[string]string{accessEntryManagedTag: "true"}
+	admin := "arn:aws:iam::123456789012:role/AWSReservedSSO_admin_0123456789abcdef"
+	newClient := func() *fakeEKSClient {
+		client := newFakeEKSClient(
+			ekstypes.AccessEntry{PrincipalArn: aws.String(admin), Type: aws.String(accessEntryTypeStandard), Tags: managed},
+			ekstypes.AccessEntry{PrincipalArn: aws.String("arn:aws:iam::123456789012:role/ops"), Type: aws.String(accessEntryTypeStandard), KubernetesGroups: []string{"admins"}, Tags: managed},
+			ekstypes.AccessEntry{PrincipalArn: aws.String("arn:aws:iam::123456789012:role/cluster-creator"), Type: aws.String(accessEntryTypeStandard)},
+		)
+		client.policies[admin] = []string{accessPolicyARN("aws", clusterAdminAccessPolicy)}
+		client.policies["arn:aws:iam::123456789012:role/cluster-creator"] = []string{accessPolicyARN("aws", clusterAdminAccessPolicy)}
+		return client
+	}
+
+	// Test that check fails when managed admin access entries would be removed, regardless of unmanaged ones
+	t.Run("Admin access entries removed", func(t *testing.T) {
+		desired := []SSORoleMapping{{RoleARN: "arn:aws:iam::123456789012:role/cluster-creator", Groups: []string{"system:masters"}}}
+
+		if err := protectAccessEntriesFromLockout(context.TODO(), newClient(), "TEST_CLUSTER", desired); err == nil {
+			t.Errorf("protectAccessEntriesFromLockout() returned nil, was expecting to get an error")
+		}
+	})
+
+	// Test that check passes when admin access entries are kept, either through access policy or admin group
+	t.Run("Admin access entries kept", func(t *testing.T) {
+		desired := []SSORoleMapping{
+			{RoleARN: admin, Groups: []string{"system:masters"}},
+			{RoleARN: "arn:aws:iam::123456789012:role/ops", Groups: []string{"admins"}},
+		}
+
+		if err := protectAccessEntriesFromLockout(context.TODO(), newClient(), "TEST_CLUSTER", desired); err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+	})
+}
@@ -1,27 +1,84 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"reflect"
+	"sync"
+	"syscall"
 	"time"
 
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 )
 
 var (
 	logger                    *zap.Logger
 	sourceConfigMapName       string
 	sourceNamespaceName       string
+	sourceSelector            string
+	sourceNamespaceNames      string
+	platformNamespaceNames    string
 	destinationConfigMapName  string
 	destinationNamespaceName  string
 	defaultAWSRegion          string
 	debug                     bool
 	interval                  int
 	disableAutoWorkerNodeRole bool
+	disableWatch              bool
+
+	leaderElect                 bool
+	leaderElectionLeaseName     string
+	leaderElectionNamespace     string
+	leaderElectionLeaseDuration time.Duration
+	leaderElectionRenewDeadline time.Duration
+	leaderElectionRetryPeriod   time.Duration
+
+	retryAttempts     int
+	retryInitialDelay time.Duration
+	retryMaxDelay     time.Duration
+
+	httpAddress                string
+	livenessIntervalMultiplier float64
+
+	outputMode     string
+	eksClusterName string
+
+	adminGroups           string
+	maxAdminShrinkPercent float64
+
+	enableCRD bool
+
+	ssoRegion      string
+	ssoInstanceARN string
+
+	crossAccountRoleName string
+
+	roleCacheTTL time.Duration
+
+	sqsQueueURL    string
+	sqsEndpointURL string
+
+	statusConfigMapName string
+
+	webhookAddress  string
+	webhookCertFile string
+	webhookKeyFile  string
+
+	backupRetention     int
+	backupKind          string
+	backupNamespaceName string
+
+	pipelinesConfigFile string
+	pipelineName        string
 )
 
 // init is a special function in Go that is automatically called before the main function.
@@ -31,32 +88,196 @@ func init() {
 // main is the entry point of the program.
 //
 // It initializes a scheduler to periodically execute the updateRoleMappings function.
-// The scheduler runs every interval seconds.
+// The scheduler runs every interval seconds and additionally whenever source or
+// destination ConfigMap is changed, unless watching is disabled. When leader election
+// is enabled, the scheduler only runs while this replica holds the leader lease.
+// When the first argument is "render" or "diff", transformed ConfigMap or its differences from
+// destination ConfigMap are printed instead, see runRender and runDiff. "rollback" and "resume"
+// restore a backup of destination ConfigMap and resume its reconciliation, see runRollback and runResume.
+// Admission webhook validating source ConfigMap is served by every replica when -webhook-address is defined.
+// Every pipeline defined by -pipelines-config, or by -src-* and -dst-* flags, has its own scheduler, see runPipeline.
 //
 // No parameters are required.
 // No return types.
 func main() {
-	parseCliArgs()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "render":
+			os.Exit(runRender(ctx, os.Args[2:], os.Stdout, os.Stderr))
+		case "diff":
+			parseCliArgs(os.Args[2:])
+			setupLogger(debug)
+			os.Exit(runDiff(ctx, os.Stdout))
+		case "rollback":
+			revision := flag.Int("to", 0, "Revision of destination ConfigMap backup to restore. If not defined, available backups are listed")
+			parseCliArgs(os.Args[2:])
+			setupLogger(debug)
+			os.Exit(runRollback(ctx, *revision, os.Stdout))
+		case "resume":
+			parseCliArgs(os.Args[2:])
+			setupLogger(debug)
+			os.Exit(runResume(ctx, os.Stdout))
+		}
+	}
+
+	parseCliArgs(os.Args[1:])
 	setupLogger(debug)
-	done := scheduler(updateRoleMappings, time.Duration(interval)*time.Second)
-	defer close(done)
+
+	if httpAddress != "" {
+		livenessMaxAge := time.Duration(float64(interval)*livenessIntervalMultiplier) * time.Second
+		startHTTPServer(ctx, httpAddress, newHTTPHandler(livenessMaxAge))
+	}
+
+	// Admission webhook is served by every replica, regardless of leader election
+	if webhookAddress != "" {
+		startHTTPSServer(ctx, webhookAddress, webhookCertFile, webhookKeyFile, newWebhookHandler())
+	}
+
+	if !leaderElect {
+		run(ctx)
+		return
+	}
+
+	clientset, err := getKubernetesClientSet()
+	if err != nil {
+		logger.Fatal("Failed to create Kubernetes clientset", zap.Error(err))
+	}
+
+	if leaderElectionNamespace == "" {
+		leaderElectionNamespace, err = getCurrentNamespace()
+		if err != nil {
+			logger.Fatal("Failed to get current namespace", zap.Error(err))
+		}
+	}
+
+	healthStatus.setStandby(true)
+
+	identity, err := getLeaderElectionIdentity()
+	if err != nil {
+		logger.Fatal("Failed to determine leader election identity", zap.Error(err))
+	}
+
+	err = runWithLeaderElection(ctx, clientset, leaderElectionLeaseName, leaderElectionNamespace, identity, run)
+	if err != nil {
+		logger.Fatal("Failed to start leader election", zap.Error(err))
+	}
+
+	// Leadership can only be lost without cancellation if lease could not be renewed in time. Exit,
+	// so that replica is restarted and rejoins the election instead of running without the lease.
+	if ctx.Err() == nil {
+		logger.Fatal("Lost leader lease, exiting")
+	}
+}
+
+// run starts SQS consumer and all pipelines concurrently, and blocks until the context is cancelled.
+//
+// Refresh events consumed from SQS trigger reconciliation of every pipeline.
+//
+// Parameters:
+// - ctx: Context which stops SQS consumer and pipelines once cancelled.
+func run(ctx context.Context) {
+	triggers := make([]chan struct{}, len(pipelines))
+	for i := range triggers {
+		triggers[i] = make(chan struct{}, 1)
+	}
+
+	if sqsQueueURL != "" {
+		client, err := newSQSClient(ctx, sqsQueueURL, sqsEndpointURL)
+		if err != nil {
+			logger.Error("Failed to create SQS client, refresh events will not be consumed", zap.Error(err))
+		} else {
+			refresh := make(chan struct{}, 1)
+			go consumeRefreshEvents(ctx, client, sqsQueueURL, refresh)
+			go broadcastTrigger(ctx, refresh, triggers)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, p := range pipelines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runPipeline(ctx, p, triggers[i])
+		}()
+	}
+	wg.Wait()
 }
 
 // parseCliArgs parses the command-line arguments and sets the corresponding variables.
 //
-// No parameters.
+// It takes the command-line arguments without the program name (and subcommand, if any).
 // No return type.
-func parseCliArgs() {
+func parseCliArgs(args []string) {
 	// Parse cli arguments
 	flag.StringVar(&sourceConfigMapName, "src-configmap", "aws-auth", "Name of the source Kubernetes ConfigMap to read data from and perform transformation upon")
 	flag.StringVar(&sourceNamespaceName, "src-namespace", "", "Kubernetes namespace from which to read ConfigMap which contains mapRoles with permissionset names. If not defined, current namespace of pod will be used")
+	flag.StringVar(&sourceSelector, "src-selector", "", "Label selector of source ConfigMaps, e.g. \"sso-wrapper/source=true\", whose role mappings are merged into destination ConfigMap. Replaces -src-configmap, and ConfigMaps are only selected in -src-namespaces or -src-namespace, or in all namespaces when neither is defined")
+	flag.StringVar(&sourceNamespaceNames, "src-namespaces", "", "Comma separated list of Kubernetes namespaces from which ConfigMaps matching -src-selector are read, ordered by their precedence from the highest to the lowest. Replaces -src-namespace")
+	flag.StringVar(&platformNamespaceNames, "platform-namespaces", "", "Comma separated list of Kubernetes namespaces whose ConfigMaps matching -src-selector may map -admin-groups and system: groups. ConfigMaps of other namespaces mapping them are skipped")
 	flag.StringVar(&destinationConfigMapName, "dst-configmap", "aws-auth", "Name of the destination Kubernetes ConfigMap which will be updated after transformation")
 	flag.StringVar(&destinationNamespaceName, "dst-namespace", "kube-system", "Name of the destination Kubernetes Namespace where new ConfigMap will be updated")
-	flag.StringVar(&defaultAWSRegion, "aws-region", "us-east-1", "AWS region to use when interacting with IAM service")
+	flag.StringVar(&defaultAWSRegion, "aws-region", "us-east-1", "AWS region to use when interacting with AWS services, unless defined by pipeline. Its partition is used to build ARNs until partition is detected from caller identity")
 	flag.BoolVar(&debug, "debug", false, "Enable debug logging")
 	flag.IntVar(&interval, "interval", 1800, "Interval in seconds on which application will check for updates")
 	flag.BoolVar(&disableAutoWorkerNodeRole, "disable-auto-worker-node-role", false, "Disable automatic injection of worker node IAM role")
-	flag.Parse() // Enable command-line parsing
+	flag.BoolVar(&disableWatch, "disable-watch", false, "Disable watching source and destination ConfigMaps for changes and rely on -interval only")
+	flag.BoolVar(&leaderElect, "leader-elect", false, "Enable leader election, so that only one of multiple replicas updates destination ConfigMap at a time")
+	flag.StringVar(&leaderElectionLeaseName, "leader-election-lease-name", "aws-iam-authenticator-sso-wrapper", "Name of the Kubernetes Lease used for leader election")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "", "Kubernetes namespace of the Lease used for leader election. If not defined, current namespace of pod will be used")
+	flag.DurationVar(&leaderElectionLeaseDuration, "leader-election-lease-duration", 15*time.Second, "Duration that non-leader replicas will wait before attempting to acquire leadership")
+	flag.DurationVar(&leaderElectionRenewDeadline, "leader-election-renew-deadline", 10*time.Second, "Duration that the leader will retry refreshing leadership before giving it up")
+	flag.DurationVar(&leaderElectionRetryPeriod, "leader-election-retry-period", 2*time.Second, "Duration replicas should wait between attempts to acquire or renew leadership")
+	flag.IntVar(&retryAttempts, "retry-attempts", 5, "Maximum number of attempts to update role mappings before waiting for the next interval")
+	flag.DurationVar(&retryInitialDelay, "retry-initial-delay", 5*time.Second, "Delay before retrying failed update of role mappings, doubled after every failed attempt")
+	flag.DurationVar(&retryMaxDelay, "retry-max-delay", 2*time.Minute, "Maximum delay between attempts to update role mappings")
+	flag.StringVar(&httpAddress, "http-address", ":8080", "Address on which HTTP server exposing /metrics, /healthz and /readyz endpoints listens. Set to empty string to disable it")
+	flag.Float64Var(&livenessIntervalMultiplier, "liveness-interval-multiplier", 3, "Number of intervals after which /healthz endpoint fails if no update of role mappings was completed")
+	flag.StringVar(&outputMode, "output", outputConfigMap, fmt.Sprintf("Where to publish translated role mappings: %q updates destination ConfigMap, %q reconciles EKS access entries of -eks-cluster-name cluster", outputConfigMap, outputAccessEntries))
+	flag.StringVar(&eksClusterName, "eks-cluster-name", "", fmt.Sprintf("Name of EKS cluster whose access entries are reconciled when -output=%s", outputAccessEntries))
+	flag.StringVar(&adminGroups, "admin-groups", "system:masters", "Comma separated list of Kubernetes groups granting admin access, which are checked by lockout protection before updating destination ConfigMap. Set to empty string to disable lockout protection")
+	flag.Float64Var(&maxAdminShrinkPercent, "max-admin-shrink-percent", 50, "Maximum decrease, in percent, of role mappings granting -admin-groups allowed in a single update of destination ConfigMap")
+	flag.BoolVar(&enableCRD, "enable-crd", false, "Aggregate role mappings defined by SSORoleMapping custom resources in source namespace together with mapRoles of source ConfigMap. Pipelines defined by -pipelines-config only aggregate custom resources labelled with their name")
+	flag.StringVar(&ssoRegion, "sso-region", "", "AWS region of IAM Identity Center, used by SSO Admin API. If not defined, AWS region of the pipeline is used")
+	flag.StringVar(&ssoInstanceARN, "sso-instance-arn", "", "ARN of IAM Identity Center instance used to resolve permission sets by their exact names or ARNs through SSO Admin API. If not defined, roles are matched by permission set name")
+	flag.StringVar(&crossAccountRoleName, "cross-account-role-name", "", "Name of the IAM role assumed in other AWS accounts referenced by account field of role mappings to list their SSO roles. If not defined, such mappings are not resolved")
+	flag.DurationVar(&roleCacheTTL, "role-cache-ttl", 5*time.Minute, "Duration for which SSO roles retrieved from AWS IAM and permission set names retrieved from AWS SSO Admin are reused by subsequent updates of role mappings. Set to 0 to disable caching")
+	flag.StringVar(&sqsQueueURL, "sqs-queue-url", "", "URL of SQS queue receiving EventBridge events about changes of SSO roles, each of which triggers an immediate update of role mappings. If not defined, events are not consumed")
+	flag.StringVar(&sqsEndpointURL, "sqs-endpoint-url", "", "Endpoint of SQS API, used to consume events from a local SQS compatible service instead of AWS")
+	flag.StringVar(&statusConfigMapName, "status-configmap", "aws-iam-authenticator-sso-wrapper-status", "Name of the ConfigMap in source namespace where outcome of the last update of role mappings is published. Set to empty string to disable it")
+	flag.StringVar(&webhookAddress, "webhook-address", "", "Address on which HTTPS server of admission webhook validating source ConfigMap listens, e.g. \":9443\". If not defined, webhook is disabled")
+	flag.StringVar(&webhookCertFile, "webhook-cert-file", "/etc/webhook/certs/tls.crt", "Path of PEM encoded TLS certificate served by admission webhook")
+	flag.StringVar(&webhookKeyFile, "webhook-key-file", "/etc/webhook/certs/tls.key", "Path of PEM encoded private key of TLS certificate served by admission webhook")
+	flag.IntVar(&backupRetention, "backup-retention", 5, "Number of backups of destination ConfigMap data retained, one of which is created before every change. Set to 0 to disable backups")
+	flag.StringVar(&backupKind, "backup-kind", backupKindConfigMap, fmt.Sprintf("Kind of objects holding backups of destination ConfigMap: %q or %q", backupKindConfigMap, backupKindSecret))
+	flag.StringVar(&backupNamespaceName, "backup-namespace", "", "Kubernetes namespace where backups of destination ConfigMap are stored. If not defined, source namespace is used")
+	flag.StringVar(&pipelinesConfigFile, "pipelines-config", "", "Path of YAML file listing pipelines, i.e. pairs of source and destination ConfigMaps, reconciled concurrently. If not defined, a single pipeline is defined by -src-* and -dst-* flags")
+	flag.StringVar(&pipelineName, "pipeline", "", "Name of the pipeline of -pipelines-config used by diff, rollback and resume subcommands. May be omitted when only one pipeline is defined")
+	flag.CommandLine.Parse(args) // nolint:errcheck // Enable command-line parsing, CommandLine exits on error
+
+	switch {
+	case outputMode != outputConfigMap && outputMode != outputAccessEntries:
+		fmt.Fprintf(flag.CommandLine.Output(), "invalid value %q for flag -output\n", outputMode)
+		flag.Usage()
+		os.Exit(2)
+	case backupKind != backupKindConfigMap && backupKind != backupKindSecret:
+		fmt.Fprintf(flag.CommandLine.Output(), "invalid value %q for flag -backup-kind\n", backupKind)
+		flag.Usage()
+		os.Exit(2)
+	case outputMode == outputAccessEntries && eksClusterName == "":
+		fmt.Fprintf(flag.CommandLine.Output(), "flag -eks-cluster-name is required when -output=%s\n", outputAccessEntries)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	if pipelines, err = loadPipelines(pipelinesConfigFile); err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "invalid value %q for flag -pipelines-config: %s\n", pipelinesConfigFile, err)
+		flag.Usage()
+		os.Exit(2)
+	}
 }
 
 // setupLogger sets up the logger based on the debug flag.
@@ -74,122 +295,253 @@ func setupLogger(debug bool) {
 
 // scheduler schedules the execution of a given function at a specified time interval.
 //
+// The function is executed immediately, then every time the interval elapses or a
+// signal is received on trigger. It blocks until the context is cancelled.
+//
 // Parameters:
-// - f: The function to be executed.
+// - ctx: Context which stops the scheduler once cancelled.
+// - f: The function to be executed. It receives the scheduler's context.
 // - timeInterval: The time interval between function executions.
-//
-// Returns:
-// - done: A channel that can be used to signal the completion of the scheduler.
-func scheduler(f func(), timeInterval time.Duration) chan bool {
+// - trigger: Channel which requests an immediate execution of the function.
+func scheduler(ctx context.Context, f func(ctx context.Context), timeInterval time.Duration, trigger <-chan struct{}) {
 
-	logger.Info(fmt.Sprintf("Starting scheduler to run every %d seconds", timeInterval))
+	logger.Info(fmt.Sprintf("Starting scheduler to run every %s", timeInterval))
 
 	tick := time.NewTicker(timeInterval)
 	defer tick.Stop()
 
-	done := make(chan bool)
-	sigs := make(chan os.Signal, 1)
-
-	// signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	signal.Notify(sigs, os.Interrupt)
-
-	go func() {
-		defer logger.Info("Quitting application due to SIGTERM/SIGINT signal")
-		for {
-			f()
-			select {
-			case <-tick.C:
-				continue
-			case <-done:
-				return
+	for {
+		f(ctx)
+		select {
+		case <-tick.C:
+			continue
+		case <-trigger:
+			logger.Info("Change detected on watched ConfigMaps, reconciling")
+			continue
+		case <-ctx.Done():
+			logger.Info("Quitting application due to SIGTERM/SIGINT signal")
+			return
+		}
+	}
+}
+
+// startWatchers starts informers on the source and destination ConfigMaps.
+//
+// Changes of the source ConfigMap, SSORoleMapping custom resources (when enabled) and,
+// when role mappings are published to ConfigMap, changes of the destination ConfigMap's
+// data (drift) are signalled on trigger, so that they are reconciled without waiting
+// for the next tick.
+//
+// Parameters:
+// - ctx: Context which stops the informers once cancelled.
+// - p: The pipeline whose ConfigMaps are watched.
+// - trigger: Channel which receives a signal for every observed change.
+//
+// Returns:
+// - error: An error if the informers could not be started.
+func startWatchers(ctx context.Context, p *pipeline, trigger chan<- struct{}) error {
+	clientset, err := getKubernetesClientSet()
+	if err != nil {
+		return err
+	}
+
+	sourceNamespace, err := p.sourceNamespace()
+	if err != nil {
+		return err
+	}
+
+	if p.SourceSelector != "" {
+		for _, namespaceName := range p.selectorNamespaces() {
+			if err := watchSelectedConfigMaps(ctx, clientset, p.SourceSelector, namespaceName, trigger); err != nil {
+				return err
 			}
 		}
-	}()
+	} else if err := watchConfigMap(ctx, clientset, p.SourceConfigMap, sourceNamespace, false, trigger); err != nil {
+		return err
+	}
+
+	if *p.EnableCRD {
+		client, err := getDynamicClient()
+		if err != nil {
+			return err
+		}
 
-	<-sigs
-	done <- true
+		if err := watchCustomRoleMappings(ctx, client, sourceNamespace, p.customRoleMappingSelector(), trigger); err != nil {
+			return err
+		}
+	}
 
-	return done
+	if outputMode != outputConfigMap {
+		return nil
+	}
+
+	return watchConfigMap(ctx, clientset, p.DestinationConfigMap, p.DestinationNamespace, true, trigger)
+}
+
+// updateRoleMappings runs reconcile and retries it with exponential backoff on failure.
+//
+// Failures are logged and never terminate the application. As reconcile does not write
+// destination ConfigMap unless all of its inputs were retrieved successfully, the last
+// successfully published role mappings stay in effect until the next run.
+// Outcome of the update is published in status ConfigMap of the pipeline.
+//
+// Parameters:
+// - ctx: Context which aborts retrying once cancelled.
+// - p: The pipeline to reconcile.
+func updateRoleMappings(ctx context.Context, p *pipeline) {
+	policy := RetryPolicy{
+		Attempts:     retryAttempts,
+		InitialDelay: retryInitialDelay,
+		MaxDelay:     retryMaxDelay,
+	}
+
+	err := retryWithBackoff(ctx, policy, instrumentReconcile(p.Name, func(ctx context.Context) error { return reconcile(ctx, p) }))
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to update role mappings of %s, destination ConfigMap is left unchanged", p), zap.Error(err))
+	}
+	healthStatus.cycleCompleted(p.String(), err == nil)
+	completeSyncStatus(ctx, p, err)
 }
 
-// updateRoleMappings updates the role mappings in the configMap.
+// reconcile updates the role mappings in the configMap.
 //
-// This function retrieves the current namespace where the pod is running and
-// reads the configMap template from that namespace. It then unmarshal the
-// RoleMappings from the configMap and reads all the SSO roles from AWS IAM.
-// The function replaces the PermissionSet name with the Role ARN and removes
-// the permission set from the configMap if it is not found. It then marshals
-// the new role mappings into a string format and updates the configMap in the
-// destination namespace.
-func updateRoleMappings() {
+// This function reads the source configMap and transforms its role mappings,
+// as described in desiredRoleMappings. It then marshals the new role mappings
+// into a string format and updates the configMap in the destination namespace,
+// or reconciles EKS access entries when they are used as output.
+//
+// Parameters:
+// - ctx: Context of the reconciliation.
+// - p: The pipeline to reconcile.
+//
+// Returns:
+// - error: A *ReconcileError if any of the steps fails, in which case destination ConfigMap is not written.
+func reconcile(ctx context.Context, p *pipeline) error {
 
-	logger.Info("Starting process...")
+	logger.Info(fmt.Sprintf("Starting process of %s...", p))
 
 	// Creates Kubernetes clientset to authenticate and interact with API
 	clientset, err := getKubernetesClientSet()
 	if err != nil {
-		logger.Panic("Failed to create Kubernetes clientset", zap.Error(err))
+		return newReconcileError(StageKubernetesClient, err)
+	}
+
+	p.status.ResolvedRoleARNs = nil
+	p.status.DroppedRoleMappings = nil
+
+	configMap, roleMappingsUpdated, sources, err := desiredRoleMappings(ctx, clientset, p, false)
+	if err != nil {
+		return err
+	}
+
+	// Report role mappings which were dropped during transformation on source ConfigMaps they come from
+	p.status.ResolvedRoleARNs = roleMappingARNs(roleMappingsUpdated)
+	for _, source := range sources {
+		p.status.DroppedRoleMappings = append(p.status.DroppedRoleMappings, source.Dropped...)
 	}
+	p.recordDroppedRoleMappings(clientset, sources)
+
+	// Publish role mappings as EKS access entries instead of destination configMap
+	if outputMode == outputAccessEntries {
+		client, err := newEKSClient(ctx, p.AWSRegion)
+		if err != nil {
+			return newReconcileError(StageWriteDestination, err)
+		}
+
+		// Refuse to reconcile access entries which would lock administrators out of the cluster
+		if err := protectAccessEntriesFromLockout(ctx, client, eksClusterName, roleMappingsUpdated); err != nil {
+			for _, source := range sources {
+				emitEvent(clientset, source.ConfigMap, v1.EventTypeWarning, eventReasonLockoutProtection, err.Error())
+			}
+			return newReconcileError(StageLockoutProtection, err)
+		}
 
-	// Get name of kubernetes namespace pod is running
-	if sourceNamespaceName == "" {
-		var err error
-		sourceNamespaceName, err = getCurrentNamespace()
+		err = reconcileAccessEntries(ctx, client, eksClusterName, roleMappingsUpdated)
+		for _, source := range sources {
+			recordDestinationWrite(clientset, source.ConfigMap, fmt.Sprintf("access entries of EKS cluster %s", eksClusterName), len(roleMappingsUpdated), err)
+		}
 		if err != nil {
-			logger.Panic("Failed to get current namespace", zap.Error(err))
+			return newReconcileError(StageWriteDestination, err)
 		}
+		p.status.Outcome = syncOutcomeUpdated
+
+		logger.Info("Finished processing access entries")
+		return nil
 	}
 
-	// Read configMap template from current namespace which will be transformed
-	configMap, err := getConfigMap(clientset, sourceConfigMapName, sourceNamespaceName)
+	// Marshal new role mappings into string format and update configMap on destination namespace
+	cmdata, err := buildConfigMapData(configMap.Data, roleMappingsUpdated)
 	if err != nil {
-		logger.Panic(fmt.Sprintf("Failed to get configMap %s from namespace %s", sourceConfigMapName, sourceNamespaceName), zap.Error(err))
+		return newReconcileError(StageMarshalMappings, err)
 	}
 
-	// Unmarshal RoleMappings from configMap
-	// _, roleMappings, _, _ := aiacm.ParseMap(configMap.Data)
-	roleMappings := []SSORoleMapping{}
-	err = yaml.Unmarshal([]byte(configMap.Data["mapRoles"]), &roleMappings)
-	if err != nil {
-		logger.Error("Failed to unmarshal RoleMappings from configMap", zap.Error(err))
+	// Merge role mappings with entries of destination configMap which are managed by others
+	destination, err := getConfigMap(ctx, clientset, p.DestinationConfigMap, p.DestinationNamespace)
+	if err != nil && !errors.IsNotFound(err) {
+		return newReconcileError(StageWriteDestination, err)
 	}
 
-	// Read all SSO roles from AWS IAM
-	awsIAMRoles, err := listSSORoles()
-	if err != nil {
-		logger.Panic("Error occurred while retrieving SSO Roles for AWS IAM service", zap.Error(err))
+	// Leave destination configMap untouched while its reconciliation is paused by rollback subcommand
+	if destination != nil {
+		if reason, paused := destination.Annotations[pausedAnnotation]; paused {
+			logger.Warn(fmt.Sprintf("Reconciliation of ConfigMap %s in namespace %s is paused (%s), skipping update until resume subcommand is run", p.DestinationConfigMap, p.DestinationNamespace, reason))
+			p.status.Outcome = syncOutcomePaused
+			return nil
+		}
 	}
 
-	// Get AWS Account ID where this application runs on
-	accountId, err := getAccountId()
+	cmdata, ownership, err := mergeDestinationData(destination, cmdata)
 	if err != nil {
-		logger.Panic("Failed to read AWS Account ID", zap.Error(err))
+		return newReconcileError(StageMergeDestination, err)
 	}
 
-	// Replace PermissionSet name with Role ARN, if permission set is not found - remove it from configMap
-	roleMappingsUpdated := transformRoleMappings(roleMappings, awsIAMRoles, accountId)
+	// Skip the update if neither source configMap nor SSO roles changed since the last successful update
+	fingerprint := fingerprintConfigMapData(cmdata)
+	if destination != nil && destinationUpToDate(destination.Data, fingerprint, p.lastDestinationFingerprint) {
+		logger.Info(fmt.Sprintf("ConfigMap %s in namespace %s is up to date, skipping update", p.DestinationConfigMap, p.DestinationNamespace))
+		destinationWritesSkipped.WithLabelValues(p.Name).Inc()
+		p.status.Outcome = syncOutcomeUpToDate
+		return nil
+	}
 
-	// Add worker node role bindings if those are absent and not disabled via CLI flag
-	if !disableAutoWorkerNodeRole {
-		iamRoleARN := "arn:aws:iam::" + accountId + ":role/" + getInstanceRole()
-		roleMappingsUpdated = addWorkerNodeRoleBindings(roleMappingsUpdated, iamRoleARN)
-	} 
-	
-	// Marshal new role mappings into string format and update configMap on destination namespace
-	data, err := yaml.Marshal(roleMappingsUpdated) // Marshal new role mappings into string format
-	if err != nil {
-		logger.Panic("Failed to marshal RoleMappings", zap.Error(err))
+	// Refuse to publish role mappings which would lock administrators out of the cluster
+	merged := []SSORoleMapping{}
+	if err := yaml.Unmarshal([]byte(cmdata["mapRoles"]), &merged); err != nil {
+		return newReconcileError(StageMergeDestination, err)
+	}
+	if err := protectFromLockout(ctx, clientset, p.DestinationConfigMap, p.DestinationNamespace, merged); err != nil {
+		return newReconcileError(StageLockoutProtection, err)
 	}
 
-	cmdata := configMap.Data // Read Data from existing configMap and replaces "mapRoles" with new data
-	cmdata["mapRoles"] = string(data)
+	// Snapshot current data of destination configMap, so that it can be restored by rollback subcommand. Destination
+	// already holding desired data, e.g. after a restart, is not changed by the update and needs no backup
+	if destination != nil && fingerprintConfigMapData(destination.Data) != fingerprint {
+		backupNamespace, err := getBackupNamespace(p)
+		if err != nil {
+			return newReconcileError(StageBackupDestination, err)
+		}
+		if _, err := backupDestination(ctx, clientset, backupNamespace, destination); err != nil {
+			return newReconcileError(StageBackupDestination, err)
+		}
+	}
 
-	err = setConfigMap(clientset, destinationConfigMapName, destinationNamespaceName, cmdata) // Update configMap
+	// Update configMap, unless it was modified since its entries were merged, in which case the update is retried
+	resourceVersion := ""
+	if destination != nil {
+		resourceVersion = destination.ResourceVersion
+	}
+	err = setConfigMap(ctx, clientset, p.DestinationConfigMap, p.DestinationNamespace, cmdata, ownership, resourceVersion)
+	for _, source := range sources {
+		recordDestinationWrite(clientset, source.ConfigMap, fmt.Sprintf("ConfigMap %s in namespace %s", p.DestinationConfigMap, p.DestinationNamespace), len(roleMappingsUpdated), err)
+	}
 	if err != nil {
-		logger.Panic("Failed to set configMap", zap.Error(err))
+		return newReconcileError(StageWriteDestination, err)
 	}
+	p.lastDestinationFingerprint = fingerprint
+	p.status.Outcome = syncOutcomeUpdated
 
 	logger.Info("Finished processing configMaps")
+	return nil
 }
 
 func addWorkerNodeRoleBindings(mappings []SSORoleMapping, roleARN string) []SSORoleMapping {
@@ -205,6 +557,142 @@ func addWorkerNodeRoleBindings(mappings []SSORoleMapping, roleARN string) []SSOR
 	return mappings
 }
 
+// desiredRoleMappings computes role mappings which should be published.
+//
+// This function reads source ConfigMaps of the pipeline, see getRoleMappingSources,
+// and reads all the SSO roles from AWS IAM. The function replaces the PermissionSet
+// name with the Role ARN in role mappings of every source and removes the permission
+// set if it is not found, and merges role mappings of all sources by their precedence.
+// Role mappings of SSORoleMapping custom resources are appended when enabled, and
+// the worker node role is injected unless it is disabled for the pipeline.
+//
+// Parameters:
+// - ctx: Context of the API calls.
+// - clientset: The Kubernetes clientset.
+// - p: The pipeline whose role mappings are computed.
+// - dryRun: Whether role mappings are only previewed, in which case status of custom resources is not written, events of skipped sources are not emitted and validation snapshot of the pipeline is kept.
+//
+// Returns:
+// - *v1.ConfigMap: The source ConfigMap, or ConfigMap holding merged data of all sources.
+// - []SSORoleMapping: The transformed role mappings.
+// - []*roleMappingSource: The source ConfigMaps with role mappings removed from each during transformation.
+// - error: A *ReconcileError if any of the steps fails.
+func desiredRoleMappings(ctx context.Context, clientset kubernetes.Interface, p *pipeline, dryRun bool) (*v1.ConfigMap, []SSORoleMapping, []*roleMappingSource, error) {
+
+	// Read configMap templates which will be transformed
+	sources, err := getRoleMappingSources(ctx, clientset, p, dryRun)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	roleMappings := []SSORoleMapping{}
+	for _, source := range sources {
+		roleMappings = append(roleMappings, source.RoleMappings...)
+	}
+
+	// Read custom resources before SSO roles are retrieved, as their role mappings may reference other accounts too
+	var dynamicClient dynamic.Interface
+	var customResources []unstructured.Unstructured
+	if *p.EnableCRD {
+		dynamicClient, err = getDynamicClient()
+		if err != nil {
+			return nil, nil, nil, newReconcileError(StageCustomResources, err)
+		}
+
+		sourceNamespace, err := p.sourceNamespace()
+		if err != nil {
+			return nil, nil, nil, newReconcileError(StageCustomResources, err)
+		}
+
+		customResources, err = listCustomRoleMappings(ctx, dynamicClient, sourceNamespace, p.customRoleMappingSelector())
+		if err != nil {
+			return nil, nil, nil, newReconcileError(StageCustomResources, err)
+		}
+		for i := range customResources {
+			if mapping, err := parseCustomRoleMapping(&customResources[i]); err == nil {
+				roleMappings = append(roleMappings, mapping)
+			}
+		}
+	}
+
+	// Translation state is kept per reconciliation, so that pipelines translate role mappings concurrently
+	t := newTranslator(ctx, p.AWSRegion)
+	awsIAMRoles, accountId, accounts, err := t.prepare(roleMappings)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ssoRolesFound.WithLabelValues(p.Name).Set(float64(len(awsIAMRoles)))
+
+	// Replace PermissionSet name with Role ARN, if permission set is not found - remove it from configMap
+	dropped := 0
+	for _, source := range sources {
+		if p.SourceSelector != "" {
+			logger.Info(fmt.Sprintf("Translating role mappings of ConfigMap %s in namespace %s", source.ConfigMap.Name, source.ConfigMap.Namespace))
+		}
+		source.RoleMappings = t.transformRoleMappings(source.RoleMappings, awsIAMRoles, accountId)
+		for _, mapping := range t.dropped {
+			mapping.Source = sourceName(source.ConfigMap)
+			source.Dropped = append(source.Dropped, mapping)
+		}
+		dropped += len(source.Dropped)
+	}
+	unresolvedPermissionSets.WithLabelValues(p.Name).Set(float64(dropped))
+
+	// Admission webhook validates source ConfigMaps against the roles and resolvers of the last reconciliation
+	if !dryRun {
+		p.setValidationSnapshot(t.validationSnapshot(awsIAMRoles, accountId, accounts))
+	}
+	roleMappingsUpdated := mergeSourceRoleMappings(sources)
+
+	// Copy data of source configMaps, which is merged when there are multiple of them
+	configMap := &v1.ConfigMap{ObjectMeta: sources[0].ConfigMap.ObjectMeta, Data: mergeSourceData(sources)}
+
+	// Append role mappings defined by custom resources, recording outcome of translation in their status unless dry running
+	if *p.EnableCRD {
+		customRoleMappings, unresolved := t.collectCustomRoleMappings(dynamicClient, customResources, awsIAMRoles, accountId, !dryRun)
+		unresolvedPermissionSets.WithLabelValues(p.Name).Add(float64(unresolved))
+		roleMappingsUpdated = append(roleMappingsUpdated, customRoleMappings...)
+	}
+
+	// Add worker node role bindings if those are absent and not disabled via CLI flag
+	if !*p.DisableAutoWorkerNodeRole {
+		instanceRole, err := getInstanceRole(ctx, p.AWSRegion)
+		if err != nil {
+			return nil, nil, nil, newReconcileError(StageInstanceRole, err)
+		}
+		workerNodeRoleARN := iamRoleARN(getPartition(p.AWSRegion), accountId, instanceRole)
+		roleMappingsUpdated = addWorkerNodeRoleBindings(roleMappingsUpdated, workerNodeRoleARN)
+	}
+
+	return configMap, roleMappingsUpdated, sources, nil
+}
+
+// buildConfigMapData returns data of destination ConfigMap.
+//
+// Data of source ConfigMap is copied and its "mapRoles" key is replaced with the given role mappings.
+//
+// Parameters:
+// - sourceData: The data of source ConfigMap.
+// - mappings: The transformed role mappings.
+//
+// Returns:
+// - map[string]string: The data of destination ConfigMap.
+// - error: An error if role mappings could not be marshalled.
+func buildConfigMapData(sourceData map[string]string, mappings []SSORoleMapping) (map[string]string, error) {
+	data, err := yaml.Marshal(mappings)
+	if err != nil {
+		return nil, err
+	}
+
+	cmdata := make(map[string]string, len(sourceData)+1)
+	for key, value := range sourceData {
+		cmdata[key] = value
+	}
+	cmdata["mapRoles"] = string(data)
+
+	return cmdata, nil
+}
+
 // Checks []SSORoleMapping if it contains specific SSORoleMapping
 func contains(mappings []SSORoleMapping, binding SSORoleMapping) bool {
 	for _, m := range mappings {
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "aws_iam_authenticator_sso_wrapper"
+
+var (
+	// metricsRegistry holds all metrics exposed on /metrics endpoint
+	metricsRegistry = prometheus.NewRegistry()
+
+	reconcileTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_total",
+		Help:      "Total number of reconciliation attempts, partitioned by pipeline and result.",
+	}, []string{"pipeline", "result"})
+
+	reconcileErrorsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_errors_total",
+		Help:      "Total number of failed reconciliation attempts, partitioned by pipeline and the stage which failed.",
+	}, []string{"pipeline", "stage"})
+
+	reconcileDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of reconciliation attempts in seconds, partitioned by pipeline and result.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"pipeline", "result"})
+
+	lastSuccessfulReconcile = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_successful_reconcile_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful reconciliation, partitioned by pipeline.",
+	}, []string{"pipeline"})
+
+	ssoRolesFound = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "sso_roles",
+		Help:      "Number of SSO roles found in AWS IAM during the last lookup, partitioned by pipeline.",
+	}, []string{"pipeline"})
+
+	destinationWritesSkipped = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "destination_writes_skipped_total",
+		Help:      "Total number of reconciliations which skipped update of destination ConfigMap, as it was already up to date, partitioned by pipeline.",
+	}, []string{"pipeline"})
+
+	unresolvedPermissionSets = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "unresolved_permission_sets",
+		Help:      "Number of permission sets which could not be resolved to a role and were dropped during the last transformation, partitioned by pipeline.",
+	}, []string{"pipeline"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// initPipelineMetrics exposes metrics of the pipeline before its first reconciliation, so that they are not absent
+// until the pipeline records them.
+func initPipelineMetrics(pipeline string) {
+	ssoRolesFound.WithLabelValues(pipeline)
+	destinationWritesSkipped.WithLabelValues(pipeline)
+	unresolvedPermissionSets.WithLabelValues(pipeline)
+}
+
+// instrumentReconcile wraps a reconcile function with metrics recording its outcome and duration.
+//
+// Parameters:
+// - pipeline: The name of the reconciled pipeline, which labels the metrics. It is empty for pipeline defined by flags.
+// - f: The reconcile function to be instrumented.
+//
+// Returns:
+// - func(ctx context.Context) error: The instrumented function, returning the same error as f.
+func instrumentReconcile(pipeline string, f func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		start := time.Now()
+		err := f(ctx)
+
+		result := "success"
+		if err != nil {
+			result = "error"
+
+			stage := "unknown"
+			var reconcileErr *ReconcileError
+			if errors.As(err, &reconcileErr) {
+				stage = string(reconcileErr.Stage)
+			}
+			reconcileErrorsTotal.WithLabelValues(pipeline, stage).Inc()
+		} else {
+			lastSuccessfulReconcile.WithLabelValues(pipeline).SetToCurrentTime()
+		}
+
+		reconcileTotal.WithLabelValues(pipeline, result).Inc()
+		reconcileDuration.WithLabelValues(pipeline, result).Observe(time.Since(start).Seconds())
+
+		return err
+	}
+}
+
+// metricsHandler returns HTTP handler serving metrics in Prometheus exposition format.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentReconcile(t *testing.T) {
+	// Test that successful reconciliation is counted and sets timestamp of last success
+	t.Run("Reconciliation succeeds", func(t *testing.T) {
+		before := testutil.ToFloat64(reconcileTotal.WithLabelValues("TEST_PIPELINE", "success"))
+
+		err := instrumentReconcile("TEST_PIPELINE", func(ctx context.Context) error { return nil })(context.Background())
+		if err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		if got := testutil.ToFloat64(reconcileTotal.WithLabelValues("TEST_PIPELINE", "success")) - before; got != 1 {
+			t.Errorf("reconcile_total{pipeline=\"TEST_PIPELINE\",result=\"success\"} increased by %v, want 1", got)
+		}
+		if got := testutil.ToFloat64(lastSuccessfulReconcile.WithLabelValues("TEST_PIPELINE")); got == 0 {
+			t.Errorf("last_successful_reconcile_timestamp_seconds = %v, want non-zero timestamp", got)
+		}
+	})
+
+	// Test that failed reconciliation is counted together with the stage which failed
+	t.Run("Reconciliation fails", func(t *testing.T) {
+		before := testutil.ToFloat64(reconcileTotal.WithLabelValues("TEST_PIPELINE", "error"))
+		beforeStage := testutil.ToFloat64(reconcileErrorsTotal.WithLabelValues("TEST_PIPELINE", string(StageListRoles)))
+		want := newReconcileError(StageListRoles, errors.New("throttled"))
+
+		err := instrumentReconcile("TEST_PIPELINE", func(ctx context.Context) error { return want })(context.Background())
+		if !errors.Is(err, want) {
+			t.Errorf("Got unexpected error: %s, was expecting to get %s", err, want)
+		}
+
+		if got := testutil.ToFloat64(reconcileTotal.WithLabelValues("TEST_PIPELINE", "error")) - before; got != 1 {
+			t.Errorf("reconcile_total{pipeline=\"TEST_PIPELINE\",result=\"error\"} increased by %v, want 1", got)
+		}
+		if got := testutil.ToFloat64(reconcileErrorsTotal.WithLabelValues("TEST_PIPELINE", string(StageListRoles))) - beforeStage; got != 1 {
+			t.Errorf("reconcile_errors_total{pipeline=\"TEST_PIPELINE\",stage=%q} increased by %v, want 1", StageListRoles, got)
+		}
+	})
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	initPipelineMetrics("TEST_PIPELINE")
+	server := httptest.NewServer(newHTTPHandler(time.Hour))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /metrics returned status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+
+	for _, metric := range []string{"aws_iam_authenticator_sso_wrapper_sso_roles", "aws_iam_authenticator_sso_wrapper_unresolved_permission_sets"} {
+		if !strings.Contains(string(body), metric) {
+			t.Errorf("GET /metrics response does not contain %s metric", metric)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// managedRolesAnnotation lists role ARNs of mapRoles entries of destination ConfigMap managed by this application
+	managedRolesAnnotation = "aws-iam-authenticator-sso-wrapper.justinas-b.github.io/managed-roles"
+
+	// managedUsersAnnotation lists user ARNs of mapUsers entries of destination ConfigMap managed by this application
+	managedUsersAnnotation = "aws-iam-authenticator-sso-wrapper.justinas-b.github.io/managed-users"
+
+	// ssoRoleNameMarker identifies ARNs of SSO roles and their sessions, which are adopted when destination has no ownership annotations
+	ssoRoleNameMarker = "/AWSReservedSSO_"
+)
+
+// managedList describes a list of entries in destination ConfigMap, whose entries are owned individually
+type managedList struct {
+	// dataKey is the key of ConfigMap data holding the list
+	dataKey string
+
+	// idField is the field identifying an entry of the list
+	idField string
+
+	// annotation is the annotation of ConfigMap listing IDs of owned entries
+	annotation string
+}
+
+// managedLists are lists of destination ConfigMap merged with entries managed by others
+var managedLists = []managedList{
+	{dataKey: "mapRoles", idField: "rolearn", annotation: managedRolesAnnotation},
+	{dataKey: "mapUsers", idField: "userarn", annotation: managedUsersAnnotation},
+}
+
+// mergeDestinationData merges desired data with data of destination ConfigMap.
+//
+// Entries of mapRoles and mapUsers are owned individually: owned entries are replaced by desired ones, while entries
+// added by others (e.g. eksctl, Karpenter or managed node groups) are preserved, unless desired entry has the same ARN.
+// Owned entries are listed in annotations of destination ConfigMap. If destination has no such annotation yet, entries
+// of SSO roles are adopted as owned. Other keys of desired data replace keys of destination, while keys which only
+// exist in destination are preserved.
+//
+// Parameters:
+// - destination: The destination ConfigMap, or nil if it does not exist.
+// - desired: The desired data built from source ConfigMap.
+//
+// Returns:
+// - map[string]string: The merged data.
+// - map[string]string: The ownership annotations listing entries of merged data managed by this application.
+// - error: An error if entries of destination or desired data could not be parsed.
+func mergeDestinationData(destination *v1.ConfigMap, desired map[string]string) (map[string]string, map[string]string, error) {
+	current := map[string]string{}
+	annotations := map[string]string{}
+	if destination != nil {
+		current = destination.Data
+		annotations = destination.Annotations
+	}
+
+	merged := make(map[string]string, len(current)+len(desired))
+	for key, value := range current {
+		merged[key] = value
+	}
+	for key, value := range desired {
+		merged[key] = value
+	}
+
+	ownership := make(map[string]string, len(managedLists))
+	for _, list := range managedLists {
+		_, inCurrent := current[list.dataKey]
+		_, inDesired := desired[list.dataKey]
+		if !inCurrent && !inDesired {
+			continue
+		}
+
+		owned, adopt := []string{}, true
+		if value, ok := annotations[list.annotation]; ok {
+			adopt = false
+			if err := json.Unmarshal([]byte(value), &owned); err != nil {
+				return nil, nil, fmt.Errorf("invalid %s annotation: %w", list.annotation, err)
+			}
+		}
+
+		data, ids, err := mergeEntries(current[list.dataKey], desired[list.dataKey], list.idField, owned, adopt)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to merge %s: %w", list.dataKey, err)
+		}
+
+		annotation, err := json.Marshal(ids)
+		if err != nil {
+			return nil, nil, err
+		}
+		merged[list.dataKey] = data
+		ownership[list.annotation] = string(annotation)
+	}
+
+	return merged, ownership, nil
+}
+
+// mergeEntries merges desired entries of aws-auth list with entries of current list which are not owned.
+//
+// Parameters:
+// - current: The YAML list of destination ConfigMap.
+// - desired: The YAML list of desired entries.
+// - idField: The field identifying an entry.
+// - owned: The IDs of current entries owned by this application.
+// - adopt: Whether current entries of SSO roles are considered owned, as ownership was not recorded yet.
+//
+// Returns:
+// - string: The merged YAML list, holding entries of others followed by desired entries.
+// - []string: The sorted IDs of desired entries, which are owned after the merge.
+// - error: An error if any of the lists could not be parsed.
+func mergeEntries(current string, desired string, idField string, owned []string, adopt bool) (string, []string, error) {
+	currentEntries := []yaml.MapSlice{}
+	if err := yaml.Unmarshal([]byte(current), &currentEntries); err != nil {
+		return "", nil, fmt.Errorf("failed to parse current entries: %w", err)
+	}
+
+	desiredEntries := []yaml.MapSlice{}
+	if err := yaml.Unmarshal([]byte(desired), &desiredEntries); err != nil {
+		return "", nil, fmt.Errorf("failed to parse desired entries: %w", err)
+	}
+
+	ids := []string{}
+	for _, entry := range desiredEntries {
+		if id := entryID(entry, idField); id != "" && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	merged := []yaml.MapSlice{}
+	for _, entry := range currentEntries {
+		id := entryID(entry, idField)
+		switch {
+		case slices.Contains(owned, id), adopt && strings.Contains(id, ssoRoleNameMarker):
+			continue
+		case slices.Contains(ids, id):
+			logger.Warn(fmt.Sprintf("Entry %s is not managed by this application, but is replaced by a desired entry with the same %s", id, idField))
+			continue
+		}
+		merged = append(merged, entry)
+	}
+	merged = append(merged, desiredEntries...)
+
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return "", nil, err
+	}
+
+	slices.Sort(ids)
+	return string(data), ids, nil
+}
+
+// entryID returns value of the field identifying entry of aws-auth list, or empty string if entry does not have it.
+func entryID(entry yaml.MapSlice, idField string) string {
+	for _, item := range entry {
+		if key, ok := item.Key.(string); ok && key == idField {
+			value, _ := item.Value.(string)
+			return value
+		}
+	}
+	return ""
+}
+
+// mergeDestinationRoleMappings merges desired role mappings with mapRoles entries of destination ConfigMap managed by others.
+//
+// Parameters:
+// - destination: The destination ConfigMap, or nil if it does not exist.
+// - desired: The desired role mappings.
+//
+// Returns:
+// - []SSORoleMapping: The role mappings destination ConfigMap would hold after the update.
+// - error: An error if role mappings could not be merged.
+func mergeDestinationRoleMappings(destination *v1.ConfigMap, desired []SSORoleMapping) ([]SSORoleMapping, error) {
+	data, err := buildConfigMapData(nil, desired)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _, err = mergeDestinationData(destination, data)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := []SSORoleMapping{}
+	if err := yaml.Unmarshal([]byte(data["mapRoles"]), &merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMergeDestinationData(t *testing.T) {
+	desired := map[string]string{
+		"mapRoles": "- rolearn: arn:aws:iam::000000000000:role/AWSReservedSSO_devops_fedcba9876543210\n  username: devops\n  groups:\n  - system:masters\n",
+		"mapUsers": "[]\n",
+	}
+
+	// Test that desired data is used as is when destination does not exist
+	t.Run("Destination does not exist", func(t *testing.T) {
+		got, ownership, err := mergeDestinationData(nil, desired)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if !reflect.DeepEqual(got, desired) {
+			t.Errorf("mergeDestinationData() returned unexpected data: %+v, want %+v", got, desired)
+		}
+		want := map[string]string{
+			managedRolesAnnotation: `["arn:aws:iam::000000000000:role/AWSReservedSSO_devops_fedcba9876543210"]`,
+			managedUsersAnnotation: `[]`,
+		}
+		if !reflect.DeepEqual(ownership, want) {
+			t.Errorf("mergeDestinationData() returned unexpected ownership: %+v, want %+v", ownership, want)
+		}
+	})
+
+	// Test that entries of others are preserved, while owned entries are replaced
+	t.Run("Entries managed by others are preserved", func(t *testing.T) {
+		destination := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					managedRolesAnnotation: `["arn:aws:iam::000000000000:role/AWSReservedSSO_devops_0123456789abcdef"]`,
+				},
+			},
+			Data: map[string]string{
+				"mapRoles": "- rolearn: arn:aws:iam::000000000000:role/KarpenterNodeRole\n  username: system:node:{{EC2PrivateDNSName}}\n  groups:\n  - system:bootstrappers\n  - system:nodes\n" +
+					"- rolearn: arn:aws:iam::000000000000:role/AWSReservedSSO_devops_0123456789abcdef\n  username: devops\n  groups:\n  - system:masters\n" +
+					"- rolearn: arn:aws:iam::000000000000:role/AWSReservedSSO_manual_0123456789abcdef\n  username: manual\n  groups:\n  - viewers\n",
+				"mapUsers":    "- userarn: arn:aws:iam::000000000000:user/admin\n  username: admin\n  groups:\n  - system:masters\n",
+				"mapAccounts": "[]\n",
+			},
+		}
+
+		got, ownership, err := mergeDestinationData(destination, desired)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		want := map[string]string{
+			"mapRoles": "- rolearn: arn:aws:iam::000000000000:role/KarpenterNodeRole\n  username: system:node:{{EC2PrivateDNSName}}\n  groups:\n  - system:bootstrappers\n  - system:nodes\n" +
+				"- rolearn: arn:aws:iam::000000000000:role/AWSReservedSSO_manual_0123456789abcdef\n  username: manual\n  groups:\n  - viewers\n" +
+				"- rolearn: arn:aws:iam::000000000000:role/AWSReservedSSO_devops_fedcba9876543210\n  username: devops\n  groups:\n  - system:masters\n",
+			"mapUsers":    "- userarn: arn:aws:iam::000000000000:user/admin\n  username: admin\n  groups:\n  - system:masters\n",
+			"mapAccounts": "[]\n",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("mergeDestinationData() returned unexpected data: %+v, want %+v", got, want)
+		}
+		if ownership[managedRolesAnnotation] != `["arn:aws:iam::000000000000:role/AWSReservedSSO_devops_fedcba9876543210"]` {
+			t.Errorf("mergeDestinationData() returned unexpected ownership: %+v", ownership)
+		}
+	})
+
+	// Test that entries of SSO roles are adopted when ownership was not recorded yet
+	t.Run("Entries of SSO roles are adopted", func(t *testing.T) {
+		destination := &v1.ConfigMap{
+			Data: map[string]string{
+				"mapRoles": "- rolearn: arn:aws:iam::000000000000:role/KarpenterNodeRole\n  username: karpenter\n  groups:\n  - system:nodes\n" +
+					"- rolearn: arn:aws:iam::000000000000:role/AWSReservedSSO_devops_0123456789abcdef\n  username: devops\n  groups:\n  - system:masters\n",
+			},
+		}
+
+		got, _, err := mergeDestinationData(destination, desired)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		want := "- rolearn: arn:aws:iam::000000000000:role/KarpenterNodeRole\n  username: karpenter\n  groups:\n  - system:nodes\n" +
+			"- rolearn: arn:aws:iam::000000000000:role/AWSReservedSSO_devops_fedcba9876543210\n  username: devops\n  groups:\n  - system:masters\n"
+		if got["mapRoles"] != want {
+			t.Errorf("mergeDestinationData() returned unexpected mapRoles: %s, want %s", got["mapRoles"], want)
+		}
+	})
+
+	// Test that unparsable entries of destination are not overwritten
+	t.Run("Destination entries can not be parsed", func(t *testing.T) {
+		destination := &v1.ConfigMap{Data: map[string]string{"mapRoles": "not a list"}}
+
+		if _, _, err := mergeDestinationData(destination, desired); err == nil {
+			t.Errorf("mergeDestinationData() returned nil, was expecting to get an error")
+		}
+	})
+}
+
+func TestSetConfigMapPreservesMetadata(t *testing.T) {
+	existing := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "aws-auth",
+			Namespace:   "kube-system",
+			Labels:      map[string]string{"app.kubernetes.io/managed-by": "eksctl"},
+			Annotations: map[string]string{"note": "keep"},
+		},
+	}
+	clientset := fake.NewClientset()
+	if _, err := clientset.CoreV1().ConfigMaps("kube-system").Create(context.TODO(), existing, metav1.CreateOptions{FieldManager: "eksctl"}); err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+
+	err := setConfigMap(context.Background(), clientset, "aws-auth", "kube-system", map[string]string{"mapRoles": "- rolearn: arn\n"}, map[string]string{managedRolesAnnotation: `["arn"]`}, "")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+
+	got, err := clientset.CoreV1().ConfigMaps("kube-system").Get(context.TODO(), "aws-auth", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+
+	if !reflect.DeepEqual(got.Labels, existing.Labels) {
+		t.Errorf("setConfigMap() did not preserve labels: %+v", got.Labels)
+	}
+	wantAnnotations := map[string]string{"note": "keep", managedRolesAnnotation: `["arn"]`}
+	if !reflect.DeepEqual(got.Annotations, wantAnnotations) {
+		t.Errorf("setConfigMap() set unexpected annotations: %+v, want %+v", got.Annotations, wantAnnotations)
+	}
+}
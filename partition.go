@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// partitionRegionPrefixes maps prefixes of region names to AWS partitions other than the commercial one
+var partitionRegionPrefixes = []struct {
+	prefix    string
+	partition string
+}{
+	{"cn-", "aws-cn"},
+	{"us-gov-", "aws-us-gov"},
+	{"us-isob-", "aws-iso-b"},
+	{"us-iso-", "aws-iso"},
+	{"eu-isoe-", "aws-iso-e"},
+	{"us-isof-", "aws-iso-f"},
+}
+
+// getPartition returns AWS partition used to build ARNs of the given region.
+//
+// Partition is detected from the ARN of caller identity once AWS account ID is retrieved in the region, and is
+// derived from the region before that, or when caller identity is not retrieved at all (e.g. render subcommand).
+func getPartition(region string) string {
+	awsClientsMu.Lock()
+	defer awsClientsMu.Unlock()
+
+	if clients, ok := awsClients[region]; ok && clients.partition != "" {
+		return clients.partition
+	}
+	return partitionForRegion(region)
+}
+
+// partitionForRegion returns AWS partition the given region belongs to.
+func partitionForRegion(region string) string {
+	for _, p := range partitionRegionPrefixes {
+		if strings.HasPrefix(region, p.prefix) {
+			return p.partition
+		}
+	}
+	return "aws"
+}
+
+// partitionFromARN returns AWS partition of the given ARN (e.g. "aws-us-gov" of "arn:aws-us-gov:sts::000000000000:assumed-role/Foo/bar").
+func partitionFromARN(arn string) (string, error) {
+	parts := strings.SplitN(arn, ":", 3)
+	if len(parts) < 3 || parts[0] != "arn" || parts[1] == "" {
+		return "", fmt.Errorf("%s is not a valid ARN", arn)
+	}
+	return parts[1], nil
+}
+
+// principalPartition returns AWS partition of the given principal ARN, or the commercial partition if ARN is not valid.
+func principalPartition(arn string) string {
+	if partition, err := partitionFromARN(arn); err == nil {
+		return partition
+	}
+	return "aws"
+}
+
+// iamRoleARN returns ARN of IAM role with the given name in the given account and partition.
+func iamRoleARN(partition string, accountId string, roleName string) string {
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, accountId, roleName)
+}
+
+// accessPolicyARN returns ARN of EKS access policy with the given name in the given partition.
+func accessPolicyARN(partition string, policyName string) string {
+	return fmt.Sprintf("arn:%s:eks::aws:cluster-access-policy/%s", partition, policyName)
+}
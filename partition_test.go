@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestPartitionForRegion(t *testing.T) {
+	tests := map[string]string{
+		"us-east-1":      "aws",
+		"eu-west-1":      "aws",
+		"cn-north-1":     "aws-cn",
+		"cn-northwest-1": "aws-cn",
+		"us-gov-west-1":  "aws-us-gov",
+		"us-iso-east-1":  "aws-iso",
+		"us-isob-east-1": "aws-iso-b",
+	}
+
+	for region, want := range tests {
+		if got := partitionForRegion(region); got != want {
+			t.Errorf("partitionForRegion(%s) = %s, want %s", region, got, want)
+		}
+	}
+}
+
+func TestPartitionFromARN(t *testing.T) {
+	got, err := partitionFromARN("arn:aws-us-gov:sts::000000000000:assumed-role/Foo/bar")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+	if got != "aws-us-gov" {
+		t.Errorf("partitionFromARN() = %s, want aws-us-gov", got)
+	}
+
+	if _, err := partitionFromARN("not-an-arn"); err == nil {
+		t.Errorf("partitionFromARN() returned nil, was expecting to get an error")
+	}
+}
+
+func TestGetPartition(t *testing.T) {
+	originalClients := awsClients
+	t.Cleanup(func() {
+		awsClients = originalClients
+	})
+
+	// Test that partition is derived from region until it is detected from caller identity
+	awsClients = map[string]*awsClientSet{"cn-north-1": {}}
+	if got, want := iamRoleARN(getPartition("cn-north-1"), "000000000000", "node"), "arn:aws-cn:iam::000000000000:role/node"; got != want {
+		t.Errorf("iamRoleARN() = %s, want %s", got, want)
+	}
+
+	// Test that partition detected from caller identity takes precedence over region, only in the region it was detected in
+	awsClients["us-gov-west-1"] = &awsClientSet{partition: "aws-us-gov"}
+	if got, want := getPartition("us-gov-west-1"), "aws-us-gov"; got != want {
+		t.Errorf("getPartition() = %s, want %s", got, want)
+	}
+	if got, want := getPartition("us-east-1"), "aws"; got != want {
+		t.Errorf("getPartition() = %s, want %s", got, want)
+	}
+}
+
+func TestPrincipalPartition(t *testing.T) {
+	if got, want := accessPolicyARN(principalPartition("arn:aws-us-gov:iam::000000000000:role/admin"), clusterAdminAccessPolicy), "arn:aws-us-gov:eks::aws:cluster-access-policy/AmazonEKSClusterAdminPolicy"; got != want {
+		t.Errorf("accessPolicyARN() = %s, want %s", got, want)
+	}
+	if got, want := principalPartition("not-an-arn"), "aws"; got != want {
+		t.Errorf("principalPartition() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// pipeline is a source ConfigMap whose role mappings are translated and published to a destination ConfigMap.
+//
+// Pipelines are read from -pipelines-config file, or built from -src-* and -dst-* flags when it is not defined.
+// Options omitted in the file default to values of the corresponding flags.
+type pipeline struct {
+	// Name identifies the pipeline in logs and subcommands, and suffixes the name of its status ConfigMap
+	Name string `json:"name"`
+
+	// SourceConfigMap is the name of the source ConfigMap
+	SourceConfigMap string `json:"srcConfigmap"`
+
+	// SourceNamespace is the namespace of the source ConfigMap. If empty, current namespace of pod is used, or all
+	// namespaces are searched when SourceSelector is defined without SourceNamespaces
+	SourceNamespace string `json:"srcNamespace"`
+
+	// SourceSelector is the label selector of source ConfigMaps, which replaces SourceConfigMap when defined
+	SourceSelector string `json:"srcSelector"`
+
+	// SourceNamespaces are the namespaces from which ConfigMaps matching SourceSelector are read, replacing
+	// SourceNamespace. Their order is the precedence of ConfigMaps, from the highest to the lowest
+	SourceNamespaces []string `json:"srcNamespaces"`
+
+	// PlatformNamespaces are the namespaces whose ConfigMaps matching SourceSelector may map privileged groups,
+	// see isPrivilegedGroup
+	PlatformNamespaces []string `json:"platformNamespaces"`
+
+	// DestinationConfigMap is the name of the destination ConfigMap
+	DestinationConfigMap string `json:"dstConfigmap"`
+
+	// DestinationNamespace is the namespace of the destination ConfigMap
+	DestinationNamespace string `json:"dstNamespace"`
+
+	// DisableAutoWorkerNodeRole disables injection of worker node IAM role into role mappings
+	DisableAutoWorkerNodeRole *bool `json:"disableAutoWorkerNodeRole"`
+
+	// EnableCRD aggregates role mappings defined by SSORoleMapping custom resources in source namespace. Pipelines
+	// defined by -pipelines-config file only aggregate custom resources labelled with their name
+	EnableCRD *bool `json:"enableCrd"`
+
+	// AWSRegion is the region of AWS clients of the pipeline, which is also used by REGION template variable of role mappings
+	AWSRegion string `json:"awsRegion"`
+
+	// lastDestinationFingerprint is the fingerprint of data written to destination ConfigMap by the last successful reconciliation
+	lastDestinationFingerprint string
+
+	// status is the status of role mappings, filled in by reconcile and published by updateRoleMappings
+	status syncStatus
+
+	// reportedDroppedRoleMappings are the role mappings dropped from every source ConfigMap, keyed by sourceName,
+	// which were reported by events of the last reconciliation
+	reportedDroppedRoleMappings map[string][]droppedRoleMapping
+
+	// validation is the snapshot of SSO roles and resolvers of the last reconciliation, read by admission webhook
+	validation   *validationSnapshot
+	validationMu sync.RWMutex
+
+	// validationRefreshMu serializes refreshes of validation snapshot by admission webhook
+	validationRefreshMu sync.Mutex
+}
+
+// pipelinesConfig is the content of -pipelines-config file
+type pipelinesConfig struct {
+	Pipelines []*pipeline `json:"pipelines"`
+}
+
+// pipelines are the pipelines reconciled by this application, loaded by loadPipelines
+var pipelines []*pipeline
+
+// newFlagPipeline returns the pipeline defined by -src-* and -dst-* flags.
+func newFlagPipeline() *pipeline {
+	return &pipeline{
+		SourceConfigMap:           sourceConfigMapName,
+		SourceNamespace:           sourceNamespaceName,
+		SourceSelector:            sourceSelector,
+		SourceNamespaces:          splitList(sourceNamespaceNames),
+		PlatformNamespaces:        splitList(platformNamespaceNames),
+		DestinationConfigMap:      destinationConfigMapName,
+		DestinationNamespace:      destinationNamespaceName,
+		DisableAutoWorkerNodeRole: &disableAutoWorkerNodeRole,
+		EnableCRD:                 &enableCRD,
+		AWSRegion:                 defaultAWSRegion,
+	}
+}
+
+// loadPipelines returns pipelines defined in the given file, or the single pipeline defined by flags when path is empty.
+//
+// Options omitted in the file default to values of the corresponding flags. Every pipeline must have a unique name,
+// which is a DNS label, and a unique destination ConfigMap, as pipelines publishing to the same ConfigMap would
+// overwrite each other's role mappings. EKS access entries are a single destination, so only one pipeline is
+// allowed when they are used as output.
+//
+// Parameters:
+// - path: The path of -pipelines-config file, or empty string.
+//
+// Returns:
+// - []*pipeline: The pipelines to reconcile.
+// - error: An error if the file could not be read or it defines invalid pipelines.
+func loadPipelines(path string) ([]*pipeline, error) {
+	if path == "" {
+		if _, err := labels.Parse(sourceSelector); err != nil {
+			return nil, fmt.Errorf("-src-selector is not valid: %w", err)
+		}
+		return []*pipeline{newFlagPipeline()}, nil
+	}
+
+	config := pipelinesConfig{}
+	if err := readYAMLFile(path, &config); err != nil {
+		return nil, fmt.Errorf("failed to read pipelines from %s: %w", path, err)
+	}
+	if len(config.Pipelines) == 0 {
+		return nil, fmt.Errorf("no pipelines are defined in %s", path)
+	}
+	if outputMode == outputAccessEntries && len(config.Pipelines) > 1 {
+		return nil, fmt.Errorf("only one pipeline can be defined when -output=%s", outputAccessEntries)
+	}
+
+	names := map[string]bool{}
+	destinations := map[string]string{}
+	for i, p := range config.Pipelines {
+		if p == nil {
+			return nil, fmt.Errorf("pipelines[%d]: pipeline must not be empty", i)
+		}
+		if problems := validation.IsDNS1123Label(p.Name); len(p.Name) == 0 || len(problems) > 0 {
+			return nil, fmt.Errorf("pipelines[%d]: name %q is not valid: %s", i, p.Name, strings.Join(problems, "; "))
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("pipelines[%d]: name %s is used by another pipeline", i, p.Name)
+		}
+		names[p.Name] = true
+
+		defaults := newFlagPipeline()
+		if p.SourceNamespaces == nil && p.SourceNamespace == "" {
+			// Source namespace of the pipeline replaces -src-namespaces, like it replaces -src-namespace
+			p.SourceNamespaces = defaults.SourceNamespaces
+		}
+		if p.SourceConfigMap == "" {
+			p.SourceConfigMap = defaults.SourceConfigMap
+		}
+		if p.SourceNamespace == "" {
+			p.SourceNamespace = defaults.SourceNamespace
+		}
+		if p.SourceSelector == "" {
+			p.SourceSelector = defaults.SourceSelector
+		}
+		if _, err := labels.Parse(p.SourceSelector); err != nil {
+			return nil, fmt.Errorf("pipelines[%d]: srcSelector is not valid: %w", i, err)
+		}
+		if p.PlatformNamespaces == nil {
+			p.PlatformNamespaces = defaults.PlatformNamespaces
+		}
+		if p.DestinationConfigMap == "" {
+			p.DestinationConfigMap = defaults.DestinationConfigMap
+		}
+		if p.DestinationNamespace == "" {
+			p.DestinationNamespace = defaults.DestinationNamespace
+		}
+		if p.DisableAutoWorkerNodeRole == nil {
+			p.DisableAutoWorkerNodeRole = defaults.DisableAutoWorkerNodeRole
+		}
+		if p.EnableCRD == nil {
+			p.EnableCRD = defaults.EnableCRD
+		}
+		if p.AWSRegion == "" {
+			p.AWSRegion = defaults.AWSRegion
+		}
+
+		destination := fmt.Sprintf("%s/%s", p.DestinationNamespace, p.DestinationConfigMap)
+		if other, ok := destinations[destination]; ok {
+			return nil, fmt.Errorf("pipelines[%d]: ConfigMap %s in namespace %s is already the destination of pipeline %s", i, p.DestinationConfigMap, p.DestinationNamespace, other)
+		}
+		destinations[destination] = p.Name
+	}
+
+	return config.Pipelines, nil
+}
+
+// selectPipeline returns the pipeline with the given name, which is used by subcommands.
+//
+// Name may be omitted when there is only one pipeline.
+//
+// Parameters:
+// - name: The name of the pipeline, defined by -pipeline flag.
+//
+// Returns:
+// - *pipeline: The selected pipeline.
+// - error: An error if no pipeline, or more than one, matches the name.
+func selectPipeline(name string) (*pipeline, error) {
+	if name == "" {
+		if len(pipelines) != 1 {
+			return nil, fmt.Errorf("-pipeline must be defined, as %d pipelines are configured", len(pipelines))
+		}
+		return pipelines[0], nil
+	}
+
+	for _, p := range pipelines {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("pipeline %s is not defined", name)
+}
+
+// sourceNamespace returns the namespace of source ConfigMap of the pipeline, defaulting to the current namespace of pod.
+func (p *pipeline) sourceNamespace() (string, error) {
+	if p.SourceNamespace != "" {
+		return p.SourceNamespace, nil
+	}
+	return getCurrentNamespace()
+}
+
+// selectorNamespaces returns the namespaces from which ConfigMaps matching source selector are read, ordered by
+// precedence. ConfigMaps of other namespaces are never read. When neither SourceNamespaces nor SourceNamespace is
+// defined, ConfigMaps are read from all namespaces, which is denoted by a single metav1.NamespaceAll.
+func (p *pipeline) selectorNamespaces() []string {
+	if len(p.SourceNamespaces) > 0 {
+		return p.SourceNamespaces
+	}
+	if p.SourceNamespace != "" {
+		return []string{p.SourceNamespace}
+	}
+	return []string{metav1.NamespaceAll}
+}
+
+// selectsAllNamespaces checks whether ConfigMaps matching source selector are read from all namespaces.
+func (p *pipeline) selectsAllNamespaces() bool {
+	return slices.Equal(p.selectorNamespaces(), []string{metav1.NamespaceAll})
+}
+
+// describeSelectorNamespaces returns the namespaces from which ConfigMaps matching source selector are read, as
+// written in logs.
+func (p *pipeline) describeSelectorNamespaces() string {
+	if p.selectsAllNamespaces() {
+		return "all namespaces"
+	}
+	return "namespaces " + strings.Join(p.selectorNamespaces(), ", ")
+}
+
+// customRoleMappingSelector returns the label selector of SSORoleMapping custom resources of the pipeline.
+//
+// Pipelines read from -pipelines-config file may share source namespace, so they only read custom resources
+// labelled with their name, while the pipeline defined by flags reads all of them.
+func (p *pipeline) customRoleMappingSelector() string {
+	if p.Name == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s=%s", ssoRoleMappingPipelineLabel, p.Name)
+}
+
+// setValidationSnapshot replaces the snapshot used by admission webhook to validate source ConfigMaps of the pipeline.
+func (p *pipeline) setValidationSnapshot(snapshot *validationSnapshot) {
+	p.validationMu.Lock()
+	defer p.validationMu.Unlock()
+	p.validation = snapshot
+}
+
+// validationSnapshot returns the snapshot used by admission webhook, or nil if the pipeline was not reconciled yet.
+func (p *pipeline) validationSnapshot() *validationSnapshot {
+	p.validationMu.RLock()
+	defer p.validationMu.RUnlock()
+	return p.validation
+}
+
+// statusConfigMapName returns the name of status ConfigMap of the pipeline, or empty string if status is disabled.
+//
+// Pipelines read from -pipelines-config file may share source namespace, so their name is appended to -status-configmap.
+func (p *pipeline) statusConfigMapName() string {
+	if statusConfigMapName == "" || p.Name == "" {
+		return statusConfigMapName
+	}
+	return fmt.Sprintf("%s-%s", statusConfigMapName, p.Name)
+}
+
+// String returns the description of the pipeline used in logs.
+func (p *pipeline) String() string {
+	if p.Name != "" {
+		return fmt.Sprintf("pipeline %s", p.Name)
+	}
+	return fmt.Sprintf("ConfigMap %s in namespace %s", p.DestinationConfigMap, p.DestinationNamespace)
+}
+
+// runPipeline starts watchers of the pipeline and its scheduler, and blocks until the context is cancelled.
+//
+// Every pipeline has its own scheduler and retries, so failure of one pipeline does not delay the others, and its
+// progress is reported by liveness and readiness probes separately.
+//
+// Parameters:
+// - ctx: Context which stops watchers and the scheduler once cancelled.
+// - p: The pipeline to reconcile.
+// - trigger: Channel which requests an immediate reconciliation of the pipeline.
+func runPipeline(ctx context.Context, p *pipeline, trigger chan struct{}) {
+	if !disableWatch {
+		if err := startWatchers(ctx, p, trigger); err != nil {
+			logger.Error(fmt.Sprintf("Failed to start ConfigMap watchers of %s, falling back to periodic reconciliation only", p), zap.Error(err))
+		}
+	}
+
+	initPipelineMetrics(p.Name)
+	healthStatus.schedulerStarted(p.String())
+	defer healthStatus.schedulerStopped(p.String())
+
+	scheduler(ctx, func(ctx context.Context) { updateRoleMappings(ctx, p) }, time.Duration(interval)*time.Second, trigger)
+}
+
+// broadcastTrigger forwards every signal received on source to all targets, until the context is cancelled.
+//
+// Parameters:
+// - ctx: Context which stops forwarding once cancelled.
+// - source: Channel whose signals are forwarded.
+// - targets: Channels which receive the signals.
+func broadcastTrigger(ctx context.Context, source <-chan struct{}, targets []chan struct{}) {
+	for {
+		select {
+		case <-source:
+			for _, target := range targets {
+				sendTrigger(target)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
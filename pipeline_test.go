@@ -0,0 +1,211 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// usePipelineFlags sets flags which define default options of pipelines for the duration of the test
+func usePipelineFlags(t *testing.T) {
+	originalSource, originalSourceNamespace := sourceConfigMapName, sourceNamespaceName
+	originalDestination, originalDestinationNamespace := destinationConfigMapName, destinationNamespaceName
+	originalWorkerNode, originalRegion, originalOutput := disableAutoWorkerNodeRole, defaultAWSRegion, outputMode
+	originalCRD := enableCRD
+	t.Cleanup(func() {
+		sourceConfigMapName, sourceNamespaceName = originalSource, originalSourceNamespace
+		destinationConfigMapName, destinationNamespaceName = originalDestination, originalDestinationNamespace
+		disableAutoWorkerNodeRole, defaultAWSRegion, outputMode = originalWorkerNode, originalRegion, originalOutput
+		enableCRD = originalCRD
+	})
+
+	sourceConfigMapName, sourceNamespaceName = "aws-auth", "TEST_NAMESPACE"
+	destinationConfigMapName, destinationNamespaceName = "aws-auth", "kube-system"
+	disableAutoWorkerNodeRole, defaultAWSRegion, outputMode = false, "us-east-1", outputConfigMap
+	enableCRD = false
+}
+
+func TestLoadPipelines(t *testing.T) {
+	usePipelineFlags(t)
+
+	// Test that a single pipeline is defined by flags when configuration file is not used
+	t.Run("Pipeline defined by flags", func(t *testing.T) {
+		got, err := loadPipelines("")
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if len(got) != 1 || got[0].Name != "" || got[0].SourceNamespace != "TEST_NAMESPACE" || got[0].DestinationConfigMap != "aws-auth" || *got[0].DisableAutoWorkerNodeRole {
+			t.Errorf("loadPipelines() returned unexpected pipelines: %+v", got)
+		}
+	})
+
+	// Test that options omitted in configuration file default to flags
+	t.Run("Pipelines defined by file", func(t *testing.T) {
+		path := writeTestFile(t, "pipelines.yaml", `pipelines:
+  - name: team-a
+    srcConfigmap: aws-auth-team-a
+    dstConfigmap: aws-auth-team-a
+    dstNamespace: team-a
+    disableAutoWorkerNodeRole: true
+    enableCrd: true
+    awsRegion: eu-west-1
+  - name: team-b
+    srcNamespace: team-b
+`)
+
+		got, err := loadPipelines(path)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("loadPipelines() returned %d pipelines, want %d", len(got), 2)
+		}
+
+		teamA, teamB := got[0], got[1]
+		if teamA.SourceConfigMap != "aws-auth-team-a" || teamA.SourceNamespace != "TEST_NAMESPACE" || teamA.DestinationNamespace != "team-a" || !*teamA.DisableAutoWorkerNodeRole || !*teamA.EnableCRD || teamA.AWSRegion != "eu-west-1" {
+			t.Errorf("loadPipelines() returned unexpected pipeline: %+v", teamA)
+		}
+		if teamB.SourceConfigMap != "aws-auth" || teamB.SourceNamespace != "team-b" || teamB.DestinationConfigMap != "aws-auth" || teamB.DestinationNamespace != "kube-system" || *teamB.DisableAutoWorkerNodeRole || *teamB.EnableCRD || teamB.AWSRegion != "us-east-1" {
+			t.Errorf("loadPipelines() returned unexpected pipeline: %+v", teamB)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "No pipelines",
+			content: "pipelines: []\n",
+			wantErr: "no pipelines are defined",
+		},
+		{
+			name:    "Missing name",
+			content: "pipelines:\n  - srcConfigmap: aws-auth\n",
+			wantErr: "pipelines[0]: name \"\" is not valid",
+		},
+		{
+			name:    "Invalid name",
+			content: "pipelines:\n  - name: Team_A\n",
+			wantErr: "pipelines[0]: name \"Team_A\" is not valid",
+		},
+		{
+			name:    "Duplicate name",
+			content: "pipelines:\n  - name: team-a\n    dstNamespace: team-a\n  - name: team-a\n    dstNamespace: team-b\n",
+			wantErr: "pipelines[1]: name team-a is used by another pipeline",
+		},
+		{
+			name:    "Shared destination",
+			content: "pipelines:\n  - name: team-a\n    srcNamespace: team-a\n  - name: team-b\n    srcNamespace: team-b\n",
+			wantErr: "pipelines[1]: ConfigMap aws-auth in namespace kube-system is already the destination of pipeline team-a",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadPipelines(writeTestFile(t, "pipelines.yaml", tc.content))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Got unexpected error: %v, was expecting to get error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+
+	// Test that source namespaces default to flag, unless source namespace of the pipeline is defined
+	t.Run("Selector namespaces", func(t *testing.T) {
+		original := sourceNamespaceNames
+		t.Cleanup(func() { sourceNamespaceNames = original })
+		sourceNamespaceNames = "platform,team-a"
+
+		got, err := loadPipelines(writeTestFile(t, "pipelines.yaml", `pipelines:
+  - name: teams
+    srcSelector: sso-wrapper/source=true
+    platformNamespaces: [platform]
+  - name: team-b
+    srcSelector: sso-wrapper/source=true
+    srcNamespace: team-b
+    dstNamespace: team-b
+`))
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		teams := got[0].selectorNamespaces()
+		if want := []string{"platform", "team-a"}; !reflect.DeepEqual(teams, want) || !reflect.DeepEqual(got[0].PlatformNamespaces, []string{"platform"}) {
+			t.Errorf("loadPipelines() returned unexpected pipeline: %+v", got[0])
+		}
+		teamB := got[1].selectorNamespaces()
+		if want := []string{"team-b"}; !reflect.DeepEqual(teamB, want) {
+			t.Errorf("selectorNamespaces() = %v, want %v", teamB, want)
+		}
+
+		// Without any namespaces, ConfigMaps matching the selector are read from all namespaces
+		all := &pipeline{SourceSelector: "sso-wrapper/source=true"}
+		if got := all.selectorNamespaces(); !reflect.DeepEqual(got, []string{metav1.NamespaceAll}) || !all.selectsAllNamespaces() {
+			t.Errorf("selectorNamespaces() = %v, want all namespaces", got)
+		}
+	})
+
+	// Test that EKS access entries can not be reconciled by multiple pipelines
+	t.Run("Multiple pipelines with access entries", func(t *testing.T) {
+		outputMode = outputAccessEntries
+		t.Cleanup(func() { outputMode = outputConfigMap })
+
+		_, err := loadPipelines(writeTestFile(t, "pipelines.yaml", "pipelines:\n  - name: team-a\n    dstNamespace: team-a\n  - name: team-b\n    dstNamespace: team-b\n"))
+		if err == nil {
+			t.Errorf("loadPipelines() returned nil, was expecting to get an error")
+		}
+	})
+}
+
+func TestSelectPipeline(t *testing.T) {
+	originalPipelines := pipelines
+	t.Cleanup(func() { pipelines = originalPipelines })
+
+	teamA, teamB := &pipeline{Name: "team-a"}, &pipeline{Name: "team-b"}
+
+	pipelines = []*pipeline{teamA}
+	if got, err := selectPipeline(""); err != nil || got != teamA {
+		t.Errorf("selectPipeline() = %v, %v, was expecting to get the only pipeline", got, err)
+	}
+
+	pipelines = []*pipeline{teamA, teamB}
+	if got, err := selectPipeline("team-b"); err != nil || got != teamB {
+		t.Errorf("selectPipeline() = %v, %v, was expecting to get pipeline team-b", got, err)
+	}
+	if _, err := selectPipeline(""); err == nil {
+		t.Errorf("selectPipeline() returned nil, was expecting to get an error as name is ambiguous")
+	}
+	if _, err := selectPipeline("missing"); err == nil {
+		t.Errorf("selectPipeline() returned nil, was expecting to get an error as pipeline is not defined")
+	}
+}
+
+func TestStatusConfigMapName(t *testing.T) {
+	original := statusConfigMapName
+	t.Cleanup(func() { statusConfigMapName = original })
+
+	statusConfigMapName = "status"
+	if got := (&pipeline{}).statusConfigMapName(); got != "status" {
+		t.Errorf("statusConfigMapName() = %s, want %s", got, "status")
+	}
+	if got := (&pipeline{Name: "team-a"}).statusConfigMapName(); got != "status-team-a" {
+		t.Errorf("statusConfigMapName() = %s, want %s", got, "status-team-a")
+	}
+
+	statusConfigMapName = ""
+	if got := (&pipeline{Name: "team-a"}).statusConfigMapName(); got != "" {
+		t.Errorf("statusConfigMapName() = %s, want empty string", got)
+	}
+}
+
+func TestCustomRoleMappingSelector(t *testing.T) {
+	if got := (&pipeline{}).customRoleMappingSelector(); got != "" {
+		t.Errorf("customRoleMappingSelector() = %s, want empty string", got)
+	}
+	if got, want := (&pipeline{Name: "team-a"}).customRoleMappingSelector(), ssoRoleMappingPipelineLabel+"=team-a"; got != want {
+		t.Errorf("customRoleMappingSelector() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8syaml "sigs.k8s.io/yaml"
+)
+
+// iamRolesFixture is the format of file with IAM roles used instead of live AWS IAM data,
+// matching the output of `aws iam list-roles` command.
+type iamRolesFixture struct {
+	Roles []types.Role `json:"Roles"`
+}
+
+// runRender implements the render subcommand, which prints transformed ConfigMap without writing it to the cluster.
+//
+// Source ConfigMap is read from a file, and IAM roles are either read from a fixture file or retrieved
+// from AWS IAM, after which the same transformation as during reconciliation is applied.
+//
+// Parameters:
+// - ctx: Context of the API calls, cancelled on SIGTERM/SIGINT.
+// - args: The command line arguments following the subcommand name.
+// - stdout: The writer to which the rendered ConfigMap is written.
+// - stderr: The writer to which usage and errors are written.
+//
+// Returns:
+// - int: The exit code, 0 on success, 1 on failure and 2 on invalid arguments.
+func runRender(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	var srcFile, rolesFile, accountId, workerNodeRoleARN, configMapName, namespaceName string
+	var renderDebug bool
+
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&srcFile, "src-file", "", "Path to the file containing source ConfigMap which contains mapRoles with permissionset names (required)")
+	flags.StringVar(&rolesFile, "roles-file", "", "Path to the file with IAM roles in the format of `aws iam list-roles` output. If not defined, roles are retrieved from AWS IAM")
+	flags.StringVar(&accountId, "account-id", "", "AWS account ID used to replace $ACCOUNTID. If not defined, it is retrieved from AWS STS")
+	flags.StringVar(&workerNodeRoleARN, "worker-node-role-arn", "", "ARN of the worker node IAM role to inject. If not defined, worker node role is not injected")
+	flags.StringVar(&configMapName, "dst-configmap", "aws-auth", "Name of the rendered ConfigMap")
+	flags.StringVar(&namespaceName, "dst-namespace", "kube-system", "Namespace of the rendered ConfigMap")
+	flags.StringVar(&defaultAWSRegion, "aws-region", "us-east-1", "AWS region to use when interacting with IAM service")
+	flags.BoolVar(&renderDebug, "debug", false, "Enable debug logging")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if srcFile == "" {
+		fmt.Fprintln(stderr, "flag -src-file is required")
+		flags.Usage()
+		return 2
+	}
+
+	setupLogger(renderDebug)
+
+	configMap, err := renderConfigMap(ctx, srcFile, rolesFile, accountId, workerNodeRoleARN)
+	if err != nil {
+		logger.Error("Failed to render ConfigMap", zap.Error(err))
+		return 1
+	}
+	configMap.Name = configMapName
+	configMap.Namespace = namespaceName
+
+	output, err := k8syaml.Marshal(configMap)
+	if err != nil {
+		logger.Error("Failed to marshal ConfigMap", zap.Error(err))
+		return 1
+	}
+
+	fmt.Fprint(stdout, string(output))
+	return 0
+}
+
+// renderConfigMap reads source ConfigMap from a file and transforms its role mappings.
+//
+// Parameters:
+// - ctx: Context of the API calls.
+// - srcFile: The path to the file containing source ConfigMap.
+// - rolesFile: The path to the file with IAM roles, or empty string to retrieve roles from AWS IAM.
+// - accountId: The AWS account ID, or empty string to retrieve it from AWS STS.
+// - workerNodeRoleARN: The ARN of worker node role to inject, or empty string to skip the injection.
+//
+// Returns:
+// - *v1.ConfigMap: The transformed ConfigMap.
+// - error: An error if any of the inputs could not be read.
+func renderConfigMap(ctx context.Context, srcFile string, rolesFile string, accountId string, workerNodeRoleARN string) (*v1.ConfigMap, error) {
+	source := &v1.ConfigMap{}
+	if err := readYAMLFile(srcFile, source); err != nil {
+		return nil, fmt.Errorf("failed to read source ConfigMap: %w", err)
+	}
+
+	roleMappings := []SSORoleMapping{}
+	if err := yaml.Unmarshal([]byte(source.Data["mapRoles"]), &roleMappings); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal RoleMappings from configMap: %w", err)
+	}
+
+	var awsIAMRoles []types.Role
+	if rolesFile != "" {
+		fixture := iamRolesFixture{}
+		if err := readYAMLFile(rolesFile, &fixture); err != nil {
+			return nil, fmt.Errorf("failed to read IAM roles: %w", err)
+		}
+		awsIAMRoles = fixture.Roles
+	} else {
+		roles, err := listSSORoles(ctx, defaultAWSRegion)
+		if err != nil {
+			return nil, err
+		}
+		awsIAMRoles = roles
+	}
+
+	if accountId == "" {
+		id, err := getAccountId(ctx, defaultAWSRegion)
+		if err != nil {
+			return nil, err
+		}
+		accountId = id
+	}
+
+	roleMappingsUpdated := newTranslator(ctx, defaultAWSRegion).transformRoleMappings(roleMappings, awsIAMRoles, accountId)
+	if workerNodeRoleARN != "" {
+		roleMappingsUpdated = addWorkerNodeRoleBindings(roleMappingsUpdated, workerNodeRoleARN)
+	}
+
+	data, err := buildConfigMapData(source.Data, roleMappingsUpdated)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		Data: data,
+	}, nil
+}
+
+// readYAMLFile reads YAML or JSON file into the given object.
+func readYAMLFile(path string, obj interface{}) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return k8syaml.Unmarshal(content, obj)
+}
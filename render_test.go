@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+const renderSourceConfigMap = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: aws-auth
+  namespace: default
+data:
+  mapUsers: |
+    []
+  mapRoles: |
+    - groups:
+      - system:masters
+      permissionset: AdminRole
+      username: "AdminRole:{{SessionName}}"
+    - groups:
+      - viewers
+      rolearn: arn:aws:iam::$ACCOUNTID:role/generic
+      username: generic
+`
+
+const renderIAMRoles = `{
+  "Roles": [
+    {
+      "Path": "/aws-reserved/sso.amazonaws.com/eu-west-1/",
+      "RoleName": "AWSReservedSSO_AdminRole_0123456789abcdef",
+      "Arn": "arn:aws:iam::000000000000:role/aws-reserved/sso.amazonaws.com/eu-west-1/AWSReservedSSO_AdminRole_0123456789abcdef",
+      "CreateDate": "2023-01-01T00:00:00+00:00"
+    }
+  ]
+}`
+
+// writeTestFile writes content to a file in a temporary directory and returns its path
+func writeTestFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write test file: %s", err)
+	}
+	return path
+}
+
+func TestRenderConfigMap(t *testing.T) {
+	srcFile := writeTestFile(t, "aws-auth.yaml", renderSourceConfigMap)
+	rolesFile := writeTestFile(t, "roles.json", renderIAMRoles)
+
+	want := []SSORoleMapping{
+		{
+			RoleARN:  "arn:aws:iam::000000000000:role/AWSReservedSSO_AdminRole_0123456789abcdef",
+			Username: "AdminRole:{{SessionName}}",
+			Groups:   []string{"system:masters"},
+		},
+		{
+			RoleARN:  "arn:aws:iam::000000000000:role/generic",
+			Username: "generic",
+			Groups:   []string{"viewers"},
+		},
+		{
+			RoleARN:  "arn:aws:iam::000000000000:role/node",
+			Username: "system:node:{{EC2PrivateDNSName}}",
+			Groups:   []string{"system:bootstrappers", "system:nodes"},
+		},
+	}
+
+	configMap, err := renderConfigMap(context.Background(), srcFile, rolesFile, "000000000000", "arn:aws:iam::000000000000:role/node")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+
+	got := []SSORoleMapping{}
+	if err := yaml.Unmarshal([]byte(configMap.Data["mapRoles"]), &got); err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("renderConfigMap() returned unexpected mapRoles: %+v, want %+v", got, want)
+	}
+
+	if configMap.Data["mapUsers"] != "[]\n" {
+		t.Errorf("renderConfigMap() did not preserve mapUsers: %q", configMap.Data["mapUsers"])
+	}
+}
+
+func TestRunRender(t *testing.T) {
+	// Test that missing -src-file is reported as invalid arguments
+	t.Run("Source file not defined", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		if code := runRender(context.Background(), []string{}, &stdout, &stderr); code != 2 {
+			t.Errorf("runRender() returned exit code %d, want 2", code)
+		}
+		if stdout.Len() != 0 {
+			t.Errorf("runRender() unexpectedly wrote to stdout: %s", stdout.String())
+		}
+	})
+
+	// Test that rendered ConfigMap is written to stdout
+	t.Run("ConfigMap rendered", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		args := []string{
+			"-src-file", writeTestFile(t, "aws-auth.yaml", renderSourceConfigMap),
+			"-roles-file", writeTestFile(t, "roles.json", renderIAMRoles),
+			"-account-id", "000000000000",
+			"-dst-namespace", "kube-system",
+		}
+
+		if code := runRender(context.Background(), args, &stdout, &stderr); code != 0 {
+			t.Fatalf("runRender() returned exit code %d, want 0: %s", code, stderr.String())
+		}
+
+		rendered := struct {
+			Kind     string            `yaml:"kind"`
+			Metadata map[string]string `yaml:"metadata"`
+		}{}
+		if err := yaml.Unmarshal(stdout.Bytes(), &rendered); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		want := map[string]string{"name": "aws-auth", "namespace": "kube-system"}
+		if rendered.Kind != "ConfigMap" || !reflect.DeepEqual(rendered.Metadata, want) {
+			t.Errorf("runRender() printed unexpected ConfigMap: %s", stdout.String())
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// retryBackoffFactor is the multiplier applied to the delay after every failed attempt.
+	retryBackoffFactor = 2.0
+
+	// retryBackoffJitter is the maximum fraction of the delay which is randomly added to it.
+	retryBackoffJitter = 0.2
+)
+
+// RetryPolicy defines how failed reconciliations are retried.
+//
+// Delay between attempts grows exponentially starting from InitialDelay and is
+// randomised with jitter, so that replicas of multiple clusters sharing the same
+// AWS account do not retry in lockstep.
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts, including the first one.
+	Attempts int
+
+	// InitialDelay is the delay before the second attempt.
+	InitialDelay time.Duration
+
+	// MaxDelay is the upper limit of the delay between two attempts.
+	MaxDelay time.Duration
+}
+
+// delay returns the time to wait after the given failed attempt.
+//
+// It takes the number of the failed attempt, starting from 1.
+// It returns the delay with jitter applied, which never exceeds MaxDelay.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := time.Duration(float64(p.InitialDelay) * math.Pow(retryBackoffFactor, float64(attempt-1)))
+	if d > p.MaxDelay || d <= 0 { // d <= 0 on overflow
+		d = p.MaxDelay
+	}
+
+	d = wait.Jitter(d, retryBackoffJitter)
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	return d
+}
+
+// retryWithBackoff executes the given function until it succeeds, the attempts are exhausted
+// or the function returns an error which is not retryable.
+//
+// Parameters:
+// - ctx: Context which aborts waiting for the next attempt once cancelled.
+// - policy: The policy which defines the number of attempts and the delays between them.
+// - f: The function to be executed.
+//
+// Returns:
+// - error: The error returned by the last attempt, or nil if the function succeeded.
+func retryWithBackoff(ctx context.Context, policy RetryPolicy, f func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := f(ctx)
+		if err == nil {
+			return nil
+		}
+
+		var reconcileErr *ReconcileError
+		if errors.As(err, &reconcileErr) && !reconcileErr.Retryable() {
+			return err
+		}
+
+		if attempt >= policy.Attempts {
+			return err
+		}
+
+		delay := policy.delay(attempt)
+		logger.Warn(fmt.Sprintf("Attempt %d of %d failed, retrying in %s", attempt, policy.Attempts, delay.Round(time.Millisecond)), zap.Error(err))
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{
+		Attempts:     10,
+		InitialDelay: 1 * time.Second,
+		MaxDelay:     10 * time.Second,
+	}
+
+	// Test that delay grows exponentially and jitter only adds to it
+	t.Run("Delay grows exponentially", func(t *testing.T) {
+		for attempt, want := range map[int]time.Duration{1: 1 * time.Second, 2: 2 * time.Second, 3: 4 * time.Second} {
+			got := policy.delay(attempt)
+			if got < want || got > time.Duration(float64(want)*(1+retryBackoffJitter)) {
+				t.Errorf("delay(%d) = %s, want between %s and %s", attempt, got, want, time.Duration(float64(want)*(1+retryBackoffJitter)))
+			}
+		}
+	})
+
+	// Test that delay never exceeds maximum delay
+	t.Run("Delay is capped", func(t *testing.T) {
+		for _, attempt := range []int{5, 10, 100} {
+			if got := policy.delay(attempt); got != policy.MaxDelay {
+				t.Errorf("delay(%d) = %s, want %s", attempt, got, policy.MaxDelay)
+			}
+		}
+	})
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		Attempts:     3,
+		InitialDelay: 1 * time.Millisecond,
+		MaxDelay:     5 * time.Millisecond,
+	}
+
+	// Test that function is retried until it succeeds
+	t.Run("Succeeds after transient failure", func(t *testing.T) {
+		calls := 0
+		err := retryWithBackoff(context.Background(), policy, func(ctx context.Context) error {
+			calls++
+			if calls < 2 {
+				return newReconcileError(StageListRoles, errors.New("throttled"))
+			}
+			return nil
+		})
+
+		if err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if calls != 2 {
+			t.Errorf("Function was called %d times, want %d", calls, 2)
+		}
+	})
+
+	// Test that function is not retried more times than allowed
+	t.Run("Gives up after all attempts", func(t *testing.T) {
+		calls := 0
+		want := newReconcileError(StageWriteDestination, errors.New("conflict"))
+		err := retryWithBackoff(context.Background(), policy, func(ctx context.Context) error {
+			calls++
+			return want
+		})
+
+		if !errors.Is(err, want) {
+			t.Errorf("Got unexpected error: %s, was expecting to get %s", err, want)
+		}
+		if calls != policy.Attempts {
+			t.Errorf("Function was called %d times, want %d", calls, policy.Attempts)
+		}
+	})
+
+	// Test that errors which can not be resolved by retrying are returned immediately
+	t.Run("Does not retry permanent errors", func(t *testing.T) {
+		calls := 0
+		err := retryWithBackoff(context.Background(), policy, func(ctx context.Context) error {
+			calls++
+			return newReconcileError(StageParseMappings, errors.New("yaml: line 1: did not find expected key"))
+		})
+
+		var reconcileErr *ReconcileError
+		if !errors.As(err, &reconcileErr) || reconcileErr.Stage != StageParseMappings {
+			t.Errorf("Got unexpected error: %s, was expecting to get %s error", err, StageParseMappings)
+		}
+		if calls != 1 {
+			t.Errorf("Function was called %d times, want %d", calls, 1)
+		}
+	})
+
+	// Test that waiting for the next attempt is aborted once context is cancelled
+	t.Run("Stops when context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		err := retryWithBackoff(ctx, RetryPolicy{Attempts: 3, InitialDelay: time.Hour, MaxDelay: time.Hour}, func(ctx context.Context) error {
+			calls++
+			cancel()
+			return newReconcileError(StageAccountID, errors.New("timeout"))
+		})
+
+		if err == nil {
+			t.Errorf("Got nil error, was expecting to get an error")
+		}
+		if calls != 1 {
+			t.Errorf("Function was called %d times, want %d", calls, 1)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"golang.org/x/exp/slices"
+)
+
+// ssoRoleNameRegex extracts permission set name, as it is included in role name, from the name of SSO role
+var ssoRoleNameRegex = regexp.MustCompile("^AWSReservedSSO_(.+)_[[:alnum:]]{16}$")
+
+// isPermissionSetSelector checks whether permission set of a role mapping selects multiple permission sets.
+//
+// Permission set names can not contain "*", "?", "[", "^" or "$" characters, so the value is treated as an
+// anchored regex when it starts with "^" and ends with "$", and as a glob when it contains any of "*?[".
+func isPermissionSetSelector(permissionSet string) bool {
+	return isPermissionSetRegex(permissionSet) || strings.ContainsAny(permissionSet, "*?[")
+}
+
+// isPermissionSetRegex checks whether permission set selector is an anchored regex.
+func isPermissionSetRegex(permissionSet string) bool {
+	return len(permissionSet) > 1 && strings.HasPrefix(permissionSet, "^") && strings.HasSuffix(permissionSet, "$")
+}
+
+// compilePermissionSetSelector returns function matching permission set names selected by glob or anchored regex.
+func compilePermissionSetSelector(selector string) (func(string) bool, error) {
+	if isPermissionSetRegex(selector) {
+		r, err := regexp.Compile(selector)
+		if err != nil {
+			return nil, fmt.Errorf("permission set selector %s is not a valid regex: %w", selector, err)
+		}
+		return r.MatchString, nil
+	}
+
+	if _, err := path.Match(selector, ""); err != nil {
+		return nil, fmt.Errorf("permission set selector %s is not a valid glob: %w", selector, err)
+	}
+	return func(name string) bool {
+		matched, _ := path.Match(selector, name)
+		return matched
+	}, nil
+}
+
+// ssoRolePermissionSetNames returns sorted names of permission sets, as they are included in names of the given SSO roles.
+func ssoRolePermissionSetNames(iamRoles []types.Role, accountId string) []string {
+	var names []string
+	for _, role := range iamRoles {
+		match := ssoRoleNameRegex.FindStringSubmatch(aws.ToString(role.RoleName))
+		if match != nil && !slices.Contains(names, match[1]) {
+			names = append(names, match[1])
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// expandRoleMapping expands role mapping with permission set selector into one role mapping per matched permission set.
+//
+// Parameters:
+// - roleMapping: The role mapping whose permission set is a glob or anchored regex.
+// - awsIAMRoles: The SSO roles retrieved from AWS IAM of all referenced accounts.
+// - accountId: The AWS account ID where this application runs.
+//
+// Returns:
+// - []SSORoleMapping: The role mappings, each with the name of a single matched permission set.
+// - error: An error if selector is not valid or does not match any permission set.
+func (t *translator) expandRoleMapping(roleMapping SSORoleMapping, awsIAMRoles []types.Role, accountId string) ([]SSORoleMapping, error) {
+	if roleMapping.Account != "" {
+		accountId = roleMapping.Account
+	}
+
+	match, err := compilePermissionSetSelector(roleMapping.PermissionSet)
+	if err != nil {
+		return nil, err
+	}
+
+	var expanded []SSORoleMapping
+	for _, name := range t.listPermissionSetNames(rolesInAccount(awsIAMRoles, accountId), accountId) {
+		if !match(name) {
+			continue
+		}
+
+		mapping := roleMapping
+		mapping.PermissionSet = name
+		mapping.Groups = slices.Clone(roleMapping.Groups)
+		expanded = append(expanded, mapping)
+	}
+
+	if len(expanded) == 0 {
+		return nil, fmt.Errorf("permission set selector %s did not match any permission set", roleMapping.PermissionSet)
+	}
+
+	logger.Debug(fmt.Sprintf("Permission set selector %s matched %d permission sets", roleMapping.PermissionSet, len(expanded)))
+	return expanded, nil
+}
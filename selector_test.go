@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+func TestCompilePermissionSetSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		matches  []string
+		rejects  []string
+		wantErr  bool
+	}{
+		{"Glob", "team-*-readonly", []string{"team-a-readonly", "team-data-readonly"}, []string{"team-a-admin", "xteam-a-readonly"}, false},
+		{"Glob with character class", "team-[ab]-readonly", []string{"team-a-readonly"}, []string{"team-c-readonly"}, false},
+		{"Anchored regex", "^team-(a|b)-.+$", []string{"team-a-readonly", "team-b-admin"}, []string{"team-c-admin", "team-a-"}, false},
+		{"Invalid glob", "team-[a", nil, nil, true},
+		{"Invalid regex", "^team-(a$", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !isPermissionSetSelector(tt.selector) {
+				t.Fatalf("isPermissionSetSelector(%s) = false, want true", tt.selector)
+			}
+
+			match, err := compilePermissionSetSelector(tt.selector)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("compilePermissionSetSelector(%s) returned nil, was expecting to get an error", tt.selector)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+			}
+
+			for _, name := range tt.matches {
+				if !match(name) {
+					t.Errorf("Selector %s does not match %s, but should", tt.selector, name)
+				}
+			}
+			for _, name := range tt.rejects {
+				if match(name) {
+					t.Errorf("Selector %s matches %s, but should not", tt.selector, name)
+				}
+			}
+		})
+	}
+
+	if isPermissionSetSelector("team-a_readonly+=,.@") {
+		t.Errorf("Plain permission set name is treated as selector")
+	}
+}
+
+func TestTransformRoleMappingsWithSelector(t *testing.T) {
+	roles := []types.Role{
+		newSSORole("team-a-readonly", "0123456789abcdef"),
+		newSSORole("team-b-readonly", "0123456789abcdef"),
+		newSSORole("team-b-admin", "0123456789abcdef"),
+		newAccountSSORole("111111111111", "team-c-readonly", "0123456789abcdef"),
+	}
+
+	mappings := []SSORoleMapping{
+		{PermissionSet: "team-*-readonly", Username: "$PERMISSIONSET:{{SessionName}}", Groups: []string{"viewers"}},
+		{PermissionSet: "^team-.-readonly$", Account: "111111111111", Username: "$PERMISSIONSET", Groups: []string{"viewers"}},
+		{PermissionSet: "nobody-*", Username: "nobody", Groups: []string{"viewers"}},
+	}
+
+	want := []SSORoleMapping{
+		{RoleARN: "arn:aws:iam::000000000000:role/AWSReservedSSO_team-a-readonly_0123456789abcdef", Username: "team-a-readonly:{{SessionName}}", Groups: []string{"viewers"}},
+		{RoleARN: "arn:aws:iam::000000000000:role/AWSReservedSSO_team-b-readonly_0123456789abcdef", Username: "team-b-readonly:{{SessionName}}", Groups: []string{"viewers"}},
+		{RoleARN: "arn:aws:iam::111111111111:role/AWSReservedSSO_team-c-readonly_0123456789abcdef", Username: "team-c-readonly", Groups: []string{"viewers"}},
+	}
+
+	got := newTranslator(context.Background(), defaultAWSRegion).transformRoleMappings(mappings, roles, "000000000000")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("transformRoleMappings() returned unexpected object: %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newHTTPHandler returns the HTTP handler serving operational endpoints of the application.
+//
+// It takes the maximum time allowed to pass without the scheduler completing a cycle
+// before /healthz endpoint reports the application as not live.
+func newHTTPHandler(livenessMaxAge time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler())
+	mux.Handle("/healthz", probeHandler(func() error { return healthStatus.live(livenessMaxAge) }))
+	mux.Handle("/readyz", probeHandler(healthStatus.ready))
+	return mux
+}
+
+// startHTTPServer starts HTTP server in the background and shuts it down once the context is cancelled.
+//
+// Parameters:
+// - ctx: Context which stops the server once cancelled.
+// - address: The TCP address to listen on, e.g. ":8080".
+// - handler: The HTTP handler serving requests.
+func startHTTPServer(ctx context.Context, address string, handler http.Handler) {
+	server := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serveInBackground(ctx, server, "HTTP", server.ListenAndServe)
+}
+
+// startHTTPSServer starts HTTPS server in the background and shuts it down once the context is cancelled.
+//
+// Certificate and key are read from files on first use, and read again whenever either file changes,
+// so that rotated certificates are served without restarting the application.
+//
+// Parameters:
+// - ctx: Context which stops the server once cancelled.
+// - address: The TCP address to listen on, e.g. ":9443".
+// - certFile: The path of PEM encoded certificate.
+// - keyFile: The path of PEM encoded private key of the certificate.
+// - handler: The HTTP handler serving requests.
+func startHTTPSServer(ctx context.Context, address string, certFile string, keyFile string, handler http.Handler) {
+	certificate := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	server := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certificate.getCertificate,
+		},
+	}
+
+	serveInBackground(ctx, server, "HTTPS", func() error { return server.ListenAndServeTLS("", "") })
+}
+
+// serveInBackground runs serve in the background and shuts the server down once the context is cancelled.
+//
+// Parameters:
+// - ctx: Context which stops the server once cancelled.
+// - server: The server to shut down.
+// - protocol: The protocol of the server, used in log messages.
+// - serve: The function listening on server's address and serving requests until the server is shut down.
+func serveInBackground(ctx context.Context, server *http.Server, protocol string, serve func() error) {
+	go func() {
+		logger.Info(fmt.Sprintf("Starting %s server on %s", protocol, server.Addr))
+		if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error(fmt.Sprintf("%s server failed", protocol), zap.Error(err))
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Error(fmt.Sprintf("Failed to shut down %s server", protocol), zap.Error(err))
+		}
+	}()
+}
+
+// certificateReloader serves TLS certificate read from files, reading them again whenever either file changes.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	modTimes    [2]time.Time
+}
+
+// getCertificate returns the current certificate, and is meant to be used as tls.Config.GetCertificate.
+func (r *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var modTimes [2]time.Time
+	for i, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", file, err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+
+	if r.certificate == nil || modTimes != r.modTimes {
+		certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load certificate: %w", err)
+		}
+		r.certificate, r.modTimes = &certificate, modTimes
+	}
+
+	return r.certificate, nil
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+)
+
+// eventReasonSourceSkipped is the reason of events emitted when a selected source ConfigMap can not be parsed, or maps
+// privileged groups without being allowed to
+const eventReasonSourceSkipped = "SourceSkipped"
+
+// roleMappingSource is a source ConfigMap together with its role mappings
+type roleMappingSource struct {
+	// ConfigMap is the source ConfigMap
+	ConfigMap *v1.ConfigMap
+
+	// RoleMappings are the role mappings of the source ConfigMap, which are replaced with translated ones
+	RoleMappings []SSORoleMapping
+
+	// Dropped are the role mappings of the source ConfigMap removed during translation
+	Dropped []droppedRoleMapping
+}
+
+// sourceName returns the name of ConfigMap prefixed with its namespace, which is used to attribute role mappings to it.
+func sourceName(configMap *v1.ConfigMap) string {
+	return fmt.Sprintf("%s/%s", configMap.Namespace, configMap.Name)
+}
+
+// isPrivilegedGroup checks whether Kubernetes group may only be mapped by source ConfigMaps in platform namespaces.
+// Groups of -admin-groups and groups reserved by Kubernetes, prefixed with "system:", are privileged.
+func isPrivilegedGroup(group string) bool {
+	return strings.HasPrefix(group, "system:") || slices.Contains(splitList(adminGroups), group)
+}
+
+// checkSourcePrivileges checks that source ConfigMap selected by label maps privileged groups, see isPrivilegedGroup,
+// in mapRoles or mapUsers only if it is in one of platform namespaces of the pipeline, so that tenants can not grant
+// themselves admin access to the cluster. Source ConfigMap read by name is defined by the operator and is not checked.
+//
+// Parameters:
+// - p: The pipeline reading source ConfigMap.
+// - configMap: The source ConfigMap.
+//
+// Returns:
+// - error: An error naming privileged groups, or describing why mappings of source ConfigMap can not be parsed.
+func checkSourcePrivileges(p *pipeline, configMap *v1.ConfigMap) error {
+	if p.SourceSelector == "" || slices.Contains(p.PlatformNamespaces, configMap.Namespace) {
+		return nil
+	}
+
+	roleMappings := []SSORoleMapping{}
+	if err := yaml.Unmarshal([]byte(configMap.Data["mapRoles"]), &roleMappings); err != nil {
+		return fmt.Errorf("mapRoles is not valid: %w", err)
+	}
+	userMappings := []SSOUserMapping{}
+	if err := yaml.Unmarshal([]byte(configMap.Data["mapUsers"]), &userMappings); err != nil {
+		return fmt.Errorf("mapUsers is not valid: %w", err)
+	}
+
+	mapped := []string{}
+	for _, mapping := range roleMappings {
+		mapped = append(mapped, mapping.Groups...)
+	}
+	for _, mapping := range userMappings {
+		mapped = append(mapped, mapping.Groups...)
+	}
+
+	privileged := []string{}
+	for _, group := range mapped {
+		if isPrivilegedGroup(group) && !slices.Contains(privileged, group) {
+			privileged = append(privileged, group)
+		}
+	}
+	if len(privileged) > 0 {
+		return fmt.Errorf("groups %s may only be mapped by ConfigMaps in platform namespaces", strings.Join(privileged, ", "))
+	}
+	return nil
+}
+
+// getRoleMappingSources reads source ConfigMaps of the pipeline and unmarshals their role mappings.
+//
+// Without -src-selector, the single source ConfigMap is read and failure to parse it fails reconciliation. With it,
+// all ConfigMaps matching the selector in namespaces of the pipeline are read and sorted by precedence, see
+// sortRoleMappingSources. ConfigMaps which can not be parsed, or which map privileged groups outside of platform
+// namespaces, see checkSourcePrivileges, are skipped with a warning event, so that a mistake of one tenant does not
+// block updates of the others.
+//
+// Parameters:
+// - ctx: Context of the API calls.
+// - clientset: The Kubernetes clientset.
+// - p: The pipeline whose source ConfigMaps are read.
+// - dryRun: Whether role mappings are only previewed, in which case events of skipped ConfigMaps are not emitted.
+//
+// Returns:
+// - []*roleMappingSource: The source ConfigMaps sorted by precedence, from the highest to the lowest.
+// - error: A *ReconcileError if source ConfigMaps could not be read.
+func getRoleMappingSources(ctx context.Context, clientset kubernetes.Interface, p *pipeline, dryRun bool) ([]*roleMappingSource, error) {
+	if p.SourceSelector == "" {
+		// Get name of kubernetes namespace pod is running
+		sourceNamespace, err := p.sourceNamespace()
+		if err != nil {
+			return nil, newReconcileError(StageNamespace, err)
+		}
+
+		// Read configMap template from current namespace which will be transformed
+		configMap, err := getConfigMap(ctx, clientset, p.SourceConfigMap, sourceNamespace)
+		if err != nil {
+			return nil, newReconcileError(StageSourceConfigMap, fmt.Errorf("failed to get configMap %s from namespace %s: %w", p.SourceConfigMap, sourceNamespace, err))
+		}
+
+		// Unmarshal RoleMappings from configMap
+		roleMappings := []SSORoleMapping{}
+		err = yaml.Unmarshal([]byte(configMap.Data["mapRoles"]), &roleMappings)
+		if err != nil {
+			return nil, newReconcileError(StageParseMappings, fmt.Errorf("failed to unmarshal RoleMappings from configMap: %w", err))
+		}
+
+		return []*roleMappingSource{{ConfigMap: configMap, RoleMappings: roleMappings}}, nil
+	}
+
+	logger.Info(fmt.Sprintf("Listing source ConfigMaps matching selector %s in %s", p.SourceSelector, p.describeSelectorNamespaces()))
+
+	sources := []*roleMappingSource{}
+	for _, namespaceName := range p.selectorNamespaces() {
+		configMaps, err := clientset.CoreV1().ConfigMaps(namespaceName).List(ctx, metav1.ListOptions{LabelSelector: p.SourceSelector})
+		if err != nil {
+			return nil, newReconcileError(StageSourceConfigMap, fmt.Errorf("failed to list ConfigMaps matching selector %s in %s: %w", p.SourceSelector, p.describeSelectorNamespaces(), err))
+		}
+
+		for i := range configMaps.Items {
+			configMap := &configMaps.Items[i]
+
+			roleMappings := []SSORoleMapping{}
+			if err := yaml.Unmarshal([]byte(configMap.Data["mapRoles"]), &roleMappings); err != nil {
+				skipSource(clientset, configMap, fmt.Sprintf("mapRoles is not valid: %s", err), dryRun)
+				continue
+			}
+			if err := checkSourcePrivileges(p, configMap); err != nil {
+				skipSource(clientset, configMap, err.Error(), dryRun)
+				continue
+			}
+
+			sources = append(sources, &roleMappingSource{ConfigMap: configMap, RoleMappings: roleMappings})
+		}
+	}
+
+	// Publishing nothing would remove all managed role mappings, which is more likely a mistake than intent
+	if len(sources) == 0 {
+		return nil, newReconcileError(StageSourceConfigMap, fmt.Errorf("no valid ConfigMaps match selector %s", p.SourceSelector))
+	}
+
+	// Namespaces listed by the operator define precedence, which are platform namespaces when all of them are read
+	precedence := p.selectorNamespaces()
+	if p.selectsAllNamespaces() {
+		precedence = p.PlatformNamespaces
+	}
+	sortRoleMappingSources(sources, precedence)
+	logger.Info(fmt.Sprintf("Found %d source ConfigMaps matching selector %s", len(sources), p.SourceSelector))
+	return sources, nil
+}
+
+// skipSource logs why source ConfigMap selected by label is skipped and, unless dry running, emits a warning event on it.
+func skipSource(clientset kubernetes.Interface, configMap *v1.ConfigMap, reason string, dryRun bool) {
+	logger.Warn(fmt.Sprintf("Skipping ConfigMap %s in namespace %s: %s", configMap.Name, configMap.Namespace, reason))
+	if dryRun {
+		return
+	}
+
+	emitEvent(clientset, configMap, v1.EventTypeWarning, eventReasonSourceSkipped, fmt.Sprintf("Role mappings were not published, as %s", reason))
+}
+
+// sortRoleMappingSources sorts source ConfigMaps by precedence: by position of their namespace in the given namespaces,
+// which are configured by the operator, with namespaces not listed there following them, and then by names of
+// namespace and ConfigMap, so that the order does not depend on the order in which they were listed.
+func sortRoleMappingSources(sources []*roleMappingSource, namespaces []string) {
+	position := func(namespaceName string) int {
+		if index := slices.Index(namespaces, namespaceName); index >= 0 {
+			return index
+		}
+		return len(namespaces)
+	}
+
+	sort.SliceStable(sources, func(i, j int) bool {
+		a, b := sources[i].ConfigMap, sources[j].ConfigMap
+		if positionA, positionB := position(a.Namespace), position(b.Namespace); positionA != positionB {
+			return positionA < positionB
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+}
+
+// mergeSourceRoleMappings returns the union of translated role mappings of all source ConfigMaps.
+//
+// Sources must be sorted by precedence. When multiple sources map the same role ARN, only the mapping of the source
+// with the highest precedence is kept and the others are logged together with the ConfigMaps they come from.
+// Identical mappings are kept once. Role mappings of a single source are returned as they are.
+//
+// Parameters:
+// - sources: The source ConfigMaps with translated role mappings, sorted by precedence.
+//
+// Returns:
+// - []SSORoleMapping: The merged role mappings.
+func mergeSourceRoleMappings(sources []*roleMappingSource) []SSORoleMapping {
+	if len(sources) == 1 {
+		return sources[0].RoleMappings
+	}
+
+	merged := []SSORoleMapping{}
+	indexes := map[string]int{}
+	owners := map[string]*roleMappingSource{}
+	for _, source := range sources {
+		for _, mapping := range source.RoleMappings {
+			if mapping.RoleARN == "" {
+				merged = append(merged, mapping)
+				continue
+			}
+
+			owner, ok := owners[mapping.RoleARN]
+			if !ok {
+				indexes[mapping.RoleARN], owners[mapping.RoleARN] = len(merged), source
+				merged = append(merged, mapping)
+				continue
+			}
+
+			if !reflect.DeepEqual(merged[indexes[mapping.RoleARN]], mapping) {
+				logger.Warn(fmt.Sprintf("Role mapping of %s in ConfigMap %s conflicts with the one in ConfigMap %s, which takes precedence, ignoring it", mapping.RoleARN, sourceName(source.ConfigMap), sourceName(owner.ConfigMap)))
+			}
+		}
+	}
+	return merged
+}
+
+// mergeSourceData returns data of all source ConfigMaps, which is copied to destination ConfigMap.
+//
+// Keys defined by a single source are copied as is. Values of keys defined by multiple sources, e.g. mapUsers, are
+// YAML lists which are concatenated in the order of precedence. Values which are not lists are skipped.
+//
+// Parameters:
+// - sources: The source ConfigMaps, sorted by precedence.
+//
+// Returns:
+// - map[string]string: The merged data.
+func mergeSourceData(sources []*roleMappingSource) map[string]string {
+	if len(sources) == 1 {
+		return sources[0].ConfigMap.Data
+	}
+
+	values := map[string][]*roleMappingSource{}
+	for _, source := range sources {
+		for key := range source.ConfigMap.Data {
+			// Role mappings are merged by mergeSourceRoleMappings once they are translated
+			if key != "mapRoles" {
+				values[key] = append(values[key], source)
+			}
+		}
+	}
+
+	data := map[string]string{}
+	for key, defined := range values {
+		if len(defined) == 1 {
+			data[key] = defined[0].ConfigMap.Data[key]
+			continue
+		}
+
+		list := []interface{}{}
+		for _, source := range defined {
+			items := []interface{}{}
+			if err := yaml.Unmarshal([]byte(source.ConfigMap.Data[key]), &items); err != nil {
+				logger.Warn(fmt.Sprintf("Failed to unmarshal %s of ConfigMap %s, skipping it", key, sourceName(source.ConfigMap)), zap.Error(err))
+				continue
+			}
+			list = append(list, items...)
+		}
+
+		encoded, err := yaml.Marshal(list)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Failed to marshal merged %s of source ConfigMaps, skipping it", key), zap.Error(err))
+			continue
+		}
+		data[key] = string(encoded)
+	}
+	return data
+}
+
+// watchSelectedConfigMaps starts an informer on ConfigMaps matching a label selector and sends a signal to trigger
+// whenever any of them is added, changed or deleted, or stops matching the selector.
+//
+// Parameters:
+//   - ctx: Context which stops the informer once cancelled.
+//   - clientset: Kubernetes clientset used to list and watch ConfigMaps.
+//   - selector: The label selector of ConfigMaps to watch.
+//   - namespaceName: The namespace of ConfigMaps to watch, or metav1.NamespaceAll to watch all namespaces.
+//   - trigger: Channel which receives a signal for every observed change, see signalChanges.
+//
+// Returns:
+//   - error: An error if the informer cache fails to sync.
+func watchSelectedConfigMaps(ctx context.Context, clientset kubernetes.Interface, selector string, namespaceName string, trigger chan<- struct{}) error {
+
+	description := "all namespaces"
+	if namespaceName != metav1.NamespaceAll {
+		description = "namespace " + namespaceName
+	}
+	logger.Info(fmt.Sprintf("Watching ConfigMaps matching selector %s in %s for changes", selector, description))
+
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(namespaceName),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.LabelSelector = selector
+		}),
+	)
+
+	return signalChanges(ctx, factory.Core().V1().ConfigMaps().Informer(), fmt.Sprintf("ConfigMaps matching selector %s in %s", selector, description), nil,
+		func(oldObj, newObj interface{}) bool {
+			return oldObj.(*v1.ConfigMap).ResourceVersion != newObj.(*v1.ConfigMap).ResourceVersion
+		},
+		trigger)
+}
+
+// selectsSourceConfigMap checks whether the ConfigMap is a source ConfigMap of the pipeline.
+//
+// Source namespace of the pipeline is resolved the same way as by reconciliation, so that ConfigMaps sharing the
+// name of source ConfigMap in other namespaces are not mistaken for it.
+func selectsSourceConfigMap(p *pipeline, configMap *v1.ConfigMap) bool {
+	if p.SourceSelector == "" {
+		namespaceName, err := p.sourceNamespace()
+		return err == nil && namespaceName == configMap.Namespace && p.SourceConfigMap == configMap.Name
+	}
+	if !p.selectsAllNamespaces() && !slices.Contains(p.selectorNamespaces(), configMap.Namespace) {
+		return false
+	}
+
+	selector, err := labels.Parse(p.SourceSelector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(configMap.Labels))
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newSourceConfigMap returns a ConfigMap selected by "sso-wrapper/source=true" selector
+func newSourceConfigMap(name string, namespace string, data map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"sso-wrapper/source": "true"},
+		},
+		Data: data,
+	}
+}
+
+func TestGetRoleMappingSources(t *testing.T) {
+	adminGroups = "system:masters"
+
+	// Test that ConfigMaps matching the selector are read from allowed namespaces and sorted by their precedence
+	t.Run("Sources selected by label", func(t *testing.T) {
+		clientset := fake.NewClientset(
+			newSourceConfigMap("aws-auth", "team-b", map[string]string{"mapRoles": "- permissionset: dev\n"}),
+			newSourceConfigMap("aws-auth", "team-a", map[string]string{"mapRoles": "- permissionset: dev\n"}),
+			newSourceConfigMap("aws-auth", "platform", map[string]string{"mapRoles": "- permissionset: admin\n  groups:\n  - system:masters\n"}),
+			newSourceConfigMap("broken", "team-c", map[string]string{"mapRoles": "not a list"}),
+			newSourceConfigMap("admin", "team-c", map[string]string{"mapUsers": "- userarn: arn:aws:iam::000000000000:user/admin\n  groups:\n  - system:masters\n"}),
+			newSourceConfigMap("aws-auth", "other", map[string]string{"mapRoles": "- permissionset: dev\n"}),
+			&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unlabelled", Namespace: "team-a"}, Data: map[string]string{"mapRoles": "[]\n"}},
+		)
+		p := &pipeline{
+			SourceSelector:     "sso-wrapper/source=true",
+			SourceNamespaces:   []string{"platform", "team-c", "team-b", "team-a"},
+			PlatformNamespaces: []string{"platform"},
+		}
+
+		sources, err := getRoleMappingSources(context.Background(), clientset, p, false)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		got := []string{}
+		for _, source := range sources {
+			got = append(got, sourceName(source.ConfigMap))
+		}
+		if want := []string{"platform/aws-auth", "team-b/aws-auth", "team-a/aws-auth"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("getRoleMappingSources() returned sources %v, want %v", got, want)
+		}
+		if sources[0].RoleMappings[0].PermissionSet != "admin" {
+			t.Errorf("getRoleMappingSources() returned unexpected role mappings: %+v", sources[0].RoleMappings)
+		}
+
+		// ConfigMaps which can not be parsed, or map privileged groups outside of platform namespaces, are reported with a warning event
+		skipped := []string{}
+		for _, event := range waitForEvents(t, clientset, "team-c", 2) {
+			if event.Reason == eventReasonSourceSkipped {
+				skipped = append(skipped, event.InvolvedObject.Name)
+			}
+		}
+		sort.Strings(skipped)
+		if want := []string{"admin", "broken"}; !reflect.DeepEqual(skipped, want) {
+			t.Errorf("getRoleMappingSources() emitted events on %v, want %v", skipped, want)
+		}
+	})
+
+	// Test that events are not emitted when role mappings are only previewed
+	t.Run("Sources skipped during dry run", func(t *testing.T) {
+		clientset := fake.NewClientset(
+			newSourceConfigMap("aws-auth", "team-a", map[string]string{"mapRoles": "[]\n"}),
+			newSourceConfigMap("broken", "team-a", map[string]string{"mapRoles": "not a list"}),
+		)
+
+		if _, err := getRoleMappingSources(context.Background(), clientset, &pipeline{SourceSelector: "sso-wrapper/source=true", SourceNamespace: "team-a"}, true); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		if events := waitForEvents(t, clientset, "team-a", 0); len(events) != 0 {
+			t.Errorf("getRoleMappingSources() emitted events during dry run: %+v", events)
+		}
+	})
+
+	// Test that source namespace limits the selected ConfigMaps
+	t.Run("Sources selected by label in source namespace", func(t *testing.T) {
+		clientset := fake.NewClientset(
+			newSourceConfigMap("aws-auth", "team-a", map[string]string{"mapRoles": "[]\n"}),
+			newSourceConfigMap("aws-auth", "team-b", map[string]string{"mapRoles": "[]\n"}),
+		)
+
+		sources, err := getRoleMappingSources(context.Background(), clientset, &pipeline{SourceSelector: "sso-wrapper/source=true", SourceNamespace: "team-b"}, false)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if len(sources) != 1 || sources[0].ConfigMap.Namespace != "team-b" {
+			t.Errorf("getRoleMappingSources() returned unexpected sources: %+v", sources)
+		}
+	})
+
+	// Test that ConfigMaps are read from all namespaces when none are defined, platform namespaces taking precedence
+	t.Run("Sources selected by label in all namespaces", func(t *testing.T) {
+		clientset := fake.NewClientset(
+			newSourceConfigMap("aws-auth", "team-b", map[string]string{"mapRoles": "[]\n"}),
+			newSourceConfigMap("aws-auth", "team-a", map[string]string{"mapRoles": "[]\n"}),
+			newSourceConfigMap("aws-auth", "platform", map[string]string{"mapRoles": "[]\n"}),
+		)
+		p := &pipeline{SourceSelector: "sso-wrapper/source=true", PlatformNamespaces: []string{"platform"}}
+
+		sources, err := getRoleMappingSources(context.Background(), clientset, p, false)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		got := []string{}
+		for _, source := range sources {
+			got = append(got, sourceName(source.ConfigMap))
+		}
+		if want := []string{"platform/aws-auth", "team-a/aws-auth", "team-b/aws-auth"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("getRoleMappingSources() returned sources %v, want %v", got, want)
+		}
+	})
+
+	// Test that reconciliation fails when no valid ConfigMap matches the selector
+	t.Run("No sources selected", func(t *testing.T) {
+		clientset := fake.NewClientset(newSourceConfigMap("broken", "team-a", map[string]string{"mapRoles": "not a list"}))
+
+		_, err := getRoleMappingSources(context.Background(), clientset, &pipeline{SourceSelector: "sso-wrapper/source=true", SourceNamespace: "team-a"}, false)
+		if reconcileErr, ok := err.(*ReconcileError); !ok || reconcileErr.Stage != StageSourceConfigMap {
+			t.Errorf("Got unexpected error: %v, was expecting to get error of stage %s", err, StageSourceConfigMap)
+		}
+	})
+
+	// Test that single source ConfigMap is read without selector, and failure to parse it is an error
+	t.Run("Single source", func(t *testing.T) {
+		clientset := fake.NewClientset(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "aws-auth", Namespace: "TEST_NAMESPACE"},
+			Data:       map[string]string{"mapRoles": "not a list"},
+		})
+
+		_, err := getRoleMappingSources(context.Background(), clientset, &pipeline{SourceConfigMap: "aws-auth", SourceNamespace: "TEST_NAMESPACE"}, false)
+		if reconcileErr, ok := err.(*ReconcileError); !ok || reconcileErr.Stage != StageParseMappings {
+			t.Errorf("Got unexpected error: %v, was expecting to get error of stage %s", err, StageParseMappings)
+		}
+	})
+}
+
+func TestSortRoleMappingSources(t *testing.T) {
+	sources := []*roleMappingSource{
+		{ConfigMap: newSourceConfigMap("b", "team-a", nil)},
+		{ConfigMap: newSourceConfigMap("a", "team-b", nil)},
+		{ConfigMap: newSourceConfigMap("d", "team-d", nil)},
+		{ConfigMap: newSourceConfigMap("c", "team-c", nil)},
+		{ConfigMap: newSourceConfigMap("a", "team-a", nil)},
+	}
+
+	sortRoleMappingSources(sources, []string{"team-c", "team-a", "team-b", "team-d"})
+
+	got := []string{}
+	for _, source := range sources {
+		got = append(got, sourceName(source.ConfigMap))
+	}
+	if want := []string{"team-c/c", "team-a/a", "team-a/b", "team-b/a", "team-d/d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sortRoleMappingSources() sorted sources %v, want %v", got, want)
+	}
+
+	// Namespaces which are not listed follow the listed ones, ordered by name
+	sortRoleMappingSources(sources, []string{"team-d"})
+
+	got = []string{}
+	for _, source := range sources {
+		got = append(got, sourceName(source.ConfigMap))
+	}
+	if want := []string{"team-d/d", "team-a/a", "team-a/b", "team-b/a", "team-c/c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sortRoleMappingSources() sorted sources %v, want %v", got, want)
+	}
+}
+
+func TestCheckSourcePrivileges(t *testing.T) {
+	adminGroups = "system:masters,admins"
+	t.Cleanup(func() { adminGroups = "system:masters" })
+
+	p := &pipeline{SourceSelector: "sso-wrapper/source=true", SourceNamespaces: []string{"platform", "team-a"}, PlatformNamespaces: []string{"platform"}}
+
+	tests := []struct {
+		name      string
+		p         *pipeline
+		configMap *v1.ConfigMap
+		wantErr   bool
+	}{
+		{name: "Tenant mapping regular groups", p: p, configMap: newSourceConfigMap("aws-auth", "team-a", map[string]string{"mapRoles": "- permissionset: dev\n  groups:\n  - dev\n"}), wantErr: false},
+		{name: "Tenant mapping admin group", p: p, configMap: newSourceConfigMap("aws-auth", "team-a", map[string]string{"mapRoles": "- permissionset: dev\n  groups:\n  - admins\n"}), wantErr: true},
+		{name: "Tenant mapping system group to user", p: p, configMap: newSourceConfigMap("aws-auth", "team-a", map[string]string{"mapUsers": "- userarn: arn:aws:iam::000000000000:user/node\n  groups:\n  - system:nodes\n"}), wantErr: true},
+		{name: "Tenant with invalid mapUsers", p: p, configMap: newSourceConfigMap("aws-auth", "team-a", map[string]string{"mapUsers": "not a list"}), wantErr: true},
+		{name: "Platform mapping admin group", p: p, configMap: newSourceConfigMap("aws-auth", "platform", map[string]string{"mapRoles": "- permissionset: admin\n  groups:\n  - system:masters\n"}), wantErr: false},
+		{name: "Source ConfigMap read by name", p: &pipeline{SourceConfigMap: "aws-auth"}, configMap: newSourceConfigMap("aws-auth", "team-a", map[string]string{"mapRoles": "- permissionset: admin\n  groups:\n  - system:masters\n"}), wantErr: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := checkSourcePrivileges(tc.p, tc.configMap); (err != nil) != tc.wantErr {
+				t.Errorf("checkSourcePrivileges() returned %v, want error: %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestMergeSourceRoleMappings(t *testing.T) {
+	admin := SSORoleMapping{RoleARN: "arn:aws:iam::000000000000:role/admin", Username: "admin", Groups: []string{"system:masters"}}
+	dev := SSORoleMapping{RoleARN: "arn:aws:iam::000000000000:role/dev", Username: "dev", Groups: []string{"dev"}}
+	devTeamB := SSORoleMapping{RoleARN: "arn:aws:iam::000000000000:role/dev", Username: "dev", Groups: []string{"team-b"}}
+	user := SSORoleMapping{Username: "user", Groups: []string{"view"}}
+
+	// Test that mapping of source with the highest precedence wins conflicts, and identical mappings are kept once
+	t.Run("Multiple sources", func(t *testing.T) {
+		sources := []*roleMappingSource{
+			{ConfigMap: newSourceConfigMap("aws-auth", "platform", nil), RoleMappings: []SSORoleMapping{admin, dev}},
+			{ConfigMap: newSourceConfigMap("aws-auth", "team-a", nil), RoleMappings: []SSORoleMapping{dev, user}},
+			{ConfigMap: newSourceConfigMap("aws-auth", "team-b", nil), RoleMappings: []SSORoleMapping{devTeamB, user}},
+		}
+
+		got := mergeSourceRoleMappings(sources)
+		if want := []SSORoleMapping{admin, dev, user, user}; !reflect.DeepEqual(got, want) {
+			t.Errorf("mergeSourceRoleMappings() = %+v, want %+v", got, want)
+		}
+	})
+
+	// Test that role mappings of a single source are not changed
+	t.Run("Single source", func(t *testing.T) {
+		sources := []*roleMappingSource{
+			{ConfigMap: newSourceConfigMap("aws-auth", "team-a", nil), RoleMappings: []SSORoleMapping{dev, devTeamB}},
+		}
+
+		got := mergeSourceRoleMappings(sources)
+		if want := []SSORoleMapping{dev, devTeamB}; !reflect.DeepEqual(got, want) {
+			t.Errorf("mergeSourceRoleMappings() = %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestMergeSourceData(t *testing.T) {
+	sources := []*roleMappingSource{
+		{ConfigMap: newSourceConfigMap("aws-auth", "platform", map[string]string{
+			"mapRoles":    "- permissionset: admin\n",
+			"mapUsers":    "- userarn: arn:aws:iam::000000000000:user/admin\n  username: admin\n",
+			"mapAccounts": "- \"000000000000\"\n",
+		})},
+		{ConfigMap: newSourceConfigMap("aws-auth", "team-a", map[string]string{
+			"mapRoles": "- permissionset: dev\n",
+			"mapUsers": "- userarn: arn:aws:iam::000000000000:user/dev\n  username: dev\n",
+		})},
+	}
+
+	got := mergeSourceData(sources)
+	want := map[string]string{
+		"mapUsers":    "- userarn: arn:aws:iam::000000000000:user/admin\n  username: admin\n- userarn: arn:aws:iam::000000000000:user/dev\n  username: dev\n",
+		"mapAccounts": "- \"000000000000\"\n",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeSourceData() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSelectsSourceConfigMap(t *testing.T) {
+	t.Setenv("LOCAL_NAMESPACE", "team-a")
+
+	selected := newSourceConfigMap("team-a", "team-a", nil)
+	other := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "aws-auth", Namespace: "team-a"}}
+
+	tests := []struct {
+		name      string
+		p         *pipeline
+		configMap *v1.ConfigMap
+		want      bool
+	}{
+		{name: "Source ConfigMap by name", p: &pipeline{SourceConfigMap: "aws-auth"}, configMap: other, want: true},
+		{name: "Other ConfigMap by name", p: &pipeline{SourceConfigMap: "aws-auth"}, configMap: selected, want: false},
+		{name: "Source ConfigMap in other namespace", p: &pipeline{SourceConfigMap: "aws-auth", SourceNamespace: "team-b"}, configMap: other, want: false},
+		{name: "Same-named ConfigMap outside of pod namespace", p: &pipeline{SourceConfigMap: "aws-auth"}, configMap: &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "aws-auth", Namespace: "team-b"}}, want: false},
+		{name: "Source ConfigMap by selector", p: &pipeline{SourceSelector: "sso-wrapper/source=true", SourceNamespaces: []string{"team-a"}}, configMap: selected, want: true},
+		{name: "Other ConfigMap by selector", p: &pipeline{SourceSelector: "sso-wrapper/source=true", SourceNamespaces: []string{"team-a"}}, configMap: other, want: false},
+		{name: "Source ConfigMap by selector in other namespace", p: &pipeline{SourceSelector: "sso-wrapper/source=true", SourceNamespaces: []string{"team-b"}}, configMap: selected, want: false},
+		{name: "Source ConfigMap by selector in all namespaces", p: &pipeline{SourceSelector: "sso-wrapper/source=true"}, configMap: selected, want: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := selectsSourceConfigMap(tc.p, tc.configMap); got != tc.want {
+				t.Errorf("selectsSourceConfigMap() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWatchSelectedConfigMaps(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clientset := fake.NewClientset(newSourceConfigMap("aws-auth", "team-a", nil))
+	trigger := make(chan struct{}, 1)
+
+	// Test that ConfigMaps created in any namespace trigger reconciliation when all namespaces are watched
+	if err := watchSelectedConfigMaps(ctx, clientset, "sso-wrapper/source=true", metav1.NamespaceAll, trigger); err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+
+	expectTrigger := func(want bool) {
+		t.Helper()
+		select {
+		case <-trigger:
+			if !want {
+				t.Errorf("watchSelectedConfigMaps() sent unexpected signal")
+			}
+		case <-time.After(500 * time.Millisecond):
+			if want {
+				t.Errorf("watchSelectedConfigMaps() did not send a signal, was expecting one")
+			}
+		}
+	}
+	expectTrigger(false)
+
+	if _, err := clientset.CoreV1().ConfigMaps("team-b").Create(ctx, newSourceConfigMap("aws-auth", "team-b", nil), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+	expectTrigger(true)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"go.uber.org/zap"
+)
+
+const (
+	// sqsWaitTimeSeconds is the duration of long polling for messages, which is the maximum allowed by SQS
+	sqsWaitTimeSeconds = 20
+
+	// sqsMaxMessages is the maximum number of messages received at once, which is the maximum allowed by SQS
+	sqsMaxMessages = 10
+
+	// sqsErrorDelay is the delay before receiving messages again after SQS returned an error
+	sqsErrorDelay = 30 * time.Second
+
+	// ssoRolePathPrefix is the path of roles which IAM Identity Center creates for permission sets
+	ssoRolePathPrefix = "/aws-reserved/sso.amazonaws.com/"
+)
+
+// sqsQueueURLRegex extracts region from URL of SQS queue (e.g. "https://sqs.eu-west-1.amazonaws.com/000000000000/queue")
+var sqsQueueURLRegex = regexp.MustCompile(`^https://sqs\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?/`)
+
+// sqsAPI is the subset of SQS API used to consume refresh events.
+type sqsAPI interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+}
+
+// cloudTrailEvent is the part of EventBridge event about an AWS API call recorded by CloudTrail, used to select refresh events
+type cloudTrailEvent struct {
+	Source string `json:"source"`
+	Detail struct {
+		EventSource       string `json:"eventSource"`
+		EventName         string `json:"eventName"`
+		ErrorCode         string `json:"errorCode"`
+		RequestParameters struct {
+			Path     string `json:"path"`
+			RoleName string `json:"roleName"`
+		} `json:"requestParameters"`
+	} `json:"detail"`
+}
+
+// newSQSClient returns SQS client of the region of the queue, optionally sending requests to the given endpoint.
+func newSQSClient(ctx context.Context, queueURL string, endpointURL string) (*sqs.Client, error) {
+	cfg, err := getAWSClientConfig(ctx, defaultAWSRegion)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %w", err)
+	}
+
+	return sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+		if match := sqsQueueURLRegex.FindStringSubmatch(queueURL); match != nil {
+			o.Region = match[1]
+		}
+		if endpointURL != "" {
+			o.BaseEndpoint = aws.String(endpointURL)
+		}
+	}), nil
+}
+
+// isRefreshEvent checks whether message body is an EventBridge event about a change of SSO roles.
+//
+// Creation and deletion of roles under the path of IAM Identity Center, as well as provisioning of permission
+// sets, change ARNs of SSO roles, so role mappings need to be updated.
+//
+// Parameters:
+// - body: The body of SQS message.
+//
+// Returns:
+// - bool: True if role mappings need to be updated.
+// - error: An error if body is not a valid EventBridge event.
+func isRefreshEvent(body string) (bool, error) {
+	event := cloudTrailEvent{}
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		return false, fmt.Errorf("message is not a valid event: %w", err)
+	}
+
+	// Calls which failed did not change anything
+	if event.Detail.ErrorCode != "" {
+		return false, nil
+	}
+
+	switch {
+	case event.Source == "aws.iam" && (event.Detail.EventName == "CreateRole" || event.Detail.EventName == "DeleteRole"):
+		// DeleteRole request does not include path, so role is recognised by its name instead
+		parameters := event.Detail.RequestParameters
+		return strings.HasPrefix(parameters.Path, ssoRolePathPrefix) || strings.HasPrefix(parameters.RoleName, "AWSReservedSSO_"), nil
+	case event.Source == "aws.sso" && event.Detail.EventName == "ProvisionPermissionSet":
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// consumeRefreshEvents receives EventBridge events from SQS queue and triggers update of role mappings for each
+// event about a change of SSO roles, until the context is cancelled.
+//
+// Cached SSO roles are invalidated before triggering the update, so that changed roles are retrieved again.
+// Every received message is deleted, including those which are not refresh events, so that they are not received again.
+//
+// Parameters:
+// - ctx: Context which stops the consumer once cancelled.
+// - client: SQS client.
+// - queueURL: The URL of SQS queue.
+// - trigger: Channel to which update requests are sent.
+func consumeRefreshEvents(ctx context.Context, client sqsAPI, queueURL string, trigger chan<- struct{}) {
+
+	logger.Info(fmt.Sprintf("Consuming refresh events from SQS queue %s", queueURL))
+
+	for ctx.Err() == nil {
+		output, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queueURL),
+			MaxNumberOfMessages: sqsMaxMessages,
+			WaitTimeSeconds:     sqsWaitTimeSeconds,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error(fmt.Sprintf("Failed to receive messages from SQS queue %s", queueURL), zap.Error(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(sqsErrorDelay):
+			}
+			continue
+		}
+
+		for _, message := range output.Messages {
+			refresh, err := isRefreshEvent(aws.ToString(message.Body))
+			if err != nil {
+				logger.Warn(fmt.Sprintf("Ignoring message %s", aws.ToString(message.MessageId)), zap.Error(err))
+			} else if refresh {
+				logger.Info(fmt.Sprintf("Received refresh event %s, updating role mappings", aws.ToString(message.MessageId)))
+				invalidateRoleCache()
+				sendTrigger(trigger)
+			} else {
+				logger.Debug(fmt.Sprintf("Ignoring message %s, as it is not a refresh event", aws.ToString(message.MessageId)))
+			}
+
+			_, err = client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(queueURL),
+				ReceiptHandle: message.ReceiptHandle,
+			})
+			if err != nil && ctx.Err() == nil {
+				logger.Error(fmt.Sprintf("Failed to delete message %s from SQS queue %s", aws.ToString(message.MessageId), queueURL), zap.Error(err))
+			}
+		}
+	}
+}
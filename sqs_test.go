@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// fakeSQSClient is an in-memory stand-in of SQS queue implementing sqsAPI
+type fakeSQSClient struct {
+	mu       sync.Mutex
+	messages []sqstypes.Message
+	deleted  []string
+}
+
+// send adds message with the given body to the queue
+func (c *fakeSQSClient) send(body string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := fmt.Sprintf("message-%d", len(c.messages)+len(c.deleted))
+	c.messages = append(c.messages, sqstypes.Message{MessageId: aws.String(id), ReceiptHandle: aws.String(id), Body: aws.String(body)})
+}
+
+// deletedCount returns number of messages deleted from the queue
+func (c *fakeSQSClient) deletedCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.deleted)
+}
+
+func (c *fakeSQSClient) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	c.mu.Lock()
+	messages := c.messages
+	c.messages = nil
+	c.mu.Unlock()
+
+	// Emulate long polling of an empty queue
+	if len(messages) == 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+}
+
+func (c *fakeSQSClient) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deleted = append(c.deleted, *params.ReceiptHandle)
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+// cloudTrailEventBody returns body of EventBridge event about AWS API call recorded by CloudTrail
+func cloudTrailEventBody(source string, eventName string, requestParameters string) string {
+	return fmt.Sprintf(`{"source":%q,"detail-type":"AWS API Call via CloudTrail","detail":{"eventName":%q,"requestParameters":%s}}`, source, eventName, requestParameters)
+}
+
+func TestIsRefreshEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    bool
+		wantErr bool
+	}{
+		{"SSO role created", cloudTrailEventBody("aws.iam", "CreateRole", `{"path":"/aws-reserved/sso.amazonaws.com/eu-west-1/","roleName":"AWSReservedSSO_devops_0123456789abcdef"}`), true, false},
+		{"SSO role deleted", cloudTrailEventBody("aws.iam", "DeleteRole", `{"roleName":"AWSReservedSSO_devops_0123456789abcdef"}`), true, false},
+		{"Permission set provisioned", cloudTrailEventBody("aws.sso", "ProvisionPermissionSet", `{"targetType":"ALL_PROVISIONED_ACCOUNTS"}`), true, false},
+		{"Other role created", cloudTrailEventBody("aws.iam", "CreateRole", `{"path":"/","roleName":"application"}`), false, false},
+		{"Other IAM call", cloudTrailEventBody("aws.iam", "TagRole", `{"roleName":"AWSReservedSSO_devops_0123456789abcdef"}`), false, false},
+		{"Failed call", `{"source":"aws.sso","detail":{"eventName":"ProvisionPermissionSet","errorCode":"AccessDenied"}}`, false, false},
+		{"Invalid message", "not json", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := isRefreshEvent(tt.body)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("isRefreshEvent() returned nil, was expecting to get an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+			}
+			if got != tt.want {
+				t.Errorf("isRefreshEvent() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsumeRefreshEvents(t *testing.T) {
+	originalTTL := roleCacheTTL
+	t.Cleanup(func() {
+		roleCacheTTL = originalTTL
+		invalidateRoleCache()
+	})
+
+	// Populate role cache, which should be invalidated by refresh event
+	roleCacheTTL = time.Hour
+	cachedSSORoles(localRoleCacheKey(defaultAWSRegion), func() ([]types.Role, error) { return nil, nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := &fakeSQSClient{}
+	client.send(cloudTrailEventBody("aws.iam", "TagRole", `{"roleName":"application"}`))
+	client.send("not json")
+
+	trigger := make(chan struct{}, 1)
+	expectTrigger := func(want bool) {
+		t.Helper()
+		select {
+		case <-trigger:
+			if !want {
+				t.Errorf("consumeRefreshEvents() sent unexpected signal")
+			}
+		case <-time.After(200 * time.Millisecond):
+			if want {
+				t.Errorf("consumeRefreshEvents() did not send a signal, was expecting one")
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		consumeRefreshEvents(ctx, client, "https://sqs.eu-west-1.amazonaws.com/000000000000/queue", trigger)
+		close(done)
+	}()
+
+	waitFor(t, func() bool { return client.deletedCount() == 2 })
+	expectTrigger(false)
+
+	client.send(cloudTrailEventBody("aws.sso", "ProvisionPermissionSet", `{}`))
+	expectTrigger(true)
+	waitFor(t, func() bool { return client.deletedCount() == 3 })
+
+	roleCacheMu.Lock()
+	cached := len(roleCache)
+	roleCacheMu.Unlock()
+	if cached != 0 {
+		t.Errorf("Role cache was not invalidated by refresh event")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("consumeRefreshEvents() did not return after context was cancelled")
+	}
+}
+
+// waitFor waits until condition becomes true, failing the test if it does not within a second
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Condition was not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
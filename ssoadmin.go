@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssoadmin"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+)
+
+// ssoRolePermissionSetNameLength is the maximum length of permission set name included in the name of its role.
+// Longer names are truncated, so that role name fits into 64 characters together with prefix and suffix.
+const ssoRolePermissionSetNameLength = 32
+
+// ssoAdminAPI is the subset of SSO Admin API used to look up permission sets.
+type ssoAdminAPI interface {
+	ssoadmin.ListPermissionSetsAPIClient
+	ssoadmin.ListPermissionSetsProvisionedToAccountAPIClient
+	DescribePermissionSet(ctx context.Context, params *ssoadmin.DescribePermissionSetInput, optFns ...func(*ssoadmin.Options)) (*ssoadmin.DescribePermissionSetOutput, error)
+}
+
+// ssoAdminResolver resolves permission sets using their exact names retrieved from SSO Admin API.
+type ssoAdminResolver struct {
+	// names maps ARNs of all permission sets of the instance to their names
+	names map[string]string
+
+	// provisioned maps account IDs to ARNs of permission sets provisioned to them
+	provisioned map[string][]string
+
+	// accountId is the account of role mappings which do not define one
+	accountId string
+}
+
+// newSSOAdminClient returns SSO Admin client configured for the region of IAM Identity Center, which defaults to the
+// given region of the pipeline.
+func newSSOAdminClient(ctx context.Context, region string) (*ssoadmin.Client, error) {
+	cfg, err := getAWSClientConfig(ctx, region)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %w", err)
+	}
+
+	return ssoadmin.NewFromConfig(cfg, func(o *ssoadmin.Options) {
+		if ssoRegion != "" {
+			o.Region = ssoRegion
+		}
+	}), nil
+}
+
+// loadSSOAdminResolver retrieves permission sets of IAM Identity Center instance and those provisioned to the accounts.
+//
+// Parameters:
+// - ctx: Context of the API calls.
+// - client: SSO Admin client.
+// - instanceARN: The ARN of IAM Identity Center instance.
+// - accountIds: The AWS account IDs whose roles are resolved, starting with the account this application runs in.
+//
+// Returns:
+// - *ssoAdminResolver: The resolver holding retrieved permission sets.
+// - error: An error if permission sets could not be retrieved.
+func loadSSOAdminResolver(ctx context.Context, client ssoAdminAPI, instanceARN string, accountIds []string) (*ssoAdminResolver, error) {
+
+	logger.Info(fmt.Sprintf("Retrieving permission sets of %s from AWS SSO Admin...", instanceARN))
+
+	resolver := &ssoAdminResolver{names: map[string]string{}, provisioned: map[string][]string{}, accountId: accountIds[0]}
+
+	paginator := ssoadmin.NewListPermissionSetsPaginator(client, &ssoadmin.ListPermissionSetsInput{InstanceArn: aws.String(instanceARN)})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list permission sets: %w", err)
+		}
+
+		for _, arn := range output.PermissionSets {
+			// Permission set names rarely change, so they are cached for as long as SSO roles
+			name, err := cachedPermissionSetName(arn, func() (string, error) {
+				described, err := client.DescribePermissionSet(ctx, &ssoadmin.DescribePermissionSetInput{
+					InstanceArn:      aws.String(instanceARN),
+					PermissionSetArn: aws.String(arn),
+				})
+				if err != nil {
+					return "", err
+				}
+				return aws.ToString(described.PermissionSet.Name), nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe permission set %s: %w", arn, err)
+			}
+			resolver.names[arn] = name
+		}
+	}
+
+	logger.Info(fmt.Sprintf("%d permission sets retrieved", len(resolver.names)))
+
+	for _, accountId := range accountIds {
+		provisioned := ssoadmin.NewListPermissionSetsProvisionedToAccountPaginator(client, &ssoadmin.ListPermissionSetsProvisionedToAccountInput{
+			InstanceArn: aws.String(instanceARN),
+			AccountId:   aws.String(accountId),
+		})
+		for provisioned.HasMorePages() {
+			output, err := provisioned.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list permission sets provisioned to account %s: %w", accountId, err)
+			}
+			resolver.provisioned[accountId] = append(resolver.provisioned[accountId], output.PermissionSets...)
+		}
+
+		logger.Info(fmt.Sprintf("%d permission sets provisioned to account %s", len(resolver.provisioned[accountId]), accountId))
+	}
+
+	return resolver, nil
+}
+
+// resolve translates permission set name or ARN of a role mapping into ARN of the role provisioned for it.
+//
+// Unlike translatePermissionSetNameToARN, permission set is matched by its exact name, and role is only
+// resolved when no other permission set provisioned to the account of the mapping shares the same truncated role name.
+//
+// Parameters:
+// - mapping: The role mapping with permission set name or ARN.
+// - iamRoles: The SSO roles retrieved from AWS IAM.
+//
+// Returns:
+// - SSORoleMapping: The role mapping with RoleARN populated and PermissionSet cleared.
+// - error: An error if permission set or its role could not be resolved unambiguously.
+func (r *ssoAdminResolver) resolve(mapping SSORoleMapping, iamRoles []types.Role) (SSORoleMapping, error) {
+
+	logger.Debug(fmt.Sprintf("Resolving %s permission set through AWS SSO Admin", mapping.PermissionSet))
+
+	accountId := r.accountId
+	if mapping.Account != "" {
+		accountId = mapping.Account
+	}
+
+	arn, name := r.find(mapping.PermissionSet)
+	if arn == "" {
+		return mapping, fmt.Errorf("permission set %s not found in AWS SSO Admin", mapping.PermissionSet)
+	}
+	if !slices.Contains(r.provisioned[accountId], arn) {
+		return mapping, fmt.Errorf("permission set %s is not provisioned to account %s", name, accountId)
+	}
+
+	truncated := truncatePermissionSetName(name)
+	for _, other := range r.provisioned[accountId] {
+		if other != arn && truncatePermissionSetName(r.names[other]) == truncated {
+			return mapping, fmt.Errorf("permission set %s can not be told apart from %s, as their role names are truncated to the same %s", name, r.names[other], truncated)
+		}
+	}
+
+	matcher := regexp.MustCompile(fmt.Sprintf("^AWSReservedSSO_%s_[[:alnum:]]{16}$", regexp.QuoteMeta(truncated)))
+	var matches []types.Role
+	for _, role := range iamRoles {
+		if matcher.MatchString(aws.ToString(role.RoleName)) {
+			matches = append(matches, role)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return mapping, fmt.Errorf("role of permission set %s not found in AWS IAM service", name)
+	case 1:
+	default:
+		return mapping, fmt.Errorf("%d roles match permission set %s in AWS IAM service", len(matches), name)
+	}
+
+	logger.Debug(fmt.Sprintf("Found IAM role %s with ARN %s which is provisioned for %s permission set", *matches[0].RoleName, *matches[0].Arn, name))
+	mapping.RoleARN = removePathFromRoleARN(*matches[0].Arn, *matches[0].Path)
+	mapping.PermissionSet = ""
+
+	return mapping, nil
+}
+
+// resolveOrGuess translates permission set of a role mapping into ARN of the role provisioned for it through SSO
+// Admin API, see resolve, and falls back to guessing the role by permission set name, see
+// translatePermissionSetNameToARN, when it can not be resolved that way.
+//
+// Parameters:
+// - mapping: The role mapping with permission set name or ARN.
+// - iamRoles: The SSO roles retrieved from AWS IAM.
+//
+// Returns:
+// - SSORoleMapping: The role mapping with RoleARN populated and PermissionSet cleared.
+// - error: An error if the role could be resolved neither through SSO Admin API nor by permission set name.
+func (r *ssoAdminResolver) resolveOrGuess(mapping SSORoleMapping, iamRoles []types.Role) (SSORoleMapping, error) {
+	resolved, err := r.resolve(mapping, iamRoles)
+	if err == nil {
+		return resolved, nil
+	}
+
+	logger.Warn(fmt.Sprintf("Failed to resolve %s permission set through AWS SSO Admin, matching its role by name instead", mapping.PermissionSet), zap.Error(err))
+	resolved, guessErr := translatePermissionSetNameToARN(mapping, iamRoles)
+	if guessErr != nil {
+		return mapping, fmt.Errorf("%w, and %w", err, guessErr)
+	}
+
+	return resolved, nil
+}
+
+// permissionSetNames returns sorted names of permission sets provisioned to the given account.
+func (r *ssoAdminResolver) permissionSetNames(iamRoles []types.Role, accountId string) []string {
+	if accountId == "" {
+		accountId = r.accountId
+	}
+
+	var names []string
+	for _, arn := range r.provisioned[accountId] {
+		names = append(names, r.names[arn])
+	}
+	slices.Sort(names)
+	return names
+}
+
+// find returns ARN and name of permission set identified by its exact name or ARN, or empty strings if it does not exist.
+func (r *ssoAdminResolver) find(permissionSet string) (string, string) {
+	if name, ok := r.names[permissionSet]; ok {
+		return permissionSet, name
+	}
+
+	for arn, name := range r.names {
+		if name == permissionSet {
+			return arn, name
+		}
+	}
+
+	return "", ""
+}
+
+// truncatePermissionSetName returns permission set name as it is included in the name of its role.
+func truncatePermissionSetName(name string) string {
+	if len(name) > ssoRolePermissionSetNameLength {
+		return name[:ssoRolePermissionSetNameLength]
+	}
+	return name
+}
+
+// useSSOAdminResolver configures resolvePermissionSet and listPermissionSetNames of the translator.
+//
+// When instanceARN is defined, permission sets are resolved through SSO Admin API, see useSSOAdminClient, and
+// otherwise by guessing the role by permission set name.
+//
+// Parameters:
+// - instanceARN: The ARN of IAM Identity Center instance, or empty string to guess roles by name.
+// - accountIds: The AWS account IDs whose roles are resolved, starting with the account this application runs in.
+//
+// Returns:
+// - error: An error if SSO Admin client could not be created.
+func (t *translator) useSSOAdminResolver(instanceARN string, accountIds []string) error {
+	if instanceARN == "" {
+		t.resolvePermissionSet = translatePermissionSetNameToARN
+		t.listPermissionSetNames = ssoRolePermissionSetNames
+		return nil
+	}
+
+	client, err := newSSOAdminClient(t.ctx, t.region)
+	if err != nil {
+		return err
+	}
+
+	t.useSSOAdminClient(client, instanceARN, accountIds)
+	return nil
+}
+
+// useSSOAdminClient configures the translator to resolve permission sets retrieved with the given SSO Admin client.
+//
+// Guessing the role by permission set name stays the fallback: it is used for all role mappings when permission
+// sets could not be retrieved, e.g. when access is denied or requests are throttled, and for role mappings whose
+// permission set could not be resolved through SSO Admin API, see ssoAdminResolver.resolveOrGuess.
+//
+// Parameters:
+// - client: SSO Admin client.
+// - instanceARN: The ARN of IAM Identity Center instance.
+// - accountIds: The AWS account IDs whose roles are resolved, starting with the account this application runs in.
+func (t *translator) useSSOAdminClient(client ssoAdminAPI, instanceARN string, accountIds []string) {
+	resolver, err := loadSSOAdminResolver(t.ctx, client, instanceARN, accountIds)
+	if err != nil {
+		logger.Warn("Failed to retrieve permission sets from AWS SSO Admin, roles are matched by permission set names instead", zap.Error(err))
+		t.resolvePermissionSet = translatePermissionSetNameToARN
+		t.listPermissionSetNames = ssoRolePermissionSetNames
+		return
+	}
+
+	t.resolvePermissionSet = resolver.resolveOrGuess
+	t.listPermissionSetNames = resolver.permissionSetNames
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssoadmin"
+	ssoadmintypes "github.com/aws/aws-sdk-go-v2/service/ssoadmin/types"
+)
+
+// fakeSSOAdminClient is an in-memory implementation of ssoAdminAPI
+type fakeSSOAdminClient struct {
+	// names maps ARNs of permission sets to their names
+	names map[string]string
+
+	// provisioned maps account IDs to ARNs of permission sets provisioned to them
+	provisioned map[string][]string
+
+	// err is returned by every call when defined
+	err error
+}
+
+func (c *fakeSSOAdminClient) ListPermissionSets(ctx context.Context, params *ssoadmin.ListPermissionSetsInput, optFns ...func(*ssoadmin.Options)) (*ssoadmin.ListPermissionSetsOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &ssoadmin.ListPermissionSetsOutput{PermissionSets: sortedKeys(c.names)}, nil
+}
+
+func (c *fakeSSOAdminClient) ListPermissionSetsProvisionedToAccount(ctx context.Context, params *ssoadmin.ListPermissionSetsProvisionedToAccountInput, optFns ...func(*ssoadmin.Options)) (*ssoadmin.ListPermissionSetsProvisionedToAccountOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &ssoadmin.ListPermissionSetsProvisionedToAccountOutput{PermissionSets: c.provisioned[*params.AccountId]}, nil
+}
+
+func (c *fakeSSOAdminClient) DescribePermissionSet(ctx context.Context, params *ssoadmin.DescribePermissionSetInput, optFns ...func(*ssoadmin.Options)) (*ssoadmin.DescribePermissionSetOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &ssoadmin.DescribePermissionSetOutput{PermissionSet: &ssoadmintypes.PermissionSet{
+		Name:             aws.String(c.names[*params.PermissionSetArn]),
+		PermissionSetArn: params.PermissionSetArn,
+	}}, nil
+}
+
+// newSSORole returns IAM role provisioned for permission set with the given name as it appears in role name
+func newSSORole(name string, suffix string) types.Role {
+	return newAccountSSORole("000000000000", name, suffix)
+}
+
+// newAccountSSORole returns IAM role of the given account provisioned for permission set with the given name
+func newAccountSSORole(accountId string, name string, suffix string) types.Role {
+	roleName := "AWSReservedSSO_" + name + "_" + suffix
+	return types.Role{
+		RoleName: aws.String(roleName),
+		Arn:      aws.String("arn:aws:iam::" + accountId + ":role/aws-reserved/sso.amazonaws.com/eu-west-1/" + roleName),
+		Path:     aws.String("/aws-reserved/sso.amazonaws.com/eu-west-1/"),
+	}
+}
+
+func TestSSOAdminResolver(t *testing.T) {
+	const (
+		psDevops    = "arn:aws:sso:::permissionSet/ssoins-0000000000000000/ps-0000000000000001"
+		psDevopsAll = "arn:aws:sso:::permissionSet/ssoins-0000000000000000/ps-0000000000000002"
+		psLongA     = "arn:aws:sso:::permissionSet/ssoins-0000000000000000/ps-0000000000000003"
+		psLongB     = "arn:aws:sso:::permissionSet/ssoins-0000000000000000/ps-0000000000000004"
+		psLongC     = "arn:aws:sso:::permissionSet/ssoins-0000000000000000/ps-0000000000000005"
+		psMissing   = "arn:aws:sso:::permissionSet/ssoins-0000000000000000/ps-0000000000000006"
+	)
+
+	client := &fakeSSOAdminClient{
+		names: map[string]string{
+			psDevops:    "dev+ops",
+			psDevopsAll: "devvops",
+			psLongA:     "ThisIsAVeryLongPermissionSetName-A",
+			psLongB:     "ThisIsAVeryLongPermissionSetName-B",
+			psLongC:     "ThisIsAnotherLongPermissionSetNa-C",
+			psMissing:   "NotProvisioned",
+		},
+		provisioned: map[string][]string{
+			"000000000000": {psDevops, psDevopsAll, psLongA, psLongB, psLongC},
+			"111111111111": {psMissing},
+		},
+	}
+
+	iamRoles := []types.Role{
+		newSSORole("dev+ops", "0123456789abcdef"),
+		newSSORole("devvops", "0123456789abcdef"),
+		newSSORole("ThisIsAVeryLongPermissionSetName", "0123456789abcdef"),
+		newSSORole("ThisIsAVeryLongPermissionSetName", "fedcba9876543210"),
+		newSSORole("ThisIsAnotherLongPermissionSetNa", "0123456789abcdef"),
+		newAccountSSORole("111111111111", "NotProvisioned", "0123456789abcdef"),
+	}
+
+	resolver, err := loadSSOAdminResolver(context.TODO(), client, "arn:aws:sso:::instance/ssoins-0000000000000000", []string{"000000000000", "111111111111"})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+
+	t.Run("Permission set names of account", func(t *testing.T) {
+		want := []string{"ThisIsAVeryLongPermissionSetName-A", "ThisIsAVeryLongPermissionSetName-B", "ThisIsAnotherLongPermissionSetNa-C", "dev+ops", "devvops"}
+		if got := resolver.permissionSetNames(iamRoles, ""); !reflect.DeepEqual(got, want) {
+			t.Errorf("permissionSetNames() = %v, want %v", got, want)
+		}
+	})
+
+	tests := []struct {
+		name          string
+		permissionSet string
+		account       string
+		want          string
+		wantErr       bool
+	}{
+		{"Name with regex metacharacters", "dev+ops", "", "arn:aws:iam::000000000000:role/AWSReservedSSO_dev+ops_0123456789abcdef", false},
+		{"Permission set ARN", psDevopsAll, "", "arn:aws:iam::000000000000:role/AWSReservedSSO_devvops_0123456789abcdef", false},
+		{"Truncated name", "ThisIsAnotherLongPermissionSetNa-C", "", "arn:aws:iam::000000000000:role/AWSReservedSSO_ThisIsAnotherLongPermissionSetNa_0123456789abcdef", false},
+		{"Truncated name shared by another permission set", "ThisIsAVeryLongPermissionSetName-A", "", "", true},
+		{"Permission set not provisioned to account", "NotProvisioned", "", "", true},
+		{"Permission set does not exist", "Missing", "", "", true},
+		{"Permission set provisioned to other account", "NotProvisioned", "111111111111", "arn:aws:iam::111111111111:role/AWSReservedSSO_NotProvisioned_0123456789abcdef", false},
+		{"Permission set not provisioned to other account", "dev+ops", "111111111111", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping := SSORoleMapping{PermissionSet: tt.permissionSet, Account: tt.account, Username: "user", Groups: []string{"group"}}
+
+			got, err := resolver.resolve(mapping, rolesInAccount(iamRoles, tt.account))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("resolve() returned nil, was expecting to get an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+			}
+
+			want := SSORoleMapping{RoleARN: tt.want, Account: tt.account, Username: "user", Groups: []string{"group"}}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("resolve() returned unexpected object: %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestUseSSOAdminClient(t *testing.T) {
+	const (
+		instanceARN = "arn:aws:sso:::instance/ssoins-0000000000000000"
+		psDevops    = "arn:aws:sso:::permissionSet/ssoins-0000000000000000/ps-0000000000000007"
+	)
+
+	iamRoles := []types.Role{newSSORole("devops", "0123456789abcdef")}
+	mapping := SSORoleMapping{PermissionSet: "devops", Username: "user", Groups: []string{"group"}}
+	want := SSORoleMapping{RoleARN: "arn:aws:iam::000000000000:role/AWSReservedSSO_devops_0123456789abcdef", Username: "user", Groups: []string{"group"}}
+
+	tests := []struct {
+		name    string
+		client  *fakeSSOAdminClient
+		mapping SSORoleMapping
+		wantErr bool
+	}{
+		{"SSO Admin API fails", &fakeSSOAdminClient{err: errors.New("AccessDeniedException: not authorized to perform sso:ListPermissionSets")}, mapping, false},
+		{"Permission set not provisioned to account", &fakeSSOAdminClient{names: map[string]string{psDevops: "devops"}}, mapping, false},
+		{"Role of permission set does not exist", &fakeSSOAdminClient{err: errors.New("ThrottlingException: rate exceeded")}, SSORoleMapping{PermissionSet: "missing"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTranslator(context.Background(), defaultAWSRegion)
+			tr.useSSOAdminClient(tt.client, instanceARN, []string{"000000000000"})
+
+			got, err := tr.resolvePermissionSet(tt.mapping, iamRoles)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("resolvePermissionSet() returned nil, was expecting to get an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("resolvePermissionSet() returned unexpected object: %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// eventReasonRoleMappingDropped is the reason of events emitted when a role mapping of source ConfigMap is dropped
+	eventReasonRoleMappingDropped = "RoleMappingDropped"
+
+	// eventReasonDestinationUpdated is the reason of events emitted when role mappings are published
+	eventReasonDestinationUpdated = "DestinationUpdated"
+
+	// eventReasonDestinationUpdateFailed is the reason of events emitted when role mappings could not be published
+	eventReasonDestinationUpdateFailed = "DestinationUpdateFailed"
+)
+
+const (
+	// syncOutcomeUpdated means that role mappings were published
+	syncOutcomeUpdated = "Updated"
+
+	// syncOutcomeUpToDate means that published role mappings were already up to date
+	syncOutcomeUpToDate = "UpToDate"
+
+	// syncOutcomeFailed means that role mappings could not be published
+	syncOutcomeFailed = "Failed"
+
+	// syncOutcomePaused means that reconciliation of destination ConfigMap is paused by rollback subcommand
+	syncOutcomePaused = "Paused"
+)
+
+// droppedRoleMapping describes a role mapping of source ConfigMap which was removed during transformation
+type droppedRoleMapping struct {
+	// PermissionSet is the permission set, or selector, of the dropped role mapping
+	PermissionSet string `json:"permissionSet"`
+
+	// Reason describes why the role mapping was dropped
+	Reason string `json:"reason"`
+
+	// Source is the namespace and name of source ConfigMap of the dropped role mapping
+	Source string `json:"source,omitempty"`
+}
+
+// syncStatus describes the outcome of the last update of role mappings, as published in status ConfigMap
+type syncStatus struct {
+	// LastSyncTime is the time when the last update of role mappings completed
+	LastSyncTime time.Time
+
+	// LastSuccessfulSyncTime is the time when the last successful update of role mappings completed
+	LastSuccessfulSyncTime time.Time
+
+	// Outcome is one of syncOutcomeUpdated, syncOutcomeUpToDate, syncOutcomePaused or syncOutcomeFailed
+	Outcome string
+
+	// ResolvedRoleARNs are the role ARNs of translated role mappings
+	ResolvedRoleARNs []string
+
+	// DroppedRoleMappings are the role mappings removed during transformation
+	DroppedRoleMappings []droppedRoleMapping
+
+	// Errors are the errors which caused the update to fail
+	Errors []string
+}
+
+// dropRoleMapping records a role mapping removed during transformation and logs the reason.
+//
+// Parameters:
+// - permissionSet: The permission set, or selector, of the dropped role mapping.
+// - message: The message logged together with the error.
+// - err: The error which caused the role mapping to be dropped.
+func (t *translator) dropRoleMapping(permissionSet string, message string, err error) {
+	logger.Warn(message, zap.Error(err))
+	t.dropped = append(t.dropped, droppedRoleMapping{PermissionSet: permissionSet, Reason: err.Error()})
+}
+
+// roleMappingARNs returns role ARNs of the given role mappings.
+func roleMappingARNs(mappings []SSORoleMapping) []string {
+	arns := make([]string, 0, len(mappings))
+	for _, mapping := range mappings {
+		arns = append(arns, mapping.RoleARN)
+	}
+	return arns
+}
+
+// recordDroppedRoleMappings emits a warning event on source ConfigMap for every dropped role mapping.
+//
+// Events are only emitted when role mappings dropped from source ConfigMap differ from those reported by the previous
+// reconciliation of the pipeline, so that role mappings which stay unresolvable are not reported again on every
+// retry and interval.
+//
+// Parameters:
+// - clientset: The Kubernetes clientset.
+// - sources: The source ConfigMaps, with role mappings removed from each during transformation.
+func (p *pipeline) recordDroppedRoleMappings(clientset kubernetes.Interface, sources []*roleMappingSource) {
+	reported := make(map[string][]droppedRoleMapping, len(sources))
+	for _, source := range sources {
+		name := sourceName(source.ConfigMap)
+		reported[name] = source.Dropped
+		if slices.Equal(p.reportedDroppedRoleMappings[name], source.Dropped) {
+			continue
+		}
+
+		for _, mapping := range source.Dropped {
+			message := fmt.Sprintf("Role mapping of %s permission set was dropped: %s", mapping.PermissionSet, mapping.Reason)
+			emitEvent(clientset, source.ConfigMap, v1.EventTypeWarning, eventReasonRoleMappingDropped, message)
+		}
+	}
+	p.reportedDroppedRoleMappings = reported
+}
+
+// recordDestinationWrite emits an event on source ConfigMap describing the outcome of publishing role mappings.
+//
+// Parameters:
+// - clientset: The Kubernetes clientset.
+// - source: The source ConfigMap.
+// - destination: The human readable description of where role mappings were published.
+// - count: The number of published role mappings.
+// - err: The error returned while publishing role mappings, or nil if they were published.
+func recordDestinationWrite(clientset kubernetes.Interface, source *v1.ConfigMap, destination string, count int, err error) {
+	eventType, reason, message := v1.EventTypeNormal, eventReasonDestinationUpdated, fmt.Sprintf("Published %d role mappings to %s", count, destination)
+	if err != nil {
+		eventType, reason, message = v1.EventTypeWarning, eventReasonDestinationUpdateFailed, fmt.Sprintf("Failed to publish role mappings to %s: %s", destination, err)
+	}
+
+	emitEvent(clientset, source, eventType, reason, message)
+}
+
+// statusConfigMapData returns data of status ConfigMap describing the given status.
+//
+// Times are formatted as RFC 3339, and lists are encoded as JSON arrays, so they can be read by both humans and tools.
+//
+// Parameters:
+// - status: The status to describe.
+//
+// Returns:
+// - map[string]string: The data of status ConfigMap.
+// - error: An error if lists could not be encoded.
+func statusConfigMapData(status syncStatus) (map[string]string, error) {
+	data := map[string]string{
+		"lastSyncTime": status.LastSyncTime.UTC().Format(time.RFC3339),
+		"outcome":      status.Outcome,
+	}
+	if !status.LastSuccessfulSyncTime.IsZero() {
+		data["lastSuccessfulSyncTime"] = status.LastSuccessfulSyncTime.UTC().Format(time.RFC3339)
+	}
+
+	lists := map[string]interface{}{
+		"resolvedRoleARNs":    status.ResolvedRoleARNs,
+		"droppedRoleMappings": status.DroppedRoleMappings,
+		"errors":              status.Errors,
+	}
+	for key, list := range lists {
+		encoded, err := json.Marshal(list)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s of status: %w", key, err)
+		}
+		if string(encoded) == "null" {
+			encoded = []byte("[]")
+		}
+		data[key] = string(encoded)
+	}
+
+	return data, nil
+}
+
+// publishSyncStatus writes the given status into status ConfigMap using server-side apply.
+//
+// Conflicts with other field managers of status ConfigMap are not overwritten, but returned as errFieldManagerConflict.
+//
+// Parameters:
+// - ctx: Context of the API calls.
+// - clientset: The Kubernetes clientset.
+// - configMapName: The name of status ConfigMap.
+// - namespaceName: The namespace of status ConfigMap.
+// - status: The status to publish.
+//
+// Returns:
+// - error: An error if status ConfigMap could not be written.
+func publishSyncStatus(ctx context.Context, clientset kubernetes.Interface, configMapName string, namespaceName string, status syncStatus) error {
+	data, err := statusConfigMapData(status)
+	if err != nil {
+		return err
+	}
+
+	cm := corev1ac.ConfigMap(configMapName, namespaceName).WithData(data)
+	_, err = clientset.CoreV1().ConfigMaps(namespaceName).Apply(ctx, cm, metav1.ApplyOptions{FieldManager: fieldManager, Force: false})
+	if errors.HasStatusCause(err, metav1.CauseTypeFieldManagerConflict) {
+		return fmt.Errorf("failed to apply status ConfigMap %s in namespace %s: %w: %w", configMapName, namespaceName, errFieldManagerConflict, err)
+	} else if err != nil {
+		return fmt.Errorf("failed to apply status ConfigMap %s in namespace %s: %w", configMapName, namespaceName, err)
+	}
+
+	logger.Debug(fmt.Sprintf("Published status to ConfigMap %s in namespace %s", configMapName, namespaceName))
+	return nil
+}
+
+// completeSyncStatus records the outcome of an update of role mappings into status of the pipeline and publishes it,
+// unless status ConfigMap is disabled. Failures to publish status are logged, as they do not affect role mappings.
+//
+// Parameters:
+// - ctx: Context of the API calls.
+// - p: The pipeline whose role mappings were updated.
+// - err: The error which caused the update to fail, or nil if it succeeded.
+func completeSyncStatus(ctx context.Context, p *pipeline, err error) {
+	now := time.Now()
+	p.status.LastSyncTime = now
+	p.status.Errors = nil
+	if err != nil {
+		p.status.Outcome = syncOutcomeFailed
+		p.status.Errors = []string{err.Error()}
+	} else {
+		p.status.LastSuccessfulSyncTime = now
+	}
+
+	configMapName := p.statusConfigMapName()
+	if configMapName == "" {
+		return
+	}
+
+	clientset, clientErr := getKubernetesClientSet()
+	if clientErr != nil {
+		logger.Warn("Failed to create Kubernetes client, status is not published", zap.Error(clientErr))
+		return
+	}
+
+	namespace, clientErr := p.sourceNamespace()
+	if clientErr != nil {
+		logger.Warn("Failed to determine namespace of status ConfigMap, status is not published", zap.Error(clientErr))
+		return
+	}
+
+	if publishErr := publishSyncStatus(ctx, clientset, configMapName, namespace, p.status); publishErr != nil {
+		logger.Warn("Failed to publish status", zap.Error(publishErr))
+	}
+}
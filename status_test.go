@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTransformRoleMappingsRecordsDropped(t *testing.T) {
+	roles := []types.Role{newSSORole("devops", "0123456789abcdef")}
+	mappings := []SSORoleMapping{
+		{PermissionSet: "devops", Username: "devops", Groups: []string{"viewers"}},
+		{PermissionSet: "missing", Username: "missing", Groups: []string{"viewers"}},
+	}
+
+	tr := newTranslator(context.Background(), defaultAWSRegion)
+	got := tr.transformRoleMappings(mappings, roles, "000000000000")
+
+	if len(got) != 1 {
+		t.Errorf("transformRoleMappings() returned %d role mappings, want %d", len(got), 1)
+	}
+	if len(tr.dropped) != 1 || tr.dropped[0].PermissionSet != "missing" || tr.dropped[0].Reason == "" {
+		t.Errorf("transformRoleMappings() recorded unexpected dropped role mappings: %+v", tr.dropped)
+	}
+}
+
+func TestRecordEvents(t *testing.T) {
+	source := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "aws-auth", Namespace: "TEST_NAMESPACE"}}
+
+	// Test that a warning event is emitted for every dropped role mapping
+	t.Run("Dropped role mappings", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset()
+		dropped := []droppedRoleMapping{
+			{PermissionSet: "missing", Reason: "permission set missing not found in AWS IAM service"},
+			{PermissionSet: "dev-*", Reason: "no permission sets match dev-* selector"},
+		}
+
+		p := &pipeline{}
+		p.recordDroppedRoleMappings(clientset, []*roleMappingSource{{ConfigMap: source, Dropped: dropped}})
+
+		events := waitForEvents(t, clientset, source.Namespace, len(dropped))
+		if len(events) != len(dropped) {
+			t.Fatalf("Expected %d events, got %+v", len(dropped), events)
+		}
+		for _, event := range events {
+			if event.Type != v1.EventTypeWarning || event.Reason != eventReasonRoleMappingDropped || event.InvolvedObject.Name != source.Name {
+				t.Errorf("recordDroppedRoleMappings() emitted unexpected event: %+v", event)
+			}
+		}
+	})
+
+	// Test that events are only emitted when dropped role mappings of source ConfigMap change
+	t.Run("Unchanged dropped role mappings", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset()
+		recorder := useFakeEventRecorder(t, clientset)
+		missing := []droppedRoleMapping{{PermissionSet: "missing", Reason: "permission set missing not found in AWS IAM service"}}
+		renamed := []droppedRoleMapping{{PermissionSet: "renamed", Reason: "permission set renamed not found in AWS IAM service"}}
+
+		p := &pipeline{}
+		for _, tc := range []struct {
+			dropped []droppedRoleMapping
+			events  int
+		}{
+			{dropped: missing, events: 1},
+			{dropped: missing, events: 0},
+			{dropped: renamed, events: 1},
+			{dropped: nil, events: 0},
+			{dropped: renamed, events: 1},
+		} {
+			p.recordDroppedRoleMappings(clientset, []*roleMappingSource{{ConfigMap: source, Dropped: tc.dropped}})
+			if got := len(recorder.Events); got != tc.events {
+				t.Errorf("recordDroppedRoleMappings() emitted %d events for %+v, want %d", got, tc.dropped, tc.events)
+			}
+			for len(recorder.Events) > 0 {
+				<-recorder.Events
+			}
+		}
+	})
+
+	// Test that outcome of publishing role mappings is reported with matching event type
+	for _, tc := range []struct {
+		name      string
+		err       error
+		eventType string
+		reason    string
+	}{
+		{name: "Successful write", err: nil, eventType: v1.EventTypeNormal, reason: eventReasonDestinationUpdated},
+		{name: "Failed write", err: errors.New("conflict"), eventType: v1.EventTypeWarning, reason: eventReasonDestinationUpdateFailed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+
+			recordDestinationWrite(clientset, source, "ConfigMap aws-auth in namespace kube-system", 2, tc.err)
+
+			events := waitForEvents(t, clientset, source.Namespace, 1)
+			if len(events) != 1 || events[0].Type != tc.eventType || events[0].Reason != tc.reason {
+				t.Errorf("recordDestinationWrite() emitted unexpected events: %+v", events)
+			}
+		})
+	}
+}
+
+func TestStatusConfigMapData(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// Test that successful update is described with resolved ARNs and empty lists
+	t.Run("Successful update", func(t *testing.T) {
+		got, err := statusConfigMapData(syncStatus{
+			LastSyncTime:           now,
+			LastSuccessfulSyncTime: now,
+			Outcome:                syncOutcomeUpdated,
+			ResolvedRoleARNs:       []string{"arn:aws:iam::000000000000:role/AWSReservedSSO_devops_0123456789abcdef"},
+		})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		want := map[string]string{
+			"lastSyncTime":           "2024-01-02T03:04:05Z",
+			"lastSuccessfulSyncTime": "2024-01-02T03:04:05Z",
+			"outcome":                syncOutcomeUpdated,
+			"resolvedRoleARNs":       `["arn:aws:iam::000000000000:role/AWSReservedSSO_devops_0123456789abcdef"]`,
+			"droppedRoleMappings":    "[]",
+			"errors":                 "[]",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("statusConfigMapData() = %+v, want %+v", got, want)
+		}
+	})
+
+	// Test that failed update without any successful one is described with errors
+	t.Run("Failed update", func(t *testing.T) {
+		got, err := statusConfigMapData(syncStatus{
+			LastSyncTime:        now,
+			Outcome:             syncOutcomeFailed,
+			DroppedRoleMappings: []droppedRoleMapping{{PermissionSet: "missing", Reason: "not found"}},
+			Errors:              []string{"list-sso-roles: throttled"},
+		})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		want := map[string]string{
+			"lastSyncTime":        "2024-01-02T03:04:05Z",
+			"outcome":             syncOutcomeFailed,
+			"resolvedRoleARNs":    "[]",
+			"droppedRoleMappings": `[{"permissionSet":"missing","reason":"not found"}]`,
+			"errors":              `["list-sso-roles: throttled"]`,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("statusConfigMapData() = %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestPublishSyncStatus(t *testing.T) {
+	clientset := fake.NewClientset()
+	now := time.Now()
+
+	// Publish status twice, so that the second one updates ConfigMap created by the first one
+	statuses := []syncStatus{
+		{LastSyncTime: now, LastSuccessfulSyncTime: now, Outcome: syncOutcomeUpdated},
+		{LastSyncTime: now, LastSuccessfulSyncTime: now, Outcome: syncOutcomeFailed, Errors: []string{"write-destination: conflict"}},
+	}
+	for _, status := range statuses {
+		if err := publishSyncStatus(context.Background(), clientset, "TEST_STATUS", "TEST_NAMESPACE", status); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+	}
+
+	got, err := clientset.CoreV1().ConfigMaps("TEST_NAMESPACE").Get(context.TODO(), "TEST_STATUS", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+
+	want, err := statusConfigMapData(statuses[1])
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+	if !reflect.DeepEqual(got.Data, want) {
+		t.Errorf("publishSyncStatus() wrote unexpected data: %+v, want %+v", got.Data, want)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// templateVariables maps names of template variables to functions returning their values. Values are
+// computed only when a template references the variable, as some of them require calls to AWS APIs.
+type templateVariables map[string]func() (string, error)
+
+// renderTemplate replaces $VAR and ${VAR} placeholders in text with values of the variables.
+//
+// "$$" is replaced with a single "$", while "$" which is not followed by a variable name is kept as is,
+// so that it can still be used to anchor permission set selectors.
+//
+// Parameters:
+// - text: The template to render.
+// - variables: The variables available to the template.
+//
+// Returns:
+// - string: The rendered text.
+// - error: An error if template references unknown variable, or if value of a variable could not be computed.
+func renderTemplate(text string, variables templateVariables) (string, error) {
+	if !strings.Contains(text, "$") {
+		return text, nil
+	}
+
+	var rendered strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '$' || i == len(text)-1 {
+			rendered.WriteByte(text[i])
+			continue
+		}
+
+		var name string
+		switch next := text[i+1]; {
+		case next == '$':
+			rendered.WriteByte('$')
+			i++
+			continue
+		case next == '{':
+			end := strings.IndexByte(text[i+2:], '}')
+			if end == -1 {
+				return "", fmt.Errorf("unterminated variable in %q", text)
+			}
+			name = text[i+2 : i+2+end]
+			if templateVariableNameLength(name) != len(name) || name == "" {
+				return "", fmt.Errorf("invalid variable name %q in %q", name, text)
+			}
+			i += end + 2
+		case templateVariableNameLength(text[i+1:]) > 0:
+			name = text[i+1 : i+1+templateVariableNameLength(text[i+1:])]
+			i += len(name)
+		default:
+			rendered.WriteByte('$')
+			continue
+		}
+
+		value, ok := variables[name]
+		if !ok {
+			return "", fmt.Errorf("unknown variable $%s in %q", name, text)
+		}
+		v, err := value()
+		if err != nil {
+			return "", fmt.Errorf("variable $%s in %q: %w", name, text, err)
+		}
+		rendered.WriteString(v)
+	}
+
+	return rendered.String(), nil
+}
+
+// templateVariableNameLength returns length of the variable name which text starts with, or 0 if it does not start with one.
+func templateVariableNameLength(text string) int {
+	for i, c := range text {
+		if c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return i
+	}
+	return len(text)
+}
+
+// templateVariables returns variables available to templates of role mappings of the given account.
+//
+// PERMISSIONSET and ROLENAME variables are only known once the role of the mapping is resolved, so until
+// then they return an error explaining so.
+//
+// Parameters:
+// - accountId: The AWS account ID of the role mapping.
+// - crossAccount: Whether account differs from the one this application runs in.
+//
+// Returns:
+// - templateVariables: The variables available to templates.
+func (t *translator) templateVariables(accountId string, crossAccount bool) templateVariables {
+	return templateVariables{
+		"ACCOUNTID": templateValue(accountId),
+		"ACCOUNTALIAS": func() (string, error) {
+			return t.cachedAccountAlias(accountId, crossAccount)
+		},
+		"REGION":    templateValue(t.region),
+		"PARTITION": templateValue(getPartition(t.region)),
+		"CLUSTERNAME": func() (string, error) {
+			if eksClusterName == "" {
+				return "", fmt.Errorf("-eks-cluster-name is not defined")
+			}
+			return eksClusterName, nil
+		},
+		"PERMISSIONSET": templateUnavailable("permission set is not known before role of the mapping is resolved"),
+		"ROLENAME":      templateUnavailable("role name is not known before role of the mapping is resolved"),
+	}
+}
+
+// templateValue returns template variable with constant value.
+func templateValue(value string) func() (string, error) {
+	return func() (string, error) { return value, nil }
+}
+
+// templateUnavailable returns template variable which can not be used, failing with the given reason.
+func templateUnavailable(reason string) func() (string, error) {
+	return func() (string, error) { return "", fmt.Errorf("%s", reason) }
+}
+
+// cachedAccountAlias returns alias of the given AWS account, retrieving it on its first use during the translation.
+func (t *translator) cachedAccountAlias(accountId string, crossAccount bool) (string, error) {
+	if alias, ok := t.accountAliases[accountId]; ok {
+		return alias, nil
+	}
+
+	alias, err := t.lookupAccountAlias(t.ctx, t.region, accountId, crossAccount)
+	if err != nil {
+		return "", err
+	}
+	t.accountAliases[accountId] = alias
+
+	return alias, nil
+}
+
+// getAccountAlias retrieves alias of the given AWS account from AWS IAM.
+//
+// Parameters:
+// - ctx: Context of the API call.
+// - region: The region of IAM client.
+// - accountId: The AWS account ID.
+// - crossAccount: Whether reader role of the account must be assumed, as it differs from the one this application runs in.
+//
+// Returns:
+// - string: The alias of the account.
+// - error: An error if account has no alias, or if it could not be retrieved.
+func getAccountAlias(ctx context.Context, region string, accountId string, crossAccount bool) (string, error) {
+	var client iam.ListAccountAliasesAPIClient
+	if crossAccount {
+		crossAccountClient, err := getCrossAccountClient(ctx, region, accountId)
+		if err != nil {
+			return "", err
+		}
+		client = crossAccountClient
+	} else {
+		clients, err := getAWSClients(ctx, region)
+		if err != nil {
+			return "", fmt.Errorf("unable to load SDK config: %w", err)
+		}
+		client = clients.iam
+	}
+
+	output, err := client.ListAccountAliases(ctx, &iam.ListAccountAliasesInput{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list aliases of account %s: %w", accountId, err)
+	}
+	if len(output.AccountAliases) == 0 {
+		return "", fmt.Errorf("account %s has no alias", accountId)
+	}
+
+	logger.Debug(fmt.Sprintf("Retrieved %s as alias of account %s", output.AccountAliases[0], accountId))
+	return output.AccountAliases[0], nil
+}
+
+// roleNameFromARN returns name of the role, without its path, from role ARN.
+func roleNameFromARN(roleARN string) string {
+	return roleARN[strings.LastIndex(roleARN, "/")+1:]
+}
+
+// renderRoleMappingSelector renders templates of Account and PermissionSet fields, which select the role of role mapping.
+//
+// Parameters:
+// - roleMapping: The role mapping to render.
+// - accountId: The AWS account ID where this application runs.
+//
+// Returns:
+// - SSORoleMapping: The role mapping with Account and PermissionSet rendered.
+// - error: An error if any of the templates could not be rendered.
+func (t *translator) renderRoleMappingSelector(roleMapping SSORoleMapping, accountId string) (SSORoleMapping, error) {
+	var err error
+
+	roleMapping.Account, err = renderTemplate(roleMapping.Account, t.templateVariables(accountId, false))
+	if err != nil {
+		return roleMapping, fmt.Errorf("account: %w", err)
+	}
+
+	crossAccount := roleMapping.Account != "" && roleMapping.Account != accountId
+	if crossAccount {
+		accountId = roleMapping.Account
+	}
+
+	roleMapping.PermissionSet, err = renderTemplate(roleMapping.PermissionSet, t.templateVariables(accountId, crossAccount))
+	if err != nil {
+		return roleMapping, fmt.Errorf("permissionset: %w", err)
+	}
+
+	return roleMapping, nil
+}
+
+// renderRoleMappingTemplates renders templates of Username, Groups and UserID fields of resolved role mapping.
+//
+// Parameters:
+// - roleMapping: The role mapping with its role already resolved.
+// - variables: The variables available to templates.
+//
+// Returns:
+// - SSORoleMapping: The role mapping with templates rendered.
+// - error: An error if any of the templates could not be rendered.
+func renderRoleMappingTemplates(roleMapping SSORoleMapping, variables templateVariables) (SSORoleMapping, error) {
+	var err error
+
+	roleMapping.Username, err = renderTemplate(roleMapping.Username, variables)
+	if err != nil {
+		return roleMapping, fmt.Errorf("username: %w", err)
+	}
+
+	roleMapping.UserID, err = renderTemplate(roleMapping.UserID, variables)
+	if err != nil {
+		return roleMapping, fmt.Errorf("userid: %w", err)
+	}
+
+	if roleMapping.Groups != nil {
+		groups := make([]string, len(roleMapping.Groups))
+		for i, group := range roleMapping.Groups {
+			groups[i], err = renderTemplate(group, variables)
+			if err != nil {
+				return roleMapping, fmt.Errorf("groups: %w", err)
+			}
+		}
+		roleMapping.Groups = groups
+	}
+
+	return roleMapping, nil
+}
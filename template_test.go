@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	variables := templateVariables{
+		"ACCOUNTID": templateValue("000000000000"),
+		"ROLENAME":  templateUnavailable("not known yet"),
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{"Text without placeholders", "system:masters", "system:masters", false},
+		{"Placeholder", "arn:aws:iam::$ACCOUNTID:role/admin", "arn:aws:iam::000000000000:role/admin", false},
+		{"Braced placeholder", "${ACCOUNTID}-admin", "000000000000-admin", false},
+		{"Escaped dollar sign", "$$ACCOUNTID", "$ACCOUNTID", false},
+		{"Dollar sign without variable name", "^team-.+$", "^team-.+$", false},
+		{"aws-iam-authenticator placeholder", "admin:{{SessionName}}", "admin:{{SessionName}}", false},
+		{"Unknown variable", "$ACCOUNT_ID", "", true},
+		{"Unterminated variable", "${ACCOUNTID", "", true},
+		{"Invalid variable name", "${ACCOUNT-ID}", "", true},
+		{"Unavailable variable", "$ROLENAME", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderTemplate(tt.template, variables)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("renderTemplate(%s) returned nil, was expecting to get an error", tt.template)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderTemplate(%s) = %s, want %s", tt.template, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformRoleMappingsTemplates(t *testing.T) {
+	originalCluster := eksClusterName
+	t.Cleanup(func() {
+		eksClusterName = originalCluster
+	})
+
+	tr := newTranslator(context.Background(), "eu-west-1")
+	lookups := 0
+	tr.lookupAccountAlias = func(ctx context.Context, region string, accountId string, crossAccount bool) (string, error) {
+		lookups++
+		if accountId == "000000000000" && !crossAccount {
+			return "shared-services", nil
+		}
+		return "", fmt.Errorf("account %s has no alias", accountId)
+	}
+	eksClusterName = "platform"
+
+	roles := []types.Role{newSSORole("devops", "0123456789abcdef")}
+
+	mappings := []SSORoleMapping{
+		{
+			PermissionSet: "devops",
+			Username:      "$PERMISSIONSET:$ROLENAME:{{SessionName}}",
+			Groups:        []string{"${CLUSTERNAME}-admins", "$ACCOUNTALIAS"},
+		},
+		{
+			RoleARN:  "arn:$PARTITION:iam::$ACCOUNTID:role/generic",
+			Username: "$ROLENAME-$REGION-$ACCOUNTALIAS",
+			Groups:   []string{},
+		},
+		{PermissionSet: "devops", Username: "$UNKNOWN", Groups: []string{}},
+		{PermissionSet: "$PERMISSIONSET", Username: "loop", Groups: []string{}},
+		{RoleARN: "arn:aws:iam::111111111111:role/generic", Username: "$ACCOUNTALIAS", Groups: []string{}, Account: "111111111111"},
+	}
+
+	want := []SSORoleMapping{
+		{
+			RoleARN:  "arn:aws:iam::000000000000:role/AWSReservedSSO_devops_0123456789abcdef",
+			Username: "devops:AWSReservedSSO_devops_0123456789abcdef:{{SessionName}}",
+			Groups:   []string{"platform-admins", "shared-services"},
+		},
+		{
+			RoleARN:  "arn:aws:iam::000000000000:role/generic",
+			Username: "generic-eu-west-1-shared-services",
+			Groups:   []string{},
+		},
+	}
+
+	got := tr.transformRoleMappings(mappings, roles, "000000000000")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("transformRoleMappings() returned unexpected object: %+v, want %+v", got, want)
+	}
+	if lookups != 2 {
+		t.Errorf("Account aliases were looked up %d times, want 2", lookups)
+	}
+	if mappings[0].Groups[0] != "${CLUSTERNAME}-admins" {
+		t.Errorf("transformRoleMappings() modified groups of source mapping: %v", mappings[0].Groups)
+	}
+}
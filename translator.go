@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// translator holds state of a single translation of role mappings.
+//
+// Every reconciliation, subcommand and admission review creates its own translator, so that pipelines and admission
+// webhook translate role mappings concurrently without sharing permission set resolvers, account aliases or dropped
+// role mappings.
+type translator struct {
+	// ctx is the context of AWS API calls made during translation
+	ctx context.Context
+
+	// region is the value of REGION template variable and the region of AWS clients used during translation
+	region string
+
+	// resolvePermissionSet translates permission set of a role mapping into ARN of the role provisioned for it.
+	// It is ssoAdminResolver.resolve when SSO Admin API is used, and guesses the role by its name otherwise
+	resolvePermissionSet func(mapping SSORoleMapping, iamRoles []types.Role) (SSORoleMapping, error)
+
+	// listPermissionSetNames returns names of permission sets which roles exist in the given account. It is
+	// ssoAdminResolver.permissionSetNames when SSO Admin API is used, and derives names from role names otherwise
+	listPermissionSetNames func(iamRoles []types.Role, accountId string) []string
+
+	// lookupAccountAlias retrieves alias of the given AWS account, replaced in tests
+	lookupAccountAlias func(ctx context.Context, region string, accountId string, crossAccount bool) (string, error)
+
+	// accountAliases caches aliases of AWS accounts retrieved during the translation, as they may change between translations
+	accountAliases map[string]string
+
+	// dropped are the role mappings removed by the last call of transformRoleMappings
+	dropped []droppedRoleMapping
+}
+
+// newTranslator returns translator which guesses roles of permission sets by their names, until SSO Admin API is
+// configured by useSSOAdminResolver.
+//
+// Parameters:
+// - ctx: Context of AWS API calls made during translation.
+// - region: The value of REGION template variable and the region of AWS clients.
+//
+// Returns:
+// - *translator: The translator.
+func newTranslator(ctx context.Context, region string) *translator {
+	return &translator{
+		ctx:                    ctx,
+		region:                 region,
+		resolvePermissionSet:   translatePermissionSetNameToARN,
+		listPermissionSetNames: ssoRolePermissionSetNames,
+		lookupAccountAlias:     getAccountAlias,
+		accountAliases:         map[string]string{},
+	}
+}
+
+// prepare retrieves SSO roles of this and other accounts referenced by role mappings, and prepares permission set
+// resolvers of the translator.
+//
+// Parameters:
+// - roleMappings: The role mappings to be translated.
+//
+// Returns:
+// - []types.Role: The SSO roles of all accounts referenced by role mappings.
+// - string: The AWS account ID where this application runs.
+// - []string: The IDs of accounts whose permission sets are resolved, starting with the account this application runs in.
+// - error: A *ReconcileError if any of the steps fails.
+func (t *translator) prepare(roleMappings []SSORoleMapping) ([]types.Role, string, []string, error) {
+	// Read all SSO roles from AWS IAM
+	awsIAMRoles, err := listSSORoles(t.ctx, t.region)
+	if err != nil {
+		return nil, "", nil, newReconcileError(StageListRoles, err)
+	}
+
+	// Get AWS Account ID where this application runs on
+	accountId, err := getAccountId(t.ctx, t.region)
+	if err != nil {
+		return nil, "", nil, newReconcileError(StageAccountID, err)
+	}
+
+	// Read SSO roles from AWS IAM of other accounts referenced by role mappings
+	crossAccountRoles, err := listCrossAccountSSORoles(t.ctx, t.region, roleMappings, accountId)
+	if err != nil {
+		return nil, "", nil, newReconcileError(StageListRoles, err)
+	}
+	awsIAMRoles = append(awsIAMRoles, crossAccountRoles...)
+
+	// Resolve permission sets through SSO Admin API when IAM Identity Center instance is defined
+	accounts := append([]string{accountId}, mappingAccounts(roleMappings, accountId)...)
+	if err := t.useSSOAdminResolver(ssoInstanceARN, accounts); err != nil {
+		return nil, "", nil, newReconcileError(StagePermissionSets, err)
+	}
+
+	return awsIAMRoles, accountId, accounts, nil
+}
@@ -18,4 +18,21 @@ type SSORoleMapping struct {
 
 	// UserID is the AWS PrincipalId of the role. (e.g., "ABCXSOTJDDV").
 	UserID string `json:"userid,omitempty" yaml:"userid,omitempty"`
+
+	// Account is the ID of AWS account where PermissionSet is resolved, if it differs
+	// from the account this application runs in. It is not included in the output.
+	Account string `json:"account,omitempty" yaml:"account,omitempty"`
+}
+
+// SSOUserMapping struct defines a userMapping used in aws-auth configMap
+type SSOUserMapping struct {
+	// UserARN is the AWS Resource Name of the IAM user (e.g., "arn:aws:iam::000000000000:user/jane").
+	UserARN string `json:"userarn,omitempty" yaml:"userarn,omitempty"`
+
+	// Username is the username pattern that this user will have in Kubernetes.
+	Username string `json:"username"`
+
+	// Groups is a list of Kubernetes groups this user will authenticate
+	// as (e.g., `system:masters`).
+	Groups []string `json:"groups" yaml:"groups"`
 }
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// webhookValidatePath is the path on which source ConfigMap is validated
+const webhookValidatePath = "/validate"
+
+// errNotInSnapshot marks role mappings which can not be validated, as the last reconciliation of the pipeline did not
+// retrieve what they reference, e.g. roles of an account which was not referenced before.
+var errNotInSnapshot = errors.New("not retrieved by the last reconciliation of the pipeline")
+
+// validationSnapshot holds SSO roles and permission set resolvers of the last reconciliation of a pipeline, so that
+// admission webhook validates source ConfigMaps without calling AWS APIs on every request.
+type validationSnapshot struct {
+	// roles are the SSO roles of all accounts referenced by role mappings
+	roles []types.Role
+
+	// accountId is the AWS account ID where this application runs
+	accountId string
+
+	// accounts are the IDs of accounts whose roles were retrieved, starting with accountId
+	accounts []string
+
+	// resolvePermissionSet and listPermissionSetNames are the resolvers used by the translation
+	resolvePermissionSet   func(mapping SSORoleMapping, iamRoles []types.Role) (SSORoleMapping, error)
+	listPermissionSetNames func(iamRoles []types.Role, accountId string) []string
+
+	// accountAliases are the aliases of AWS accounts retrieved during the translation
+	accountAliases map[string]string
+
+	// taken is the time when the snapshot was taken
+	taken time.Time
+}
+
+// currentValidationSnapshot returns validation snapshot of the pipeline, refreshing it when it is missing or stale.
+var currentValidationSnapshot = refreshValidationSnapshot
+
+// newWebhookHandler returns the HTTP handler serving admission webhook of the application.
+func newWebhookHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(webhookValidatePath, admissionHandler(validateSourceConfigMap))
+	return mux
+}
+
+// admissionHandler returns the HTTP handler which answers AdmissionReview requests using the given validation function.
+//
+// Requests are allowed when validate returns nil, and denied with the returned error as message otherwise.
+// Warnings returned by validate are shown to the client in both cases.
+//
+// Parameters:
+// - validate: The function validating admission request, called with context of the HTTP request.
+//
+// Returns:
+// - http.Handler: The handler of AdmissionReview requests.
+func admissionHandler(validate func(context.Context, *admissionv1.AdmissionRequest) ([]string, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "only POST requests are accepted", http.StatusMethodNotAllowed)
+			return
+		}
+
+		review := admissionv1.AdmissionReview{}
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			http.Error(w, fmt.Sprintf("unable to decode AdmissionReview: %s", err), http.StatusBadRequest)
+			return
+		}
+		if review.Request == nil {
+			http.Error(w, "AdmissionReview has no request", http.StatusBadRequest)
+			return
+		}
+
+		warnings, err := validate(r.Context(), review.Request)
+		response := &admissionv1.AdmissionResponse{
+			UID:      review.Request.UID,
+			Allowed:  err == nil,
+			Warnings: warnings,
+		}
+		if err != nil {
+			logger.Info(fmt.Sprintf("Denied %s of %s %s in namespace %s", review.Request.Operation, review.Request.Kind.Kind, review.Request.Name, review.Request.Namespace), zap.Error(err))
+			response.Result = &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: err.Error(),
+				Reason:  metav1.StatusReasonInvalid,
+				Code:    http.StatusUnprocessableEntity,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(admissionv1.AdmissionReview{TypeMeta: review.TypeMeta, Response: response}); err != nil {
+			logger.Error("Failed to write AdmissionReview response", zap.Error(err))
+		}
+	})
+}
+
+// validateSourceConfigMap validates role mappings of source ConfigMap being created or updated.
+//
+// Requests about objects other than source ConfigMaps of pipelines are allowed, so that webhook can not block
+// unrelated changes when it is configured too broadly.
+//
+// Parameters:
+// - ctx: Context of the admission request.
+// - request: The admission request.
+//
+// Returns:
+// - []string: The warnings to show to the client.
+// - error: An error describing why source ConfigMap is not valid, or nil if it is.
+func validateSourceConfigMap(ctx context.Context, request *admissionv1.AdmissionRequest) ([]string, error) {
+	if request.Kind.Kind != "ConfigMap" || request.Operation == admissionv1.Delete {
+		return nil, nil
+	}
+
+	configMap := v1.ConfigMap{}
+	if err := json.Unmarshal(request.Object.Raw, &configMap); err != nil {
+		return nil, fmt.Errorf("unable to decode ConfigMap: %w", err)
+	}
+	configMap.Name, configMap.Namespace = request.Name, request.Namespace
+
+	p := sourcePipeline(&configMap)
+	if p == nil {
+		return nil, nil
+	}
+	if err := checkSourcePrivileges(p, &configMap); err != nil {
+		return nil, err
+	}
+	if err := validateSourceUserMappings(configMap.Data["mapUsers"]); err != nil {
+		return nil, err
+	}
+
+	return validateSourceRoleMappings(ctx, configMap.Data["mapRoles"], p)
+}
+
+// sourcePipeline returns the pipeline reading the given ConfigMap, or nil if it is not a source ConfigMap.
+//
+// Pipelines without source namespace read the ConfigMap from namespace of the pod, unless they select source
+// ConfigMaps by label.
+func sourcePipeline(configMap *v1.ConfigMap) *pipeline {
+	for _, p := range pipelines {
+		if selectsSourceConfigMap(p, configMap) {
+			return p
+		}
+	}
+	return nil
+}
+
+// validateSourceUserMappings checks that every user mapping in mapUsers of source ConfigMap defines an IAM user ARN.
+//
+// aws-iam-authenticator canonicalizes ARNs of assumed role sessions into ARNs of their roles before looking them up,
+// so user mappings of sessions, e.g. of IAM Identity Center users, are never matched. Sessions of a role are mapped
+// in mapRoles instead, using {{SessionName}} in username to tell them apart.
+//
+// Parameters:
+// - mapUsers: The mapUsers of source ConfigMap.
+//
+// Returns:
+// - error: An error listing all problems of user mappings, or nil if there are none.
+func validateSourceUserMappings(mapUsers string) error {
+	userMappings := []SSOUserMapping{}
+	if err := yaml.Unmarshal([]byte(mapUsers), &userMappings); err != nil {
+		return fmt.Errorf("mapUsers is not valid: %w", err)
+	}
+
+	var problems []string
+	for i, mapping := range userMappings {
+		switch {
+		case mapping.UserARN == "":
+			problems = append(problems, fmt.Sprintf("mapUsers[%d]: userarn must be defined", i))
+		case strings.Contains(mapping.UserARN, ":assumed-role/"):
+			problems = append(problems, fmt.Sprintf("mapUsers[%d]: userarn of assumed role session is never matched, map its role in mapRoles with {{SessionName}} in username instead", i))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("mapUsers is not valid: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// validateSourceRoleMappings checks that mapRoles of source ConfigMap can be translated without dropping any mapping.
+//
+// Every role mapping must define exactly one of rolearn and permissionset, and non-empty username and groups.
+// Permission sets, including selectors and templates, must resolve to SSO roles retrieved by the last reconciliation
+// of the pipeline. Until the pipeline is reconciled, and for accounts or aliases it did not retrieve, only the
+// structure of role mappings is validated and a warning is returned.
+//
+// Parameters:
+// - ctx: Context of the admission request.
+// - mapRoles: The mapRoles of source ConfigMap.
+// - p: The pipeline reading source ConfigMap.
+//
+// Returns:
+// - []string: The warnings to show to the client.
+// - error: An error listing all problems of role mappings, or nil if there are none.
+func validateSourceRoleMappings(ctx context.Context, mapRoles string, p *pipeline) ([]string, error) {
+	roleMappings := []SSORoleMapping{}
+	if err := yaml.Unmarshal([]byte(mapRoles), &roleMappings); err != nil {
+		return nil, fmt.Errorf("mapRoles is not valid: %w", err)
+	}
+
+	var problems []string
+	for i, mapping := range roleMappings {
+		switch {
+		case mapping.RoleARN != "" && mapping.PermissionSet != "":
+			problems = append(problems, fmt.Sprintf("mapRoles[%d]: rolearn and permissionset can not be defined together", i))
+		case mapping.RoleARN == "" && mapping.PermissionSet == "":
+			problems = append(problems, fmt.Sprintf("mapRoles[%d]: one of rolearn and permissionset must be defined", i))
+		}
+		if mapping.Username == "" {
+			problems = append(problems, fmt.Sprintf("mapRoles[%d]: username must not be empty", i))
+		}
+		if len(mapping.Groups) == 0 {
+			problems = append(problems, fmt.Sprintf("mapRoles[%d]: groups must not be empty", i))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("mapRoles is not valid: %s", strings.Join(problems, "; "))
+	}
+
+	snapshot, err := currentValidationSnapshot(ctx, p)
+	if err != nil {
+		logger.Warn("Unable to retrieve SSO roles, permission sets of source ConfigMap are not validated", zap.Error(err))
+		return []string{fmt.Sprintf("permission sets were not validated, as SSO roles could not be retrieved: %s", err)}, nil
+	}
+
+	var warnings []string
+	t := snapshot.translator(ctx, p.AWSRegion)
+	for i, mapping := range roleMappings {
+		if mapping.PermissionSet == "" {
+			continue
+		}
+		err := snapshot.checkRoleMappingResolves(t, mapping)
+		switch {
+		case errors.Is(err, errNotInSnapshot):
+			warnings = append(warnings, fmt.Sprintf("mapRoles[%d]: permission set was not validated, as %s", i, err))
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("mapRoles[%d]: %s", i, err))
+		}
+	}
+	if len(problems) > 0 {
+		return warnings, fmt.Errorf("mapRoles is not valid: %s", strings.Join(problems, "; "))
+	}
+
+	return warnings, nil
+}
+
+// refreshValidationSnapshot returns validation snapshot of the pipeline, taking a new one when the pipeline has none, or
+// when it is older than -interval.
+//
+// Snapshot is taken by every reconciliation, so it is only refreshed here by replicas which do not reconcile the
+// pipeline, e.g. while another replica is the leader, and at most once per interval, so that admission requests do not
+// call AWS APIs. Cross-account roles are retrieved for accounts referenced by current source ConfigMaps.
+//
+// Parameters:
+// - ctx: Context of the admission request.
+// - p: The pipeline reading source ConfigMap.
+//
+// Returns:
+// - *validationSnapshot: The validation snapshot of the pipeline.
+// - error: An error if the pipeline has no snapshot and it could not be taken.
+func refreshValidationSnapshot(ctx context.Context, p *pipeline) (*validationSnapshot, error) {
+	p.validationRefreshMu.Lock()
+	defer p.validationRefreshMu.Unlock()
+
+	snapshot := p.validationSnapshot()
+	if snapshot != nil && time.Since(snapshot.taken) < time.Duration(interval)*time.Second {
+		return snapshot, nil
+	}
+
+	clientset, err := getKubernetesClientSet()
+	if err != nil {
+		return nil, err
+	}
+
+	roleMappings := []SSORoleMapping{}
+	sources, err := getRoleMappingSources(ctx, clientset, p, true)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Unable to read source ConfigMaps of %s, roles of other accounts are not validated", p), zap.Error(err))
+	}
+	for _, source := range sources {
+		roleMappings = append(roleMappings, source.RoleMappings...)
+	}
+
+	t := newTranslator(ctx, p.AWSRegion)
+	roles, accountId, accounts, err := t.prepare(roleMappings)
+	if err != nil {
+		if snapshot != nil {
+			logger.Warn(fmt.Sprintf("Unable to refresh validation snapshot of %s, using the one taken at %s", p, snapshot.taken.Format(time.RFC3339)), zap.Error(err))
+			return snapshot, nil
+		}
+		return nil, err
+	}
+
+	snapshot = t.validationSnapshot(roles, accountId, accounts)
+	p.setValidationSnapshot(snapshot)
+	return snapshot, nil
+}
+
+// validationSnapshot returns the snapshot of SSO roles and resolvers used by the translation.
+//
+// Parameters:
+// - roles: The SSO roles of all accounts referenced by role mappings.
+// - accountId: The AWS account ID where this application runs.
+// - accounts: The IDs of accounts whose roles were retrieved, starting with accountId.
+//
+// Returns:
+// - *validationSnapshot: The snapshot used by admission webhook.
+func (t *translator) validationSnapshot(roles []types.Role, accountId string, accounts []string) *validationSnapshot {
+	return &validationSnapshot{
+		roles:                  roles,
+		accountId:              accountId,
+		accounts:               accounts,
+		resolvePermissionSet:   t.resolvePermissionSet,
+		listPermissionSetNames: t.listPermissionSetNames,
+		accountAliases:         maps.Clone(t.accountAliases),
+		taken:                  time.Now(),
+	}
+}
+
+// translator returns translator which resolves permission sets using the snapshot only.
+func (s *validationSnapshot) translator(ctx context.Context, region string) *translator {
+	t := newTranslator(ctx, region)
+	t.resolvePermissionSet = s.resolvePermissionSet
+	t.listPermissionSetNames = s.listPermissionSetNames
+	t.lookupAccountAlias = func(ctx context.Context, region string, accountId string, crossAccount bool) (string, error) {
+		if alias, ok := s.accountAliases[accountId]; ok {
+			return alias, nil
+		}
+		return "", fmt.Errorf("alias of account %s was %w", accountId, errNotInSnapshot)
+	}
+	return t
+}
+
+// checkRoleMappingResolves resolves a role mapping the same way as transformRoleMappings does, and returns an error
+// if it would be dropped.
+//
+// Parameters:
+// - t: The translator returned by translator method of the snapshot.
+// - mapping: The role mapping of source ConfigMap.
+//
+// Returns:
+// - error: An error if the role mapping can not be resolved, wrapping errNotInSnapshot if it can not be validated.
+func (s *validationSnapshot) checkRoleMappingResolves(t *translator, mapping SSORoleMapping) error {
+	mapping, err := t.renderRoleMappingSelector(mapping, s.accountId)
+	if err != nil {
+		return err
+	}
+	if mapping.Account != "" && !slices.Contains(s.accounts, mapping.Account) {
+		return fmt.Errorf("roles of account %s were %w", mapping.Account, errNotInSnapshot)
+	}
+
+	expanded := []SSORoleMapping{mapping}
+	if isPermissionSetSelector(mapping.PermissionSet) {
+		expanded, err = t.expandRoleMapping(mapping, s.roles, s.accountId)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, mapping := range expanded {
+		if _, err := t.resolveRoleMapping(mapping, s.roles, s.accountId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
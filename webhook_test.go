@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// useValidationPipeline defines a single pipeline, whose validation snapshot holds the given SSO roles, for the
+// duration of the test. Snapshots are never refreshed, so pipelines without one fail to retrieve SSO roles.
+func useValidationPipeline(t *testing.T, roles []types.Role) *pipeline {
+	originalPipelines, originalSnapshot := pipelines, currentValidationSnapshot
+	t.Cleanup(func() {
+		pipelines, currentValidationSnapshot = originalPipelines, originalSnapshot
+	})
+
+	currentValidationSnapshot = func(ctx context.Context, p *pipeline) (*validationSnapshot, error) {
+		if snapshot := p.validationSnapshot(); snapshot != nil {
+			return snapshot, nil
+		}
+		return nil, errors.New("throttled")
+	}
+
+	p := &pipeline{SourceConfigMap: "aws-auth", SourceNamespace: "TEST_NAMESPACE"}
+	p.setValidationSnapshot(newTranslator(context.Background(), "").validationSnapshot(roles, "000000000000", []string{"000000000000"}))
+	pipelines = []*pipeline{p}
+	return p
+}
+
+func TestValidateSourceRoleMappings(t *testing.T) {
+	p := useValidationPipeline(t, []types.Role{newSSORole("devops", "0123456789abcdef"), newSSORole("dev-readonly", "abcdef0123456789")})
+
+	tests := []struct {
+		name     string
+		mapRoles string
+		wantErr  string
+	}{
+		{
+			name:     "Valid role mappings",
+			mapRoles: "- permissionset: devops\n  username: devops\n  groups: [viewers]\n- permissionset: dev-*\n  username: $PERMISSIONSET\n  groups: [viewers]\n- rolearn: arn:aws:iam::000000000000:role/admin\n  username: admin\n  groups: [system:masters]\n",
+		},
+		{
+			name:     "Invalid YAML",
+			mapRoles: "- permissionset: devops\n username: devops\n",
+			wantErr:  "mapRoles is not valid: yaml:",
+		},
+		{
+			name:     "Both rolearn and permissionset",
+			mapRoles: "- permissionset: devops\n  rolearn: arn:aws:iam::000000000000:role/admin\n  username: devops\n  groups: [viewers]\n",
+			wantErr:  "mapRoles[0]: rolearn and permissionset can not be defined together",
+		},
+		{
+			name:     "Neither rolearn nor permissionset",
+			mapRoles: "- username: devops\n  groups: [viewers]\n",
+			wantErr:  "mapRoles[0]: one of rolearn and permissionset must be defined",
+		},
+		{
+			name:     "Empty username and groups",
+			mapRoles: "- permissionset: devops\n  groups: [viewers]\n- permissionset: devops\n  username: devops\n",
+			wantErr:  "mapRoles[0]: username must not be empty; mapRoles[1]: groups must not be empty",
+		},
+		{
+			name:     "Permission set does not exist",
+			mapRoles: "- permissionset: devops\n  username: devops\n  groups: [viewers]\n- permissionset: missing\n  username: missing\n  groups: [viewers]\n",
+			wantErr:  "mapRoles[1]: permission set missing not found in AWS IAM service",
+		},
+		{
+			name:     "Selector matches no permission sets",
+			mapRoles: "- permissionset: prod-*\n  username: prod\n  groups: [viewers]\n",
+			wantErr:  "mapRoles[0]:",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			warnings, err := validateSourceRoleMappings(context.Background(), tc.mapRoles, p)
+
+			if len(warnings) != 0 {
+				t.Errorf("validateSourceRoleMappings() returned unexpected warnings: %v", warnings)
+			}
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("Got unexpected error: %v, was expecting to get error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+
+	// Test that structure is still validated, and a warning is returned, when SSO roles can not be retrieved
+	t.Run("SSO roles can not be retrieved", func(t *testing.T) {
+		warnings, err := validateSourceRoleMappings(context.Background(), "- permissionset: missing\n  username: missing\n  groups: [viewers]\n", &pipeline{})
+		if err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if len(warnings) != 1 {
+			t.Errorf("validateSourceRoleMappings() returned %d warnings, want %d", len(warnings), 1)
+		}
+	})
+
+	// Test that permission sets of accounts and aliases which were not retrieved by reconciliation are not rejected
+	t.Run("Not in snapshot", func(t *testing.T) {
+		mapRoles := "- permissionset: devops\n  account: \"111111111111\"\n  username: devops\n  groups: [viewers]\n- permissionset: devops\n  username: $ACCOUNTALIAS\n  groups: [viewers]\n"
+		warnings, err := validateSourceRoleMappings(context.Background(), mapRoles, p)
+		if err != nil {
+			t.Errorf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if len(warnings) != 2 || !strings.Contains(warnings[0], "roles of account 111111111111") || !strings.Contains(warnings[1], "alias of account 000000000000") {
+			t.Errorf("validateSourceRoleMappings() returned unexpected warnings: %v", warnings)
+		}
+	})
+}
+
+func TestRefreshValidationSnapshot(t *testing.T) {
+	original := interval
+	t.Cleanup(func() { interval = original })
+	interval = 60
+
+	// Test that snapshot younger than interval is used as is, without calling AWS APIs
+	p := &pipeline{}
+	snapshot := newTranslator(context.Background(), "").validationSnapshot(nil, "000000000000", []string{"000000000000"})
+	p.setValidationSnapshot(snapshot)
+
+	got, err := refreshValidationSnapshot(context.Background(), p)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+	}
+	if got != snapshot {
+		t.Errorf("refreshValidationSnapshot() returned a new snapshot, was expecting to get the current one")
+	}
+}
+
+func TestValidateSourceUserMappings(t *testing.T) {
+	tests := []struct {
+		name     string
+		mapUsers string
+		wantErr  bool
+	}{
+		{name: "Valid user mappings", mapUsers: "- userarn: arn:aws:iam::000000000000:user/admin\n  username: admin\n  groups: [system:masters]\n"},
+		{name: "No user mappings", mapUsers: ""},
+		{name: "Invalid YAML", mapUsers: "not a list", wantErr: true},
+		{name: "Missing userarn", mapUsers: "- ssouser: jane@example.com\n  username: jane\n", wantErr: true},
+		{name: "Assumed role session", mapUsers: "- userarn: arn:aws:sts::000000000000:assumed-role/AWSReservedSSO_devops_0123456789abcdef/jane\n  username: jane\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSourceUserMappings(tt.mapUsers)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateSourceUserMappings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAdmissionHandler(t *testing.T) {
+	useValidationPipeline(t, []types.Role{newSSORole("devops", "0123456789abcdef")})
+	pipelines = append(pipelines, &pipeline{SourceSelector: "sso-wrapper/source=true", SourceNamespaces: []string{"team-a"}})
+	handler := newWebhookHandler()
+
+	// review sends AdmissionReview about the given ConfigMap and returns the response
+	review := func(t *testing.T, configMap *v1.ConfigMap) *admissionv1.AdmissionResponse {
+		raw, err := json.Marshal(configMap)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		body, err := json.Marshal(admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+			Request: &admissionv1.AdmissionRequest{
+				UID:       "TEST_UID",
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+				Name:      configMap.Name,
+				Namespace: configMap.Namespace,
+				Operation: admissionv1.Update,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, webhookValidatePath, bytes.NewReader(body)))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Handler returned status %d, want %d", recorder.Code, http.StatusOK)
+		}
+
+		got := admissionv1.AdmissionReview{}
+		if err := json.NewDecoder(recorder.Body).Decode(&got); err != nil {
+			t.Fatalf("Got unexpected error: %s, was expecting to get nil", err)
+		}
+		if got.Kind != "AdmissionReview" || got.Response == nil || got.Response.UID != "TEST_UID" {
+			t.Fatalf("Handler returned unexpected AdmissionReview: %+v", got)
+		}
+		return got.Response
+	}
+
+	tests := []struct {
+		name        string
+		configMap   *v1.ConfigMap
+		wantAllowed bool
+	}{
+		{
+			name: "Valid source ConfigMap",
+			configMap: &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "aws-auth", Namespace: "TEST_NAMESPACE"},
+				Data:       map[string]string{"mapRoles": "- permissionset: devops\n  username: devops\n  groups: [viewers]\n"},
+			},
+			wantAllowed: true,
+		},
+		{
+			name: "Invalid source ConfigMap",
+			configMap: &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "aws-auth", Namespace: "TEST_NAMESPACE"},
+				Data:       map[string]string{"mapRoles": "- permissionset: missing\n  username: missing\n  groups: [viewers]\n"},
+			},
+			wantAllowed: false,
+		},
+		{
+			name: "Other ConfigMap",
+			configMap: &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "TEST_NAMESPACE"},
+				Data:       map[string]string{"mapRoles": "not a list"},
+			},
+			wantAllowed: true,
+		},
+		{
+			name: "Tenant ConfigMap mapping privileged group",
+			configMap: newSourceConfigMap("aws-auth", "team-a", map[string]string{
+				"mapRoles": "- permissionset: devops\n  username: devops\n  groups: [system:masters]\n",
+			}),
+			wantAllowed: false,
+		},
+		{
+			name: "ConfigMap of other namespace",
+			configMap: &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "aws-auth", Namespace: "kube-system"},
+				Data:       map[string]string{"mapRoles": "not a list"},
+			},
+			wantAllowed: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			response := review(t, tc.configMap)
+
+			if response.Allowed != tc.wantAllowed {
+				t.Errorf("Handler allowed request: %t, want %t (%+v)", response.Allowed, tc.wantAllowed, response.Result)
+			}
+			if !tc.wantAllowed && (response.Result == nil || response.Result.Message == "") {
+				t.Errorf("Handler denied request without a message")
+			}
+		})
+	}
+
+	// Test that requests other than AdmissionReview are rejected
+	t.Run("Invalid request", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, webhookValidatePath, strings.NewReader("{")))
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("Handler returned status %d, want %d", recorder.Code, http.StatusBadRequest)
+		}
+	})
+}